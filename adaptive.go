@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadCheckInterval is how often adaptive_concurrency samples the system load
+const loadCheckInterval = 5 * time.Second
+
+// loadRecoveryRatio is the fraction of the load threshold the load has to
+// drop below before a worker is let back in, so the limit doesn't flap
+// around the threshold
+const loadRecoveryRatio = 0.8
+
+// loadSource returns the current system load
+type loadSource func() (float64, error)
+
+// readLoadAvg returns the one-minute load average from /proc/loadavg, which
+// only exists on Linux
+func readLoadAvg() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// loadThreshold returns adaptive_load_threshold, defaulting to the number of
+// CPUs
+func loadThreshold(configured float64) float64 {
+	if configured > 0 {
+		return configured
+	}
+	return float64(runtime.NumCPU())
+}
+
+// loadGate limits how many workers process a repository at once, between one
+// and the configured concurrency, following the system load. Workers hold a
+// slot while they work; to shed a worker the gate keeps a slot to itself,
+// and hands it back once the load has dropped. A nil gate lets every worker
+// through.
+type loadGate struct {
+	slots     chan struct{}
+	held      int // Slots withheld from the workers
+	max       int
+	threshold float64
+	load      loadSource
+}
+
+// newLoadGate returns a gate for max workers that sheds them while load
+// exceeds threshold
+func newLoadGate(max int, threshold float64, load loadSource) *loadGate {
+	g := &loadGate{slots: make(chan struct{}, max), max: max, threshold: threshold, load: load}
+	for i := 0; i < max; i++ {
+		g.slots <- struct{}{}
+	}
+	return g
+}
+
+// acquire waits for a free slot
+func (g *loadGate) acquire(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	select {
+	case <-g.slots:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire
+func (g *loadGate) release() {
+	if g == nil {
+		return
+	}
+	g.slots <- struct{}{}
+}
+
+// adjust sheds one worker if the load is above the threshold and lets one
+// back in once it is well below it. It never sheds the last worker.
+func (g *loadGate) adjust() error {
+	load, err := g.load()
+	if err != nil {
+		return err
+	}
+	switch {
+	case load > g.threshold && g.held < g.max-1:
+		select {
+		case <-g.slots:
+			g.held++
+			log.Printf("🐌 Load %.2f exceeds %.2f, reducing concurrency to %d", load, g.threshold, g.max-g.held)
+		default:
+			// All slots are busy; try again on the next check
+		}
+	case load < g.threshold*loadRecoveryRatio && g.held > 0:
+		g.held--
+		g.slots <- struct{}{}
+		log.Printf("🐇 Load %.2f is below %.2f again, raising concurrency to %d", load, g.threshold, g.max-g.held)
+	}
+	return nil
+}
+
+// run adjusts the gate every interval until ctx is done. If the load can't be
+// read, e.g. off Linux, concurrency stays as configured.
+func (g *loadGate) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.adjust(); err != nil {
+				log.Printf("⚠️  Warning: Can't read the system load, keeping concurrency fixed: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fixedLoad is a load source reporting whatever *load is set to
+func fixedLoad(load *float64) loadSource {
+	return func() (float64, error) { return *load, nil }
+}
+
+func TestLoadGateFollowsLoad(t *testing.T) {
+	load := 0.0
+	g := newLoadGate(3, 4, fixedLoad(&load))
+	adjust := func(times int) {
+		t.Helper()
+		for i := 0; i < times; i++ {
+			if err := g.adjust(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Above the threshold a worker is shed per check, but never the last one
+	load = 6
+	adjust(3)
+	if free := len(g.slots); free != 1 || g.held != 2 {
+		t.Errorf("under load: %d free slots and %d held, want 1 and 2", free, g.held)
+	}
+
+	// Between the recovery level and the threshold nothing changes
+	load = 3.5
+	adjust(1)
+	if g.held != 2 {
+		t.Errorf("near the threshold: %d held, want 2", g.held)
+	}
+
+	// Well below it the workers come back one per check
+	load = 1
+	adjust(1)
+	if free := len(g.slots); free != 2 || g.held != 1 {
+		t.Errorf("after one low check: %d free slots and %d held, want 2 and 1", free, g.held)
+	}
+	adjust(2)
+	if free := len(g.slots); free != 3 || g.held != 0 {
+		t.Errorf("recovered: %d free slots and %d held, want 3 and 0", free, g.held)
+	}
+}
+
+func TestLoadGateWaitsForBusySlots(t *testing.T) {
+	load := 10.0
+	g := newLoadGate(2, 4, fixedLoad(&load))
+	for i := 0; i < 2; i++ {
+		if err := g.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every worker is busy, so there is no slot to take away yet
+	if err := g.adjust(); err != nil {
+		t.Fatal(err)
+	}
+	if g.held != 0 {
+		t.Fatalf("%d held while all workers were busy, want 0", g.held)
+	}
+	g.release()
+	if err := g.adjust(); err != nil {
+		t.Fatal(err)
+	}
+	if g.held != 1 || len(g.slots) != 0 {
+		t.Errorf("after a release: %d held and %d free slots, want 1 and 0", g.held, len(g.slots))
+	}
+}
+
+func TestLoadGateUnreadableLoad(t *testing.T) {
+	g := newLoadGate(2, 4, func() (float64, error) { return 0, errors.New("no /proc/loadavg") })
+	if err := g.adjust(); err == nil {
+		t.Error("expected the load error to be returned")
+	}
+	if len(g.slots) != 2 {
+		t.Errorf("%d free slots, want concurrency left at 2", len(g.slots))
+	}
+
+	// Without adaptive_concurrency there is no gate and workers pass freely
+	var none *loadGate
+	if err := none.acquire(context.Background()); err != nil {
+		t.Error(err)
+	}
+	none.release()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"roller/config"
+	"roller/retry"
+	"roller/runner"
+)
+
+// defaultAnsibleRetryDelay is the pause between Ansible attempts when
+// ansible_retry_delay is not set
+const defaultAnsibleRetryDelay = 10 * time.Second
+
+// defaultPlaybook is run when neither the config nor the repository names
+// any playbooks
+var defaultPlaybook = filepath.Join("ansible", "site.yml")
+
+// ansibleOutput keeps the output of concurrent Ansible runs apart
+var ansibleOutput = runner.NewMultiplexer(os.Stdout)
+
+// ansibleOutputMode returns ansible_output, defaulting to line-prefixed
+// output when repositories are processed concurrently
+func ansibleOutputMode(cfg *config.Config) string {
+	switch {
+	case cfg.AnsibleOutput != "":
+		return cfg.AnsibleOutput
+	case cfg.Concurrency > 1:
+		return config.AnsibleOutputLine
+	}
+	return config.AnsibleOutputStream
+}
+
+// playbooks returns the playbooks to run for proj, in order: the repository's
+// own list if it has one, else the global one, else the default playbook
+func playbooks(cfg *config.Config, proj config.RepoSpec) []string {
+	if len(proj.AnsiblePlaybook) > 0 {
+		return proj.AnsiblePlaybook
+	}
+	if len(cfg.AnsiblePlaybook) > 0 {
+		return cfg.AnsiblePlaybook
+	}
+	return []string{defaultPlaybook}
+}
+
+// repoPathsVar is the extra-var listing the clone directories a playbook run
+// is for; site.yml only scans repos/ when it isn't set
+const repoPathsVar = "roller_repo_paths"
+
+// repoPathsExtraVars encodes dirs, made absolute, as the roller_repo_paths
+// extra-var
+func repoPathsExtraVars(dirs []string) (string, error) {
+	abs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		p, err := filepath.Abs(dir)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+		}
+		abs[i] = p
+	}
+	vars, err := json.Marshal(map[string][]string{repoPathsVar: abs})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", repoPathsVar, err)
+	}
+	return string(vars), nil
+}
+
+// runAnsiblePlaybook runs the playbooks for repoPath in order, retrying the
+// whole chain up to cfg.AnsibleRetries times on failure. The playbooks are
+// given destDir in roller_repo_paths so they only touch this clone, which is
+// reset before each retry so a half-applied attempt doesn't leak into the
+// next one. A tree that already had changes before the first attempt, e.g. a
+// reused clone under allow_dirty or a -local-dir checkout, is never reset,
+// since that would throw away the user's own work. prepare, if non-nil, runs
+// before every attempt, after the reset, to redo changes roller itself makes
+// to the tree, such as bumped dependency files.
+func runAnsiblePlaybook(ctx context.Context, r runner.Runner, cfg *config.Config, proj config.RepoSpec, destDir string, prepare func() error) error {
+	repoPath := proj.RepoPath
+	env := ansibleEnv(cfg, proj)
+	chain := playbooks(cfg, proj)
+	vars, err := repoPathsExtraVars([]string{destDir})
+	if err != nil {
+		return err
+	}
+	delay := cfg.AnsibleRetryDelay
+	if delay == 0 {
+		delay = defaultAnsibleRetryDelay
+	}
+
+	attempts := cfg.AnsibleRetries + 1
+	reset := attempts > 1
+	if reset {
+		if reset, err = workingTreeClean(ctx, r, destDir); err != nil {
+			return err
+		}
+		if !reset {
+			log.Printf("⚠️  Warning: %s had uncommitted changes before Ansible ran, so it won't be reset between attempts", destDir)
+		}
+	}
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if !retry.FromContext(ctx).Take() {
+				log.Printf("⚠️  Retry budget exhausted, not retrying Ansible playbook for %s", repoPath)
+				return fmt.Errorf("ansible playbook failed after %d attempts (retry budget exhausted): %w", attempt-1, err)
+			}
+			log.Printf("🔁 Retrying Ansible playbook for %s (attempt %d/%d) in %s", repoPath, attempt, attempts, delay)
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+			if reset {
+				if err := resetWorkingTree(ctx, r, destDir); err != nil {
+					return err
+				}
+			}
+		}
+		if prepare != nil {
+			if err := prepare(); err != nil {
+				return err
+			}
+		}
+
+		if err = runPlaybookChain(ctx, r, cfg, repoPath, env, chain, vars); err == nil {
+			return nil
+		}
+		log.Printf("⚠️  Warning: Ansible playbook attempt %d/%d failed for %s: %v", attempt, attempts, repoPath, err)
+	}
+	return fmt.Errorf("ansible playbook failed after %d attempts: %w", attempts, err)
+}
+
+// withAnsibleTimeout bounds an Ansible run by ansible_timeout, if set
+func withAnsibleTimeout(ctx context.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	if cfg.AnsibleTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.AnsibleTimeout)
+}
+
+// runPlaybookChain runs each playbook in turn. It stops at the first failure
+// unless ansible_continue_on_error is set, in which case the remaining
+// playbooks still run and all failures are returned together.
+func runPlaybookChain(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath string, env, chain []string, extraVars ...string) error {
+	var errs []error
+	for i, playbook := range chain {
+		log.Printf("🔧 Running Ansible playbook %s for %s (%d/%d)", playbook, repoPath, i+1, len(chain))
+		cmd := ansibleCommand(cfg, env, playbook, extraVars...)
+		var out *runner.MuxWriter
+		if mode := ansibleOutputMode(cfg); mode != config.AnsibleOutputStream {
+			out = ansibleOutput.Writer("["+repoPath+"] ", mode == config.AnsibleOutputRun)
+			cmd.Stdout, cmd.Stderr = out, out
+		}
+		runErr := r.Run(ctx, cmd)
+		if out != nil {
+			if err := out.Flush(); err != nil {
+				log.Printf("⚠️  Warning: Could not write Ansible output for %s: %v", repoPath, err)
+			}
+		}
+		if runErr != nil {
+			log.Printf("❌ Ansible playbook %s failed for %s: %v", playbook, repoPath, runErr)
+			err := newAnsibleError(repoPath, playbook, runErr)
+			if !cfg.AnsibleContinueOnError {
+				return err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("✅ Ansible playbook %s succeeded for %s", playbook, repoPath)
+	}
+	return errors.Join(errs...)
+}
+
+// AnsibleError is a failed ansible-playbook run
+type AnsibleError struct {
+	RepoPath string // Repository, or batch label, the playbook ran for
+	Playbook string
+	ExitCode int // -1 if the playbook didn't exit normally, e.g. it was killed or couldn't start
+	Err      error
+}
+
+func newAnsibleError(repoPath, playbook string, err error) *AnsibleError {
+	code := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	}
+	return &AnsibleError{RepoPath: repoPath, Playbook: playbook, ExitCode: code, Err: err}
+}
+
+func (e *AnsibleError) Error() string {
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("playbook %s failed for %s: %v", e.Playbook, e.RepoPath, e.Err)
+	}
+	return fmt.Sprintf("playbook %s failed for %s with exit code %d", e.Playbook, e.RepoPath, e.ExitCode)
+}
+
+func (e *AnsibleError) Unwrap() error {
+	return e.Err
+}
+
+// defaultAnsiblePath is the playbook binary used when ansible_path is not set
+const defaultAnsiblePath = "ansible-playbook"
+
+// ansiblePath returns the configured ansible-playbook binary
+func ansiblePath(cfg *config.Config) string {
+	if cfg.AnsiblePath != "" {
+		return cfg.AnsiblePath
+	}
+	return defaultAnsiblePath
+}
+
+// ansibleCommand builds the invocation of playbook, run from the workspace
+// root, passing each of extraVars as an --extra-vars argument
+func ansibleCommand(cfg *config.Config, env []string, playbook string, extraVars ...string) runner.Cmd {
+	args := []string{playbook}
+	if cfg.AnsiblePythonInterpreter != "" {
+		args = append(args, "--extra-vars", "ansible_python_interpreter="+cfg.AnsiblePythonInterpreter)
+	}
+	for _, vars := range extraVars {
+		args = append(args, "--extra-vars", vars)
+	}
+	return runner.Cmd{Dir: ".", Env: env, Name: ansiblePath(cfg), Args: args}
+}
+
+// checkAnsibleTools verifies that the ansible-playbook binary and the
+// configured Python interpreter resolve to executables
+func checkAnsibleTools(cfg *config.Config) error {
+	if _, err := exec.LookPath(ansiblePath(cfg)); err != nil {
+		return fmt.Errorf("ansible_path: %w", err)
+	}
+	if cfg.AnsiblePythonInterpreter != "" {
+		if _, err := exec.LookPath(cfg.AnsiblePythonInterpreter); err != nil {
+			return fmt.Errorf("ansible_python_interpreter: %w", err)
+		}
+	}
+	return nil
+}
+
+// workingTreeClean reports whether destDir has no uncommitted changes or
+// untracked files
+func workingTreeClean(ctx context.Context, r runner.Runner, destDir string) (bool, error) {
+	out, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"status", "--porcelain"}})
+	if err != nil {
+		return false, fmt.Errorf("git status failed in %s: %w", destDir, err)
+	}
+	return len(dirtyFiles(out)) == 0, nil
+}
+
+// resetWorkingTree discards tracked changes and untracked files in destDir
+func resetWorkingTree(ctx context.Context, r runner.Runner, destDir string) error {
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"reset", "--hard", "HEAD"}}); err != nil {
+		return fmt.Errorf("git reset failed in %s: %w", destDir, err)
+	}
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"clean", "-fd"}}); err != nil {
+		return fmt.Errorf("git clean failed in %s: %w", destDir, err)
+	}
+	return nil
+}
+
+// ansibleEnv merges the global and per-repository env settings into KEY=VALUE
+// entries, with repository values winning. Only the keys are ever logged.
+func ansibleEnv(cfg *config.Config, proj config.RepoSpec) []string {
+	merged := make(map[string]string, len(cfg.Env)+len(proj.Env))
+	for k, v := range cfg.Env {
+		merged[k] = v
+	}
+	for k, v := range proj.Env {
+		merged[k] = v
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+merged[k])
+	}
+	log.Printf("🔑 Passing environment variables to Ansible for %s: %s", proj.RepoPath, strings.Join(keys, ", "))
+	return env
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// countCalls returns how many recorded commands have the given name and,
+// if set, first argument
+func countCalls(f *runner.Fake, name, arg string) int {
+	n := 0
+	for _, c := range f.Calls() {
+		if c.Name == name && (arg == "" || len(c.Args) > 0 && c.Args[0] == arg) {
+			n++
+		}
+	}
+	return n
+}
+
+// repoPathsArg returns the roller_repo_paths an ansible-playbook run was given
+func repoPathsArg(c runner.Cmd) []string {
+	for i, arg := range c.Args {
+		if arg != "--extra-vars" || i+1 == len(c.Args) || !strings.HasPrefix(c.Args[i+1], "{") {
+			continue
+		}
+		var vars map[string][]string
+		if err := json.Unmarshal([]byte(c.Args[i+1]), &vars); err != nil {
+			return nil
+		}
+		return vars[repoPathsVar]
+	}
+	return nil
+}
+
+// failingAnsible fails every ansible-playbook run and lets everything else succeed
+func failingAnsible() *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == defaultAnsiblePath {
+			return nil, errors.New("exit status 2")
+		}
+		return nil, nil
+	}}
+}
+
+func TestRunAnsiblePlaybookRetries(t *testing.T) {
+	f := failingAnsible()
+	cfg := &config.Config{AnsibleRetries: 2, AnsibleRetryDelay: time.Millisecond}
+
+	err := runAnsiblePlaybook(context.Background(), f, cfg, config.RepoSpec{RepoPath: "group/app"}, t.TempDir(), nil)
+	if err == nil {
+		t.Fatal("expected the playbook to fail")
+	}
+	if got := countCalls(f, defaultAnsiblePath, ""); got != 3 {
+		t.Errorf("ansible-playbook ran %d times, want 3 (1 + 2 retries)", got)
+	}
+	// The clone is reset before each retry, not before the first attempt
+	if got := countCalls(f, "git", "reset"); got != 2 {
+		t.Errorf("working tree reset %d times, want 2", got)
+	}
+}
+
+func TestRunAnsiblePlaybookKeepsDirtyTree(t *testing.T) {
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch {
+		case c.Name == defaultAnsiblePath:
+			return nil, errors.New("exit status 2")
+		case c.Name == "git" && c.Args[0] == "status":
+			return []byte(" M notes.txt\n?? scratch/\n"), nil
+		}
+		return nil, nil
+	}}
+	cfg := &config.Config{AnsibleRetries: 2, AnsibleRetryDelay: time.Millisecond}
+
+	if err := runAnsiblePlaybook(context.Background(), f, cfg, config.RepoSpec{RepoPath: "group/app"}, t.TempDir(), nil); err == nil {
+		t.Fatal("expected the playbook to fail")
+	}
+	if got := countCalls(f, defaultAnsiblePath, ""); got != 3 {
+		t.Errorf("ansible-playbook ran %d times, want 3", got)
+	}
+	// The user's uncommitted and untracked work must survive the retries
+	if resets, cleans := countCalls(f, "git", "reset"), countCalls(f, "git", "clean"); resets != 0 || cleans != 0 {
+		t.Errorf("dirty tree was reset %d and cleaned %d times, want neither", resets, cleans)
+	}
+}
+
+func TestRunAnsiblePlaybookSucceedsOnRetry(t *testing.T) {
+	attempts := 0
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == defaultAnsiblePath {
+			if attempts++; attempts == 1 {
+				return nil, errors.New("exit status 2")
+			}
+		}
+		return nil, nil
+	}}
+	cfg := &config.Config{AnsibleRetries: 3, AnsibleRetryDelay: time.Millisecond}
+
+	if err := runAnsiblePlaybook(context.Background(), f, cfg, config.RepoSpec{RepoPath: "group/app"}, t.TempDir(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("ansible-playbook ran %d times, want 2", attempts)
+	}
+}
+
+// exitingAnsible fails every ansible-playbook run with exit code 3
+func exitingAnsible(t *testing.T) *runner.Fake {
+	t.Helper()
+	var exitErr *exec.ExitError
+	if err := exec.Command("sh", "-c", "exit 3").Run(); !errors.As(err, &exitErr) {
+		t.Fatalf("could not produce an exit error: %v", err)
+	}
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == defaultAnsiblePath {
+			return nil, &runner.Error{Cmd: c, Err: exitErr}
+		}
+		return nil, nil
+	}}
+}
+
+func TestAnsibleErrorPropagates(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	client := gitlab.NewClient(cfg, "test-token")
+	proj := config.RepoSpec{RepoPath: "team/app"}
+
+	err := runAnsiblePlaybook(context.Background(), exitingAnsible(t), cfg, proj, t.TempDir(), nil)
+	var ansibleErr *AnsibleError
+	if !errors.As(err, &ansibleErr) {
+		t.Fatalf("expected an AnsibleError, got %v", err)
+	}
+	if ansibleErr.RepoPath != "team/app" || ansibleErr.Playbook != defaultPlaybook || ansibleErr.ExitCode != 3 {
+		t.Errorf("AnsibleError = %+v, want team/app, %s and exit code 3", ansibleErr, defaultPlaybook)
+	}
+
+	// By default the failure fails the repository
+	res := processProject(context.Background(), exitingAnsible(t), client, cfg, proj, true, nil)
+	if res.Status != report.StatusFailed || !strings.Contains(res.Error, "exit code 3") {
+		t.Errorf("result %s (%s), want failed with exit code 3", res.Status, res.Error)
+	}
+
+	// ansible_failure_fatal: false only warns
+	fatal := false
+	cfg.AnsibleFailureFatal = &fatal
+	if res := processProject(context.Background(), exitingAnsible(t), client, cfg, proj, true, nil); res.Status != report.StatusSuccess {
+		t.Errorf("result %s (%s), want success with ansible_failure_fatal false", res.Status, res.Error)
+	}
+}
+
+func TestBumpSurvivesAnsibleRetry(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.BumpDependencies = true
+	cfg.Updates = map[string]string{"requests": "2.33.0"}
+	cfg.AnsibleRetries = 1
+	cfg.AnsibleRetryDelay = time.Millisecond
+	const original = "requests==2.32.3\n"
+	destDir := cloneDestDir(cfg, "team/app")
+	file := filepath.Join(destDir, "requirements.txt")
+
+	attempts := 0
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch {
+		case c.Name == "git" && c.Args[0] == "clone":
+			if err := os.MkdirAll(destDir, 0o755); err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(file, []byte(original), 0o644)
+		case c.Name == "git" && c.Args[0] == "reset":
+			// Like git reset --hard, restore the committed file
+			return nil, os.WriteFile(file, []byte(original), 0o644)
+		case c.Name == defaultAnsiblePath:
+			if attempts++; attempts == 1 {
+				return nil, errors.New("exit status 2")
+			}
+		}
+		return nil, nil
+	}}
+
+	res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, true, nil)
+	if res.Status != report.StatusSuccess {
+		t.Fatalf("result %s: %s", res.Status, res.Error)
+	}
+	if attempts != 2 || countCalls(f, "git", "reset") != 1 {
+		t.Fatalf("ansible-playbook ran %d times with %d resets, want a reset before the one retry", attempts, countCalls(f, "git", "reset"))
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "requests==2.33.0\n"; string(got) != want {
+		t.Errorf("requirements.txt after the retry is %q, want the bump %q", got, want)
+	}
+}
+
+func TestStrictRoleMapping(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.RoleDetectorCommand = "detect-role"
+	cfg.AnsibleRoles = map[string]string{"java": "java_app"}
+	client := gitlab.NewClient(cfg, "test-token")
+
+	for _, strict := range []bool{false, true} {
+		cfg.StrictRoleMapping = strict
+		f := detectingRunner("python")
+		res := processProject(context.Background(), f, client, cfg, config.RepoSpec{RepoPath: "team/tool"}, true, nil)
+		ran := countCalls(f, defaultAnsiblePath, "") > 0
+		if strict {
+			if res.Status != report.StatusFailed || !strings.Contains(res.Error, "no ansible_roles mapping") || ran {
+				t.Errorf("strict: result %s (%s), Ansible ran: %v; want an unmapped role failure without Ansible", res.Status, res.Error, ran)
+			}
+		} else if res.Status != report.StatusSuccess || !ran {
+			t.Errorf("lenient: result %s (%s), Ansible ran: %v; want success with Ansible", res.Status, res.Error, ran)
+		}
+	}
+}
+
+func TestSkipIfUpToDate(t *testing.T) {
+	for _, tt := range []struct {
+		desired string
+		skip    bool
+	}{{"2.32.3", true}, {"2.33.0", false}} {
+		t.Chdir(t.TempDir())
+		cfg := testConfig()
+		cfg.SkipIfUpToDate = true
+		cfg.Updates = map[string]string{"requests": tt.desired}
+		destDir := cloneDestDir(cfg, "team/app")
+		f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+			if c.Name == "git" && c.Args[0] == "clone" {
+				if err := os.MkdirAll(destDir, 0o755); err != nil {
+					return nil, err
+				}
+				return nil, os.WriteFile(filepath.Join(destDir, "requirements.txt"), []byte("requests==2.32.3\n"), 0o644)
+			}
+			return nil, nil
+		}}
+
+		res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, true, nil)
+		ran := countCalls(f, defaultAnsiblePath, "") > 0
+		if tt.skip && (res.Status != report.StatusSkipped || res.Reason != "already up to date" || ran) {
+			t.Errorf("desired %s: result %s (%s), Ansible ran: %v; want skipped as up to date", tt.desired, res.Status, res.Reason, ran)
+		}
+		if !tt.skip && (res.Status != report.StatusSuccess || !ran) {
+			t.Errorf("desired %s: result %s (%s), Ansible ran: %v; want Ansible to run", tt.desired, res.Status, res.Error, ran)
+		}
+	}
+}
+
+func TestAnsibleEnvReachesPlaybook(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logs := captureLog(t)
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env.txt")
+	// A stand-in for ansible-playbook that records the environment it was started with
+	script := filepath.Join(dir, "ansible-playbook")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nenv > "+envFile+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{AnsiblePath: script, Env: map[string]string{"NEXUS_URL": "https://nexus.example.com", "DEPLOY_ENV": "staging"}}
+	proj := config.RepoSpec{RepoPath: "team/app", Env: map[string]string{"DEPLOY_ENV": "production", "APP_SECRET": "hunter2"}}
+
+	if err := runAnsiblePlaybook(context.Background(), &runner.Exec{}, cfg, proj, t.TempDir(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Split(string(data), "\n")
+	for _, want := range []string{"NEXUS_URL=https://nexus.example.com", "DEPLOY_ENV=production", "APP_SECRET=hunter2"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("playbook environment lacks %s", want)
+		}
+	}
+	if slices.Contains(env, "DEPLOY_ENV=staging") {
+		t.Error("the global DEPLOY_ENV should lose to the repository's")
+	}
+	if !strings.Contains(logs.String(), "APP_SECRET, DEPLOY_ENV, NEXUS_URL") || strings.Contains(logs.String(), "hunter2") {
+		t.Errorf("the log should name the keys but never the values:\n%s", logs)
+	}
+}
+
+func TestAnsiblePythonInterpreterAndPath(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.AnsiblePath = "/opt/ansible/bin/ansible-playbook"
+	cfg.AnsiblePythonInterpreter = "/opt/python3.11/bin/python3"
+	f := &runner.Fake{}
+
+	if err := runAnsiblePlaybook(context.Background(), f, cfg, config.RepoSpec{RepoPath: "team/app"}, "repos/app", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := f.Calls()
+	if len(calls) != 1 || calls[0].Name != "/opt/ansible/bin/ansible-playbook" {
+		t.Fatalf("got calls %+v, want one run of the configured ansible_path", calls)
+	}
+	if !strings.Contains(strings.Join(calls[0].Args, " "), "--extra-vars ansible_python_interpreter=/opt/python3.11/bin/python3") {
+		t.Errorf("playbook args %v lack the interpreter extra-var", calls[0].Args)
+	}
+
+	if err := checkAnsibleTools(cfg); err == nil || !strings.Contains(err.Error(), "ansible_path") {
+		t.Errorf("a missing ansible_path should fail the startup check, got %v", err)
+	}
+	cfg.AnsiblePath = "sh"
+	if err := checkAnsibleTools(cfg); err == nil || !strings.Contains(err.Error(), "ansible_python_interpreter") {
+		t.Errorf("a missing interpreter should fail the startup check, got %v", err)
+	}
+	cfg.AnsiblePythonInterpreter = "sh"
+	if err := checkAnsibleTools(cfg); err != nil {
+		t.Errorf("resolvable tools should pass, got %v", err)
+	}
+}
+
+func TestPlaybookChain(t *testing.T) {
+	tests := []struct {
+		name            string
+		global, repo    config.Playbooks
+		continueOnError bool
+		want            []string
+		wantErr         bool
+	}{
+		{"in order", config.Playbooks{"setup.yml", "update.yml", "verify.yml"}, nil, false, []string{"setup.yml", "update.yml", "verify.yml"}, false},
+		{"stops on failure", config.Playbooks{"setup.yml", "fail.yml", "verify.yml"}, nil, false, []string{"setup.yml", "fail.yml"}, true},
+		{"continue on error", config.Playbooks{"setup.yml", "fail.yml", "verify.yml"}, nil, true, []string{"setup.yml", "fail.yml", "verify.yml"}, true},
+		{"repository override", config.Playbooks{"setup.yml"}, config.Playbooks{"ios.yml", "verify.yml"}, false, []string{"ios.yml", "verify.yml"}, false},
+		{"default", nil, nil, false, []string{defaultPlaybook}, false},
+	}
+	for _, tt := range tests {
+		t.Chdir(t.TempDir())
+		cfg := testConfig()
+		cfg.AnsiblePlaybook = tt.global
+		cfg.AnsibleContinueOnError = tt.continueOnError
+		f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+			if c.Args[0] == "fail.yml" {
+				return nil, errors.New("exit status 2")
+			}
+			return nil, nil
+		}}
+
+		err := runAnsiblePlaybook(context.Background(), f, cfg, config.RepoSpec{RepoPath: "team/app", AnsiblePlaybook: tt.repo}, "repos/app", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		var ran []string
+		for _, c := range f.Calls() {
+			ran = append(ran, c.Args[0])
+		}
+		if !slices.Equal(ran, tt.want) {
+			t.Errorf("%s: ran %v, want %v", tt.name, ran, tt.want)
+		}
+	}
+}
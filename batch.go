@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+	"roller/tracing"
+)
+
+// batchedRepo is a cloned repository waiting for its role's batched Ansible run
+type batchedRepo struct {
+	RepoPath      string
+	DestDir       string
+	TargetBranch  string
+	FeatureBranch string // Branch checked out in DestDir, with any group or project override applied
+}
+
+// ansibleBatch collects prepared repositories by role while the workers clone
+// them, so that batch_ansible can run one playbook invocation per role once
+// cloning has finished. It is safe for concurrent use.
+type ansibleBatch struct {
+	mu     sync.Mutex
+	byRole map[string][]batchedRepo
+}
+
+func newAnsibleBatch() *ansibleBatch {
+	return &ansibleBatch{byRole: make(map[string][]batchedRepo)}
+}
+
+// add queues a prepared repository under role
+func (b *ansibleBatch) add(role string, repo batchedRepo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.byRole[role] = append(b.byRole[role], repo)
+}
+
+// run invokes the global playbooks once per role, in role order, passing the
+// role's clone directories as a JSON list in the roller_repo_paths extra-var.
+// Repository-level env and ansible_playbook overrides don't apply to batches;
+// merge requests are still opened from each repository's own feature branch.
+// A failed batch leaves its repositories without merge requests and, with
+// ansible_failure_fatal, fails them; publishing failures are recorded on the
+// affected results.
+func (b *ansibleBatch) run(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, results []report.Result) {
+	index := make(map[string]int, len(results))
+	for i, res := range results {
+		index[res.RepoPath] = i
+	}
+
+	roles := make([]string, 0, len(b.byRole))
+	for role := range b.byRole {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		repos := b.byRole[role]
+		sort.Slice(repos, func(i, j int) bool { return repos[i].RepoPath < repos[j].RepoPath })
+
+		dirs := make([]string, len(repos))
+		for i, repo := range repos {
+			dirs[i] = repo.DestDir
+		}
+		vars, err := repoPathsExtraVars(dirs)
+		if err != nil {
+			log.Printf("⚠️  Warning: Could not encode the batch for role %s: %v", role, err)
+			continue
+		}
+
+		label := fmt.Sprintf("role %s (%d repositories)", displayRole(role), len(repos))
+		env := ansibleEnv(cfg, config.RepoSpec{RepoPath: label})
+		actx, span := tracing.Start(ctx, "ansible.batch", tracing.RoleKey.String(role), tracing.RepoCountKey.Int(len(repos)))
+		actx, cancel := withAnsibleTimeout(actx, cfg)
+		err = runPlaybookChain(actx, r, cfg, label, env, playbooks(cfg, config.RepoSpec{}), vars)
+		cancel()
+		tracing.End(span, err)
+		if err != nil {
+			log.Printf("⚠️  Warning: Batched Ansible run failed for %s: %v", label, err)
+			if cfg.AnsibleFailuresFatal() {
+				for _, repo := range repos {
+					if i, ok := index[repo.RepoPath]; ok {
+						results[i].Status = report.StatusFailed
+						results[i].Error = err.Error()
+					}
+				}
+			}
+			continue
+		}
+		log.Printf("✅ Successfully ran batched Ansible playbook for %s", label)
+
+		if !cfg.CreateMergeRequest {
+			continue
+		}
+		for _, repo := range repos {
+			i, ok := index[repo.RepoPath]
+			if !ok {
+				continue
+			}
+			repoCfg := cfg
+			if repo.FeatureBranch != cfg.FeatureBranch {
+				c := *cfg
+				c.FeatureBranch = repo.FeatureBranch
+				repoCfg = &c
+			}
+			if err := publishChanges(ctx, r, client, repoCfg, repo.RepoPath, repo.DestDir, role, repo.TargetBranch, &results[i]); err != nil {
+				log.Printf("⚠️  Error processing %s: %v", repo.RepoPath, err)
+				results[i].Status = report.StatusFailed
+				results[i].Error = err.Error()
+			}
+		}
+	}
+}
+
+// displayRole renders an undetected role for log messages
+func displayRole(role string) string {
+	if role == "" {
+		return config.UnknownRoleKey
+	}
+	return role
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// batchRoles names the role the fake role detector reports for each clone
+var batchRoles = map[string]string{"app": "java", "lib": "java", "tool": "python"}
+
+// batchRunner detects roles from batchRoles and fails the batched Ansible
+// run of failRole, if set
+func batchRunner(failRole string) *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch c.Name {
+		case "detect-role":
+			return []byte(batchRoles[filepath.Base(c.Args[len(c.Args)-1])]), nil
+		case defaultAnsiblePath:
+			dirs := batchedDirs(c)
+			if failRole != "" && len(dirs) > 0 && batchRoles[dirs[0]] == failRole {
+				return nil, errors.New("exit status 2")
+			}
+		}
+		return nil, nil
+	}}
+}
+
+// batchedDirs returns the base names of the clone directories an
+// ansible-playbook run was given in roller_repo_paths
+func batchedDirs(c runner.Cmd) []string {
+	var dirs []string
+	for _, dir := range repoPathsArg(c) {
+		dirs = append(dirs, filepath.Base(dir))
+	}
+	return dirs
+}
+
+func batchTestConfig() *config.Config {
+	cfg := testConfig()
+	cfg.BatchAnsible = true
+	cfg.RoleDetectorCommand = "detect-role"
+	return cfg
+}
+
+var batchProjects = []config.RepoSpec{{RepoPath: "team/tool"}, {RepoPath: "team/lib"}, {RepoPath: "team/app"}}
+
+func TestBatchAnsibleRunsOncePerRole(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := batchTestConfig()
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := batchRunner("")
+
+	results := processProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, batchProjects, true, cp)
+	for _, res := range results {
+		if res.Status != report.StatusSuccess {
+			t.Errorf("%s is %s: %s", res.RepoPath, res.Status, res.Error)
+		}
+	}
+
+	var runs [][]string
+	for _, c := range f.Calls() {
+		if c.Name == defaultAnsiblePath {
+			runs = append(runs, batchedDirs(c))
+		}
+	}
+	// Roles run in order, each with its repositories sorted by path
+	want := [][]string{{"app", "lib"}, {"tool"}}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("ansible-playbook ran for %v, want one run per role: %v", runs, want)
+	}
+	for _, proj := range batchProjects {
+		if !cp.done(proj.RepoPath, cfg.FeatureBranch) {
+			t.Errorf("%s not recorded in the checkpoint", proj.RepoPath)
+		}
+	}
+}
+
+func TestBatchAnsibleFailureNotCheckpointed(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := batchTestConfig()
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := processProjects(context.Background(), batchRunner("java"), gitlab.NewClient(cfg, "test-token"), cfg, batchProjects, true, cp)
+	for _, res := range results {
+		failed := batchRoles[filepath.Base(res.RepoPath)] == "java"
+		if got := res.Status == report.StatusFailed; got != failed {
+			t.Errorf("%s is %s, want failed: %v", res.RepoPath, res.Status, failed)
+		}
+		if got := cp.done(res.RepoPath, cfg.FeatureBranch); got == failed {
+			t.Errorf("%s checkpointed: %v, want %v", res.RepoPath, got, !failed)
+		}
+	}
+}
+
+func TestBatchAnsiblePublishesRepoFeatureBranch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var mu sync.Mutex
+	var sourceBranches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/merge_requests") {
+			http.NotFound(w, r)
+			return
+		}
+		var mr struct {
+			SourceBranch string `json:"source_branch"`
+		}
+		json.NewDecoder(r.Body).Decode(&mr)
+		mu.Lock()
+		sourceBranches = append(sourceBranches, mr.SourceBranch)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"iid": 1, "web_url": "https://gitlab.example.com/mr/1"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := batchTestConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.CreateMergeRequest = true
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch {
+		case c.Name == "detect-role":
+			return []byte("java"), nil
+		case c.Name == "git" && c.Args[0] == "status":
+			return []byte(" M pom.xml\n"), nil
+		}
+		return nil, nil
+	}}
+	projects := []config.RepoSpec{{RepoPath: "team/app", FeatureBranch: "app-updates"}, {RepoPath: "team/lib"}}
+
+	results := processProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, projects, true, cp)
+	wantBranches := map[string]string{"team/app": "app-updates", "team/lib": "roller-updates"}
+	for _, res := range results {
+		want := wantBranches[res.RepoPath]
+		if res.Status != report.StatusSuccess || !res.Pushed {
+			t.Errorf("%s is %s (pushed %v): %s", res.RepoPath, res.Status, res.Pushed, res.Error)
+		}
+		if res.FeatureBranch != want || res.RemoteRef != "refs/heads/"+want {
+			t.Errorf("%s recorded branch %s and ref %s, want %s", res.RepoPath, res.FeatureBranch, res.RemoteRef, want)
+		}
+		if !cp.done(res.RepoPath, want) {
+			t.Errorf("%s not checkpointed under %s", res.RepoPath, want)
+		}
+	}
+
+	var pushed []string
+	for _, c := range f.Calls() {
+		if c.Name == "git" && len(c.Args) == 4 && c.Args[0] == "push" && c.Args[1] == "-u" {
+			pushed = append(pushed, c.Args[3])
+		}
+	}
+	sort.Strings(pushed)
+	sort.Strings(sourceBranches)
+	if want := []string{"app-updates", "roller-updates"}; !reflect.DeepEqual(pushed, want) || !reflect.DeepEqual(sourceBranches, want) {
+		t.Errorf("pushed %v and opened merge requests from %v, want %v", pushed, sourceBranches, want)
+	}
+}
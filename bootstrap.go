@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/runner"
+)
+
+// Branch names used by -bootstrap when no flag overrides them
+const (
+	defaultBootstrapTargetBranch  = "main"
+	defaultBootstrapFeatureBranch = "roller-updates"
+)
+
+// bootstrapOptions holds the flag values used to generate a config
+type bootstrapOptions struct {
+	Group         string
+	GitlabURL     string
+	TargetBranch  string
+	FeatureBranch string
+	OutputPath    string
+}
+
+// bootstrapConfig discovers opts.Group, detects each project's role and writes
+// a complete config with auto_discover pointing at the group
+func bootstrapConfig(ctx context.Context, r runner.Runner, token string, opts bootstrapOptions) error {
+	if opts.GitlabURL == "" {
+		return fmt.Errorf("-gitlab-url is required with -bootstrap")
+	}
+	if token == "" {
+		return fmt.Errorf("GITLAB_TOKEN environment variable is required")
+	}
+	if opts.TargetBranch == "" {
+		opts.TargetBranch = defaultBootstrapTargetBranch
+	}
+	if opts.FeatureBranch == "" {
+		opts.FeatureBranch = defaultBootstrapFeatureBranch
+	}
+
+	cfg := &config.Config{
+		GitlabURL:     opts.GitlabURL,
+		TargetBranch:  opts.TargetBranch,
+		FeatureBranch: opts.FeatureBranch,
+		AutoDiscover:  &config.AutoDiscover{Group: opts.Group},
+		Cleanup:       true,
+	}
+
+	projects, _, err := discoverProjects(ctx, r, gitlab.NewClient(cfg, token), cfg)
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		log.Printf("⚠️  Warning: Group %s has no active projects; the config relies on auto_discover alone", opts.Group)
+	}
+	cfg.Projects = projects
+
+	if err := config.WriteBootstrapConfig(opts.OutputPath, cfg); err != nil {
+		return err
+	}
+	log.Printf("✅ Wrote config for group %s with %d projects to %s", opts.Group, len(projects), opts.OutputPath)
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"roller/config"
+	"roller/runner"
+)
+
+func TestBootstrapConfigIsValid(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/app"}, {"path_with_namespace": "team/tool"}]`)
+	output := filepath.Join(t.TempDir(), "roller.yaml")
+
+	// The fake clones are empty, so the projects are written without roles
+	opts := bootstrapOptions{Group: "team", GitlabURL: cfg.GitlabURL, OutputPath: output}
+	if err := bootstrapConfig(context.Background(), &runner.Fake{}, "test-token", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := config.LoadConfig(output)
+	if err != nil {
+		t.Fatalf("generated config doesn't load: %v", err)
+	}
+	if err := generated.Validate(); err != nil {
+		t.Errorf("generated config fails Validate: %v", err)
+	}
+	if generated.TargetBranch != defaultBootstrapTargetBranch || generated.FeatureBranch != defaultBootstrapFeatureBranch {
+		t.Errorf("branches %s and %s, want the defaults", generated.TargetBranch, generated.FeatureBranch)
+	}
+	if generated.AutoDiscover == nil || generated.AutoDiscover.Group != "team" || len(generated.Projects) != 2 {
+		t.Errorf("auto_discover %+v with projects %+v, want group team and both projects", generated.AutoDiscover, generated.Projects)
+	}
+}
@@ -0,0 +1,61 @@
+// Package branches manages the feature branch inside a local clone.
+package branches
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"roller/runner"
+)
+
+// EnsureUpToDate fetches the latest targetBranch from origin and rebases the
+// currently checked-out feature branch onto it. If the rebase stops on
+// conflicts it is aborted so the clone is left on the original feature
+// branch, and an error is returned. A rebase that fails without starting,
+// e.g. on a dirty working tree, is reported as is and left alone.
+func EnsureUpToDate(ctx context.Context, r runner.Runner, repoDir, targetBranch string) error {
+	fetch := runner.Cmd{Dir: repoDir, Name: "git", Args: []string{"fetch", "origin", targetBranch}}
+	if err := r.Run(ctx, fetch); err != nil {
+		return fmt.Errorf("git fetch origin %s failed in %s: %w", targetBranch, repoDir, err)
+	}
+
+	upstream := "origin/" + targetBranch
+	rebase := runner.Cmd{Dir: repoDir, Name: "git", Args: []string{"rebase", upstream}}
+	if err := r.Run(ctx, rebase); err != nil {
+		if !rebaseInProgress(ctx, r, repoDir) {
+			return fmt.Errorf("rebase onto %s failed in %s: %w", upstream, repoDir, err)
+		}
+		abort := runner.Cmd{Dir: repoDir, Name: "git", Args: []string{"rebase", "--abort"}}
+		if abortErr := r.Run(ctx, abort); abortErr != nil {
+			return fmt.Errorf("rebase onto %s failed in %s and could not be aborted: %v (abort: %w)", upstream, repoDir, err, abortErr)
+		}
+		return fmt.Errorf("rebase onto %s hit conflicts in %s; rebase aborted: %w", upstream, repoDir, err)
+	}
+
+	return nil
+}
+
+// rebaseStateDirs are the directories git keeps inside .git while a rebase
+// is stopped, depending on the rebase backend
+var rebaseStateDirs = []string{"rebase-merge", "rebase-apply"}
+
+// rebaseInProgress reports whether a rebase stopped part-way in repoDir
+func rebaseInProgress(ctx context.Context, r runner.Runner, repoDir string) bool {
+	for _, name := range rebaseStateDirs {
+		out, err := r.Output(ctx, runner.Cmd{Dir: repoDir, Name: "git", Args: []string{"rev-parse", "--git-path", name}})
+		if err != nil {
+			continue
+		}
+		dir := strings.TrimSpace(string(out))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(repoDir, dir)
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package branches
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"roller/runner"
+)
+
+// fakeRebase answers git commands like a clone whose rebase fails, leaving
+// the rebase state directory behind when stopped is set
+func fakeRebase(t *testing.T, repoDir string, stopped bool) *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch {
+		case c.Args[0] == "rebase" && c.Args[1] != "--abort":
+			if stopped {
+				if err := os.MkdirAll(filepath.Join(repoDir, ".git", "rebase-merge"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return nil, &runner.Error{Stderr: "CONFLICT (content): Merge conflict in pom.xml", Err: errors.New("exit status 1")}
+		case c.Args[0] == "rev-parse":
+			return []byte(filepath.Join(".git", c.Args[2]) + "\n"), nil
+		}
+		return nil, nil
+	}}
+}
+
+func commands(f *runner.Fake) []string {
+	var cmds []string
+	for _, c := range f.Calls() {
+		cmds = append(cmds, c.String())
+	}
+	return cmds
+}
+
+func TestEnsureUpToDateAbortsOnConflict(t *testing.T) {
+	repoDir := t.TempDir()
+	f := fakeRebase(t, repoDir, true)
+
+	err := EnsureUpToDate(context.Background(), f, repoDir, "main")
+	if err == nil || !strings.Contains(err.Error(), "hit conflicts") {
+		t.Fatalf("expected a conflict error, got %v", err)
+	}
+	cmds := commands(f)
+	if last := cmds[len(cmds)-1]; last != "git rebase --abort" {
+		t.Errorf("expected the rebase to be aborted, last command was %q (all: %v)", last, cmds)
+	}
+}
+
+func TestEnsureUpToDateRebaseNotStarted(t *testing.T) {
+	repoDir := t.TempDir()
+	f := fakeRebase(t, repoDir, false)
+
+	err := EnsureUpToDate(context.Background(), f, repoDir, "main")
+	if err == nil || strings.Contains(err.Error(), "conflicts") {
+		t.Fatalf("expected a plain rebase error, got %v", err)
+	}
+	for _, cmd := range commands(f) {
+		if cmd == "git rebase --abort" {
+			t.Errorf("aborted a rebase that never started")
+		}
+	}
+}
+
+func TestEnsureUpToDate(t *testing.T) {
+	f := &runner.Fake{}
+	if err := EnsureUpToDate(context.Background(), f, t.TempDir(), "main"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"git fetch origin main", "git rebase origin/main"}
+	if got := commands(f); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
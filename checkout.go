@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// checkoutRetryDelay is the pause before retrying a checkout that ran into a
+// locked index
+const checkoutRetryDelay = 500 * time.Millisecond
+
+// createFeatureBranch runs git checkout -b for the feature branch in destDir.
+// A checkout that fails on another git process's index.lock, as happens on
+// network filesystems or under contention, is retried up to
+// checkout_retries times; any other failure, such as an existing branch,
+// fails right away.
+func createFeatureBranch(ctx context.Context, r runner.Runner, cfg *config.Config, destDir string) error {
+	cmd := runner.Cmd{Dir: destDir, Name: "git", Args: []string{"checkout", "-b", cfg.FeatureBranch}}
+	retries := cfg.CheckoutRetriesOrDefault()
+	for attempt := 1; ; attempt++ {
+		err := r.Run(ctx, cmd)
+		if err == nil || attempt > retries || !isIndexLockError(err) {
+			return err
+		}
+		log.Printf("🔁 Retrying checkout of %s in %s (attempt %d/%d): index is locked", cfg.FeatureBranch, destDir, attempt+1, retries+1)
+		if err := sleepContext(ctx, time.Duration(attempt)*checkoutRetryDelay); err != nil {
+			return fmt.Errorf("interrupted while waiting to retry checkout: %w", err)
+		}
+	}
+}
+
+// isIndexLockError reports whether a failed git command failed because the
+// index was locked by another process
+func isIndexLockError(err error) bool {
+	stderr := runner.Stderr(err)
+	return strings.Contains(stderr, "index.lock") && strings.Contains(stderr, "File exists")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// failingCheckout fails the first times checkouts with stderr, then lets them succeed
+func failingCheckout(times int, stderr string) *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if times > 0 {
+			times--
+			return nil, &runner.Error{Cmd: c, Stderr: stderr, Err: errors.New("exit status 128")}
+		}
+		return nil, nil
+	}}
+}
+
+func TestCreateFeatureBranchRetriesLockedIndex(t *testing.T) {
+	f := failingCheckout(1, "fatal: Unable to create '/repos/app/.git/index.lock': File exists.")
+	cfg := &config.Config{FeatureBranch: "roller-updates"}
+
+	if err := createFeatureBranch(context.Background(), f, cfg, t.TempDir()); err != nil {
+		t.Fatalf("expected the retry to succeed: %v", err)
+	}
+	if got := countCalls(f, "git", "checkout"); got != 2 {
+		t.Errorf("checkout ran %d times, want 2", got)
+	}
+}
+
+func TestCreateFeatureBranchDoesNotRetryExistingBranch(t *testing.T) {
+	f := failingCheckout(1, "fatal: a branch named 'roller-updates' already exists")
+	cfg := &config.Config{FeatureBranch: "roller-updates"}
+
+	if err := createFeatureBranch(context.Background(), f, cfg, t.TempDir()); err == nil {
+		t.Fatal("expected the existing branch to fail the checkout")
+	}
+	if got := countCalls(f, "git", "checkout"); got != 1 {
+		t.Errorf("checkout ran %d times, want 1", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+// defaultCheckpointPath is where completed repositories are recorded
+const defaultCheckpointPath = ".roller-checkpoint.json"
+
+// checkpointEntry identifies a repository finished for a feature branch, as
+// configured before feature_branch_suffix is applied
+type checkpointEntry struct {
+	RepoPath      string `json:"repo_path"`
+	FeatureBranch string `json:"feature_branch"`
+}
+
+// checkpoint records which repositories completed successfully so that a
+// failed run can be resumed without redoing them. It is rewritten after each
+// completed repository and is safe for concurrent use.
+type checkpoint struct {
+	mu        sync.Mutex
+	path      string
+	completed map[checkpointEntry]bool
+}
+
+// openCheckpoint returns the checkpoint stored at path. Unless resume is set,
+// previous entries are discarded and the run starts from scratch.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	cp := &checkpoint{path: path, completed: make(map[checkpointEntry]bool)}
+	if !resume {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to reset checkpoint: %w", err)
+		}
+		return cp, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var doc struct {
+		Completed []checkpointEntry `json:"completed"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	for _, e := range doc.Completed {
+		cp.completed[e] = true
+	}
+	return cp, nil
+}
+
+// done reports whether repoPath already completed for featureBranch
+func (c *checkpoint) done(repoPath, featureBranch string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[checkpointEntry{repoPath, featureBranch}]
+}
+
+// markDone records repoPath as completed for featureBranch and rewrites the
+// checkpoint file
+func (c *checkpoint) markDone(repoPath, featureBranch string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[checkpointEntry{repoPath, featureBranch}] = true
+
+	var doc struct {
+		Completed []checkpointEntry `json:"completed"`
+	}
+	for e := range c.completed {
+		doc.Completed = append(doc.Completed, e)
+	}
+	sort.Slice(doc.Completed, func(i, j int) bool {
+		a, b := doc.Completed[i], doc.Completed[j]
+		if a.FeatureBranch != b.FeatureBranch {
+			return a.FeatureBranch < b.FeatureBranch
+		}
+		return a.RepoPath < b.RepoPath
+	})
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	// Write then rename so an interrupted run never leaves a truncated file
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
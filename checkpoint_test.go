@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// testConfig returns a config for processing projects of a fake GitLab
+func testConfig() *config.Config {
+	return &config.Config{
+		GitlabURL:     "https://gitlab.example.com",
+		TargetBranch:  "main",
+		FeatureBranch: "roller-updates",
+	}
+}
+
+func TestCheckpointSecondRunSkipsCompleted(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	client := gitlab.NewClient(cfg, "test-token")
+	projects := []config.RepoSpec{{RepoPath: "team/app"}, {RepoPath: "team/lib"}}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	cp, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &runner.Fake{}
+	for _, res := range processProjects(context.Background(), first, client, cfg, projects, false, cp) {
+		if res.Status != report.StatusSuccess {
+			t.Fatalf("first run: %s is %s: %s", res.RepoPath, res.Status, res.Error)
+		}
+	}
+
+	cp, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &runner.Fake{}
+	for _, res := range processProjects(context.Background(), second, client, cfg, projects, false, cp) {
+		if res.Status != report.StatusSkipped || res.Reason != "completed in a previous run" {
+			t.Errorf("second run: %s is %s (%s), want skipped as completed", res.RepoPath, res.Status, res.Reason)
+		}
+	}
+	if calls := second.Calls(); len(calls) != 0 {
+		t.Errorf("second run ran %d commands, want none: %v", len(calls), calls)
+	}
+}
+
+func TestCheckpointResumeIgnoresBranchSuffix(t *testing.T) {
+	t.Chdir(t.TempDir())
+	projects := []config.RepoSpec{{RepoPath: "team/app"}}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Each run gets its own run ID, and so its own suffixed feature branch
+	run := func(runID string, resume bool) ([]report.Result, *runner.Fake) {
+		cfg := testConfig()
+		cfg.FeatureBranchSuffix = config.FeatureBranchSuffixRunID
+		cfg.RunID = runID
+		if err := cfg.ApplyFeatureBranchSuffix(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		cp, err := openCheckpoint(path, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &runner.Fake{}
+		return processProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, projects, false, cp), f
+	}
+
+	if res, _ := run("first", false); res[0].Status != report.StatusSuccess {
+		t.Fatalf("first run: %s: %s", res[0].Status, res[0].Error)
+	}
+	res, f := run("second", true)
+	if res[0].Status != report.StatusSkipped {
+		t.Errorf("resumed run: %s is %s, want skipped as completed", res[0].RepoPath, res[0].Status)
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("resumed run ran %d commands, want none: %v", len(calls), calls)
+	}
+}
+
+func TestCheckpointKeyedByFeatureBranch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.markDone("team/app", "roller-updates"); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.done("team/app", "roller-updates") {
+		t.Error("completed repository not recorded")
+	}
+	if cp.done("team/app", "other-branch") {
+		t.Error("completion leaked to another feature branch")
+	}
+
+	// Without -resume the previous run's entries are discarded
+	cp, err = openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.done("team/app", "roller-updates") {
+		t.Error("checkpoint not reset without -resume")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// gitHTTPStatus matches the HTTP status git reports for a failed request,
+// e.g. "The requested URL returned error: 503"
+var gitHTTPStatus = regexp.MustCompile(`returned error: (\d{3})`)
+
+// gitRetryHint matches a retry delay in seconds that a git rate-limit
+// response may carry, e.g. "Retry-After: 60"
+var gitRetryHint = regexp.MustCompile(`retry[- ]after:?\s*(\d+)`)
+
+// Substrings of git and network error output, checked in this order
+var (
+	rateLimitMarkers = []string{
+		"rate limit",
+		"too many requests",
+		"returned error: 429",
+	}
+	authMarkers = []string{
+		"authentication failed",
+		"permission denied",
+		"access denied",
+		"could not read username",
+		"invalid username or password",
+		"http basic: access denied",
+	}
+	notFoundMarkers = []string{
+		"repository not found",
+		"could not be found",
+		"does not appear to be a git repository",
+		"does not exist",
+	}
+	transientMarkers = []string{
+		"timed out",
+		"timeout",
+		"connection reset",
+		"connection refused",
+		"could not resolve host",
+		"temporary failure",
+		"early eof",
+		"remote end hung up",
+		"rpc failed",
+		"tls handshake",
+		"broken pipe",
+	}
+)
+
+// classifyError sorts a failure into one of the report.ErrorClass*
+// categories based on the GitLab API status or the git output it carries
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return report.ErrorClassTransient
+	}
+
+	var apiErr *gitlab.APIError
+	if errors.As(err, &apiErr) {
+		return classifyStatus(apiErr.StatusCode)
+	}
+	if isMissingBranchError(err) {
+		return report.ErrorClassNotFound
+	}
+
+	text := strings.ToLower(runner.Stderr(err) + "\n" + err.Error())
+	if containsAny(text, rateLimitMarkers) {
+		return report.ErrorClassRateLimit
+	}
+	if m := gitHTTPStatus.FindStringSubmatch(text); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		if class := classifyStatus(code); class != report.ErrorClassOther {
+			return class
+		}
+	}
+	switch {
+	case containsAny(text, authMarkers):
+		return report.ErrorClassAuth
+	case containsAny(text, notFoundMarkers):
+		return report.ErrorClassNotFound
+	case containsAny(text, transientMarkers):
+		return report.ErrorClassTransient
+	}
+	return report.ErrorClassOther
+}
+
+// gitRetryAfter returns the delay a rate-limited git operation was told to
+// wait before retrying, if its output carries one
+func gitRetryAfter(err error) (time.Duration, bool) {
+	m := gitRetryHint.FindStringSubmatch(strings.ToLower(runner.Stderr(err) + "\n" + err.Error()))
+	if m == nil {
+		return 0, false
+	}
+	secs, convErr := strconv.Atoi(m[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// classifyStatus maps an HTTP status code to an error class
+func classifyStatus(code int) string {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return report.ErrorClassAuth
+	case code == http.StatusNotFound:
+		return report.ErrorClassNotFound
+	case code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500:
+		return report.ErrorClassTransient
+	}
+	return report.ErrorClassOther
+}
+
+// containsAny reports whether s contains any of substrs
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
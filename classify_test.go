@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// gitFailure is a failed git command with stderr
+func gitFailure(stderr string) error {
+	return &runner.Error{Cmd: runner.Cmd{Name: "git", Args: []string{"clone"}}, Stderr: stderr, Err: errors.New("exit status 128")}
+}
+
+// sampleRateLimit is what git prints when GitLab rate-limits a clone over HTTPS
+const sampleRateLimit = `Cloning into 'repos/app'...
+remote: You have reached the rate limit for git operations. Retry after 60 seconds.
+fatal: unable to access 'https://gitlab.example.com/team/app.git/': The requested URL returned error: 429`
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"git rate limit", gitFailure(sampleRateLimit), report.ErrorClassRateLimit},
+		{"bare 429", gitFailure("fatal: unable to access 'https://gitlab.example.com/team/app.git/': The requested URL returned error: 429"), report.ErrorClassRateLimit},
+		{"server error", gitFailure("fatal: unable to access 'https://gitlab.example.com/team/app.git/': The requested URL returned error: 503"), report.ErrorClassTransient},
+		{"auth", gitFailure("remote: HTTP Basic: Access denied\nfatal: Authentication failed for 'https://gitlab.example.com/team/app.git/'"), report.ErrorClassAuth},
+		{"not found", gitFailure("remote: The project you were looking for could not be found."), report.ErrorClassNotFound},
+		{"network", gitFailure("fatal: unable to access 'https://gitlab.example.com/': Could not resolve host: gitlab.example.com"), report.ErrorClassTransient},
+		{"timeout", fmt.Errorf("clone timed out: %w", context.DeadlineExceeded), report.ErrorClassTransient},
+		{"api", &gitlab.APIError{StatusCode: 403}, report.ErrorClassAuth},
+		{"other", gitFailure("fatal: destination path 'repos/app' already exists"), report.ErrorClassOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: class %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGitRetryAfter(t *testing.T) {
+	if d, ok := gitRetryAfter(gitFailure(sampleRateLimit)); !ok || d != 60*time.Second {
+		t.Errorf("gitRetryAfter() = %s, %v; want 60s from the rate-limit message", d, ok)
+	}
+	if d, ok := gitRetryAfter(gitFailure("fatal: The requested URL returned error: 429")); ok {
+		t.Errorf("gitRetryAfter() = %s without any hint in the output", d)
+	}
+}
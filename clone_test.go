@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/retry"
+	"roller/runner"
+)
+
+func TestCloneDestDir(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"basename", config.Config{}, "repos/app"},
+		{"full-path", config.Config{DirNaming: config.DirNamingFullPath}, "repos/group__sub__app"},
+		{"preserve_namespace", config.Config{PreserveNamespace: true}, "repos/group/sub/app"},
+		{"preserve_namespace over full-path", config.Config{PreserveNamespace: true, DirNaming: config.DirNamingFullPath}, "repos/group/sub/app"},
+	}
+	for _, tt := range tests {
+		if got := cloneDestDir(&tt.cfg, "group/sub/app"); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: clone in %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// failingClone fails every git clone with a transient network error
+func failingClone() *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		return nil, &runner.Error{Cmd: c, Stderr: "fatal: unable to access: Connection reset by peer", Err: errors.New("exit status 128")}
+	}}
+}
+
+func TestRunCloneFailsFastOnceBudgetIsSpent(t *testing.T) {
+	budget := retry.NewBudget(1)
+	budget.Take()
+	ctx := retry.NewContext(context.Background(), budget)
+	f := failingClone()
+	cfg := &config.Config{CloneRetries: 3}
+	destDir := filepath.Join(t.TempDir(), "app")
+
+	err := runClone(ctx, f, cfg, "group/app", destDir, runner.Cmd{Name: "git", Args: []string{"clone", "url", destDir}})
+	if err == nil {
+		t.Fatal("expected the clone to fail")
+	}
+	if got := len(f.Calls()); got != 1 {
+		t.Errorf("clone attempted %d times with the budget spent, want 1", got)
+	}
+}
+
+func TestCloneTimeoutCancelsSlowClone(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.CloneTimeout = 100 * time.Millisecond
+	// The clone hangs until it is cancelled, like one stuck on a slow transfer
+	f := &runner.Fake{RespondContext: func(ctx context.Context, c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}}
+
+	start := time.Now()
+	res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil)
+	elapsed := time.Since(start)
+	if res.Status != report.StatusFailed || !strings.Contains(res.Error, "clone timed out after 100ms (clone_timeout)") {
+		t.Fatalf("result %s (%s), want the clone to time out at clone_timeout", res.Status, res.Error)
+	}
+	// The per-repository budget is minutes; the clone must not have waited for it
+	if elapsed < cfg.CloneTimeout || elapsed > 5*time.Second {
+		t.Errorf("clone gave up after %s, want about %s", elapsed, cfg.CloneTimeout)
+	}
+}
+
+func TestCancelledCloneLeavesNoDirectory(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	destDir := cloneDestDir(cfg, "team/app")
+	// The clone gets as far as creating the repository before it is cancelled
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			if err := os.MkdirAll(filepath.Join(destDir, ".git", "objects"), 0o755); err != nil {
+				return nil, err
+			}
+			return nil, context.Canceled
+		}
+		return nil, nil
+	}}
+
+	var res report.Result
+	err := cloneAndCreateBranch(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil, &res)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation, got %v", err)
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("partial clone %s left behind (stat: %v)", destDir, err)
+	}
+}
+
+// missingBranchClone fails clones of a --branch with git's missing branch
+// error and reports master as the default branch
+func missingBranchClone() *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		switch {
+		case c.Args[0] == "clone" && slices.Contains(c.Args, "--branch"):
+			return nil, &runner.Error{Cmd: c, Stderr: "Cloning into 'repos/app'...\nwarning: Could not find remote branch release to clone.\nfatal: Remote branch release not found in upstream origin", Err: errors.New("exit status 128")}
+		case c.Args[0] == "rev-parse":
+			return []byte("master\n"), nil
+		}
+		return nil, nil
+	}}
+}
+
+func TestCloneTargetMissingBranch(t *testing.T) {
+	destDir := filepath.Join(t.TempDir(), "app")
+	cfg := &config.Config{CloneRetries: 2}
+
+	// By default the repository fails, without retrying the clone
+	f := missingBranchClone()
+	if _, err := cloneTarget(context.Background(), f, cfg, "team/app", "https://gitlab.example.com/team/app.git", destDir, "release", false); err == nil || !isMissingBranchError(err) {
+		t.Fatalf("expected the missing branch error, got %v", err)
+	}
+	if got := countCalls(f, "git", "clone"); got != 1 {
+		t.Errorf("clone attempted %d times, want 1", got)
+	}
+
+	cfg.OnMissingBranch = config.OnMissingBranchUseDefault
+	f = missingBranchClone()
+	branch, err := cloneTarget(context.Background(), f, cfg, "team/app", "https://gitlab.example.com/team/app.git", destDir, "release", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch != "master" {
+		t.Errorf("cloned branch %s, want the default branch master", branch)
+	}
+	calls := f.Calls()
+	if len(calls) < 2 || slices.Contains(calls[1].Args, "--branch") {
+		t.Errorf("expected a second clone of the default branch, got %v", calls)
+	}
+}
+
+func TestCloneArgsSubmodules(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{"plain", config.Config{}, []string{"clone", "--depth", "1", "--branch", "main", "url", "repos/app"}},
+		{"submodules", config.Config{CloneSubmodules: true},
+			[]string{"clone", "--depth", "1", "--branch", "main", "--recurse-submodules", "--shallow-submodules", "url", "repos/app"}},
+	}
+	for _, tt := range tests {
+		if got := cloneArgs(&tt.cfg, "url", "main", "repos/app", false); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: git %v, want git %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloneArgsProtocolVersion(t *testing.T) {
+	if got := cloneArgs(&config.Config{}, "url", "", "repos/app", false); slices.Contains(got, "-c") {
+		t.Errorf("unset git_protocol_version should leave git's default, got git %v", got)
+	}
+	v := 0
+	got := cloneArgs(&config.Config{GitProtocolVersion: &v}, "url", "", "repos/app", false)
+	want := []string{"-c", "protocol.version=0", "clone", "--depth", "1", "url", "repos/app"}
+	if !slices.Equal(got, want) {
+		t.Errorf("git %v, want git %v", got, want)
+	}
+}
+
+func TestSparseCheckoutCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		global []string
+		repo   []string
+		want   []string
+	}{
+		{"global", []string{"services/billing", "ansible"}, nil, []string{"services/billing", "ansible"}},
+		{"repository override", []string{"services/billing"}, []string{"apps/web"}, []string{"apps/web"}},
+	}
+	for _, tt := range tests {
+		t.Chdir(t.TempDir())
+		cfg := testConfig()
+		cfg.SparseCheckoutPaths = tt.global
+		f := &runner.Fake{}
+		var res report.Result
+		proj := config.RepoSpec{RepoPath: "team/app", SparseCheckoutPaths: tt.repo}
+		if err := cloneAndCreateBranch(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, proj, false, nil, &res); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var clone, sparse, checkout []string
+		for _, c := range f.Calls() {
+			switch {
+			case c.Args[0] == "clone":
+				clone = c.Args
+			case c.Args[0] == "sparse-checkout":
+				sparse = c.Args
+			case c.Args[0] == "checkout" && checkout == nil:
+				checkout = c.Args
+			}
+		}
+		if !slices.Contains(clone, "--no-checkout") {
+			t.Errorf("%s: git %v should clone without a checkout", tt.name, clone)
+		}
+		if want := append([]string{"sparse-checkout", "set"}, tt.want...); !slices.Equal(sparse, want) {
+			t.Errorf("%s: git %v, want git %v", tt.name, sparse, want)
+		}
+		if want := []string{"checkout", "main"}; !slices.Equal(checkout, want) {
+			t.Errorf("%s: first checkout git %v, want git %v", tt.name, checkout, want)
+		}
+	}
+
+	// Without sparse paths the clone checks out as usual
+	t.Chdir(t.TempDir())
+	f := &runner.Fake{}
+	var res report.Result
+	cfg := testConfig()
+	if err := cloneAndCreateBranch(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil, &res); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range f.Calls() {
+		if c.Args[0] == "sparse-checkout" || slices.Contains(c.Args, "--no-checkout") {
+			t.Errorf("unexpected sparse checkout: git %v", c.Args)
+		}
+	}
+}
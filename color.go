@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Values accepted by -color
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences used for log coloring
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiGreen  = "\x1b[32m"
+	ansiReset  = "\x1b[0m"
+)
+
+// useColor decides whether log output is colored. In auto mode color is used
+// only on a terminal and when NO_COLOR is not set.
+func useColor(mode string, isTerminal, noColor bool) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto, "":
+		return isTerminal && !noColor, nil
+	default:
+		return false, fmt.Errorf("-color must be %q, %q or %q", colorAuto, colorAlways, colorNever)
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorWriter colors whole log lines by the marker they carry: errors red,
+// warnings yellow and successes green. The log package writes one line per
+// call, so each write is colored as a unit.
+type colorWriter struct {
+	w io.Writer
+}
+
+// lineColors maps log markers to their color, checked in order
+var lineColors = []struct {
+	marker []byte
+	color  string
+}{
+	{[]byte("❌"), ansiRed},
+	{[]byte("⚠️"), ansiYellow},
+	{[]byte("✅"), ansiGreen},
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	for _, lc := range lineColors {
+		if !bytes.Contains(p, lc.marker) {
+			continue
+		}
+		line := bytes.TrimSuffix(p, []byte("\n"))
+		colored := make([]byte, 0, len(p)+len(lc.color)+len(ansiReset))
+		colored = append(colored, lc.color...)
+		colored = append(colored, line...)
+		colored = append(colored, ansiReset...)
+		if len(line) < len(p) {
+			colored = append(colored, '\n')
+		}
+		if _, err := c.w.Write(colored); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
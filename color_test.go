@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUseColor(t *testing.T) {
+	tests := []struct {
+		mode              string
+		terminal, noColor bool
+		want              bool
+	}{
+		{"", true, false, true},
+		{colorAuto, true, false, true},
+		{colorAuto, false, false, false},
+		{colorAuto, true, true, false},
+		{colorAlways, false, true, true},
+		{colorNever, true, false, false},
+	}
+	for _, tt := range tests {
+		got, err := useColor(tt.mode, tt.terminal, tt.noColor)
+		if err != nil {
+			t.Errorf("-color %q: %v", tt.mode, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("-color %q, terminal %t, NO_COLOR %t: got %t, want %t", tt.mode, tt.terminal, tt.noColor, got, tt.want)
+		}
+	}
+	if _, err := useColor("sometimes", true, false); err == nil {
+		t.Error("an unknown -color mode should be an error")
+	}
+}
+
+func TestColorWriter(t *testing.T) {
+	var out strings.Builder
+	w := colorWriter{w: &out}
+	for _, line := range []string{"❌ Failed\n", "⚠️  Warning: slow\n", "✅ Done\n", "🔧 Running\n"} {
+		if n, err := w.Write([]byte(line)); err != nil || n != len(line) {
+			t.Errorf("Write(%q) = %d, %v, want %d, nil", line, n, err, len(line))
+		}
+	}
+	want := ansiRed + "❌ Failed" + ansiReset + "\n" + ansiYellow + "⚠️  Warning: slow" + ansiReset + "\n" + ansiGreen + "✅ Done" + ansiReset + "\n🔧 Running\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
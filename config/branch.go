@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Values accepted by feature_branch_suffix
+const (
+	FeatureBranchSuffixNone      = "none"
+	FeatureBranchSuffixDate      = "date"
+	FeatureBranchSuffixTimestamp = "timestamp"
+	FeatureBranchSuffixRunID     = "runid"
+)
+
+// ApplyFeatureBranchSuffix appends the configured feature_branch_suffix to
+// FeatureBranch, using now for the date-based suffixes and RunID for "runid",
+// and checks that the result is a valid branch name. It must be called once,
+// after RunID is set.
+func (c *Config) ApplyFeatureBranchSuffix(now time.Time) error {
+	c.BaseFeatureBranch = c.FeatureBranch
+
+	var suffix string
+	switch c.FeatureBranchSuffix {
+	case "", FeatureBranchSuffixNone:
+	case FeatureBranchSuffixDate:
+		suffix = now.UTC().Format("20060102")
+	case FeatureBranchSuffixTimestamp:
+		suffix = now.UTC().Format("20060102-150405")
+	case FeatureBranchSuffixRunID:
+		if c.RunID == "" {
+			return fmt.Errorf("feature_branch_suffix %q needs a run ID", FeatureBranchSuffixRunID)
+		}
+		suffix = c.RunID
+	default:
+		return fmt.Errorf("unknown feature_branch_suffix %q", c.FeatureBranchSuffix)
+	}
+	if suffix != "" {
+		c.FeatureBranch += "-" + suffix
+	}
+
+	if err := ValidBranchName(c.FeatureBranch); err != nil {
+		return fmt.Errorf("feature branch %q: %w", c.FeatureBranch, err)
+	}
+	return nil
+}
+
+// FeatureBranchBase returns feature_branch without its suffix, which every
+// feature branch of this configuration starts with
+func (c *Config) FeatureBranchBase() string {
+	if c.BaseFeatureBranch != "" {
+		return c.BaseFeatureBranch
+	}
+	return c.FeatureBranch
+}
+
+// ValidBranchName checks name against git's rules for branch names (see
+// git check-ref-format)
+func ValidBranchName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("branch name must not be empty")
+	case name == "@":
+		return fmt.Errorf("branch name must not be %q", name)
+	case strings.HasPrefix(name, "-"):
+		return fmt.Errorf("branch name must not start with %q", "-")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return fmt.Errorf("branch name must not start or end with %q", "/")
+	case strings.HasSuffix(name, "."):
+		return fmt.Errorf("branch name must not end with %q", ".")
+	}
+	for _, seq := range []string{"..", "//", "@{"} {
+		if strings.Contains(name, seq) {
+			return fmt.Errorf("branch name must not contain %q", seq)
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return fmt.Errorf("branch name must not contain %q", r)
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return fmt.Errorf("branch name component %q must not start with %q or end with %q", part, ".", ".lock")
+		}
+	}
+	return nil
+}
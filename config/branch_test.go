@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyFeatureBranchSuffix(t *testing.T) {
+	now := time.Date(2024, 6, 1, 14, 30, 5, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		suffix string
+		want   string
+	}{
+		{"", "update-deps"},
+		{FeatureBranchSuffixNone, "update-deps"},
+		{FeatureBranchSuffixDate, "update-deps-20240601"},
+		{FeatureBranchSuffixTimestamp, "update-deps-20240601-123005"},
+		{FeatureBranchSuffixRunID, "update-deps-run42"},
+	}
+	for _, tt := range tests {
+		c := &Config{FeatureBranch: "update-deps", FeatureBranchSuffix: tt.suffix, RunID: "run42"}
+		if err := c.ApplyFeatureBranchSuffix(now); err != nil {
+			t.Errorf("suffix %q: %v", tt.suffix, err)
+			continue
+		}
+		if c.FeatureBranch != tt.want {
+			t.Errorf("suffix %q: feature branch %q, want %q", tt.suffix, c.FeatureBranch, tt.want)
+		}
+		if got := c.FeatureBranchBase(); got != "update-deps" {
+			t.Errorf("suffix %q: base %q, want %q", tt.suffix, got, "update-deps")
+		}
+	}
+}
+
+func TestApplyFeatureBranchSuffixErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"runid without run ID", Config{FeatureBranch: "update-deps", FeatureBranchSuffix: FeatureBranchSuffixRunID}, "needs a run ID"},
+		{"unknown suffix", Config{FeatureBranch: "update-deps", FeatureBranchSuffix: "weekly"}, "unknown feature_branch_suffix"},
+		{"invalid ref", Config{FeatureBranch: "update deps", FeatureBranchSuffix: FeatureBranchSuffixDate}, "must not contain"},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.ApplyFeatureBranchSuffix(time.Now())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
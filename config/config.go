@@ -1,39 +1,309 @@
 package config
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // RepoSpec represents a GitLab repository specification with its path and role
 type RepoSpec struct {
-	RepoPath string `yaml:"path"`
-	RoleName string `yaml:"role"`
+	RepoPath   string `yaml:"path"`
+	RoleName   string `yaml:"role"`
+	Visibility string `yaml:"visibility,omitempty"` // GitLab visibility, set for auto-discovered projects
+	EmptyRepo  bool   `yaml:"empty_repo,omitempty"` // Whether the repository has no commits yet
+	Forked     bool   `yaml:"forked,omitempty"`     // Whether the repository is a fork of another project
+	Language   string `yaml:"language,omitempty"`   // Dominant language reported by GitLab, set by -with-languages discovery
+	// Group or user namespace the repository was discovered in; not exported
+	SourceGroup string `yaml:"-"`
+
+	TargetBranch  string `yaml:"target_branch,omitempty"`  // Overrides the global target_branch for this repository
+	FeatureBranch string `yaml:"feature_branch,omitempty"` // Overrides the global feature_branch; feature_branch_suffix still applies
+
+	Env map[string]string `yaml:"env,omitempty"` // Environment for this repository's Ansible run; overrides the global env
+	// Playbooks run for this repository instead of the global ansible_playbook
+	AnsiblePlaybook Playbooks `yaml:"ansible_playbook,omitempty"`
+	// Paths checked out for this repository instead of the global sparse_checkout_paths
+	SparseCheckoutPaths []string `yaml:"sparse_checkout_paths,omitempty"`
+
+	// Overrides of the global clone_timeout, clone_retries and ansible_timeout for outlier repositories
+	CloneTimeout   time.Duration `yaml:"clone_timeout,omitempty"`
+	CloneRetries   *int          `yaml:"clone_retries,omitempty"`
+	AnsibleTimeout time.Duration `yaml:"ansible_timeout,omitempty"`
+}
+
+// Playbooks is a list of playbook paths that may also be written in YAML as a
+// single string
+type Playbooks []string
+
+// UnmarshalYAML accepts either a single playbook path or a list of them
+func (p *Playbooks) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*p = Playbooks{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// Values accepted by on_missing_branch
+const (
+	OnMissingBranchError      = "error"
+	OnMissingBranchUseDefault = "use-default"
+)
+
+// Values accepted by git_lfs
+const (
+	GitLFSAuto  = "auto"
+	GitLFSSkip  = "skip"
+	GitLFSFetch = "fetch"
+)
+
+// Values accepted by ansible_output
+const (
+	AnsibleOutputStream = "stream"
+	AnsibleOutputLine   = "line"
+	AnsibleOutputRun    = "run"
+)
+
+// Values accepted by sort_by
+const (
+	SortByPath = "path"
+	SortByRole = "role"
+	SortByNone = "none"
+)
+
+// Values accepted by temp_retention
+const (
+	TempRetentionAlwaysClean   = "always-clean"
+	TempRetentionKeepOnFailure = "keep-on-failure"
+	TempRetentionKeep          = "keep"
+)
+
+// Values accepted by on_existing
+const (
+	OnExistingError   = "error"
+	OnExistingReuse   = "reuse"
+	OnExistingClobber = "clobber"
+)
+
+// AutoDiscover configures which GitLab groups are scanned for projects
+type AutoDiscover struct {
+	Group  string   `yaml:"group"`
+	Groups []string `yaml:"groups,omitempty"` // Additional groups scanned alongside group
+	User   string   `yaml:"user,omitempty"`   // User whose personal namespace is scanned instead of groups
 }
 
+// Values accepted by dir_naming
+const (
+	DirNamingBasename = "basename"
+	DirNamingFullPath = "full-path"
+)
+
+// Values accepted by on_empty_repo
+const (
+	OnEmptyRepoSkip = "skip"
+	OnEmptyRepoInit = "init"
+)
+
+// Values accepted by commit_signing_format
+const (
+	SigningFormatGPG = "gpg"
+	SigningFormatSSH = "ssh"
+)
+
 // Config represents the application's configuration structure
 type Config struct {
 	GitlabURL     string            `yaml:"gitlab_url"`
 	FeatureBranch string            `yaml:"feature_branch"`
 	TargetBranch  string            `yaml:"target_branch"`
 	AnsibleRoles  map[string]string `yaml:"ansible_roles"`
-	Projects      []RepoSpec        `yaml:"projects"`
-	AutoDiscover  *struct {
-		Group string `yaml:"group"`
-	} `yaml:"auto_discover,omitempty"`
-	Cleanup bool `yaml:"cleanup"` // Whether to clean up cloned repositories after processing
+	// Desired dependency versions (dependency → version), e.g. "org.slf4j:slf4j-api": "2.0.13"
+	Updates map[string]string `yaml:"updates"`
+	// Whether to skip Ansible and the MR for repositories already at the desired versions
+	SkipIfUpToDate bool `yaml:"skip_if_up_to_date"`
+	// Whether roller itself rewrites the dependency file to the updates versions before Ansible runs,
+	// so the bump is part of the merge request
+	BumpDependencies bool `yaml:"bump_dependencies"`
+	// Name prefixes of internal packages shared between repositories (e.g. "com.example:", "@example/"),
+	// which -export-graph is limited to; empty includes every dependency
+	InternalPackagePrefixes []string `yaml:"internal_package_prefixes"`
+	// External command run with the repository path as its last argument; its output names the role
+	RoleDetectorCommand string `yaml:"role_detector_command"`
+	// Extra dependency file names mapped to the role they indicate (e.g. "deps.edn": "clojure");
+	// they take precedence over the built-in detectors
+	CustomDetectors map[string]string `yaml:"custom_detectors"`
+	// Directories skipped while looking for dependency files, on top of DefaultDetectIgnoreDirs;
+	// a bare name matches at any depth, a path with a slash only relative to the repository root
+	DetectIgnoreDirs []string `yaml:"detect_ignore_dirs"`
+	// Whether repositories whose detected role has no ansible_roles entry are failed instead of run
+	StrictRoleMapping bool `yaml:"strict_role_mapping"`
+	// Role recorded for repositories whose role can't be detected, e.g. "unknown", so they stay
+	// visible and filterable; empty leaves the role blank
+	UnknownRole string `yaml:"unknown_role"`
+	// Roles that are discovered and exported but never processed automatically
+	ManualOnlyRoles      []string      `yaml:"manual_only_roles"`
+	Projects             []RepoSpec    `yaml:"projects"`
+	ProjectsFile         string        `yaml:"projects_file,omitempty"` // Extra repository paths, as a newline list or a YAML projects block
+	AutoDiscover         *AutoDiscover `yaml:"auto_discover,omitempty"`
+	DiscoveryConcurrency int           `yaml:"discovery_concurrency"` // Number of groups fetched in parallel; defaults to 1
+	VisibilityFilter     string        `yaml:"visibility_filter"`     // Only discover projects with this visibility: private, internal or public; empty for all
+	MaxRepoSizeMB        int           `yaml:"max_repo_size_mb"`      // Skip discovered repositories larger than this; zero for no limit
+	ProjectLabelFilter   string        `yaml:"project_label_filter"`  // Only discover projects tagged with this GitLab topic; empty for all
+	SortBy               string        `yaml:"sort_by"`               // Order of exported projects: "path" (default), "role" or "none" for API order
+	SkipForks            bool          `yaml:"skip_forks"`            // Leave projects forked from another project out of discovery
+	PipelineDiscovery    bool          `yaml:"pipeline_discovery"`    // Start processing discovered projects while discovery is still listing groups, one group at a time
+	Cleanup              bool          `yaml:"cleanup"`               // Whether to clean up cloned repositories after processing
+	RebaseOnTarget       bool          `yaml:"rebase_on_target"`      // Whether to rebase the feature branch onto the latest target branch before running Ansible
+
+	// Target and feature branches for projects discovered in a group (or its subgroups), keyed by
+	// group path; they override the global branches, and per-project settings override them
+	GroupDefaults map[string]GroupDefaults `yaml:"group_defaults,omitempty"`
+
+	// Appended to feature_branch with a "-": "date" (YYYYMMDD), "timestamp" (YYYYMMDD-HHMMSS, UTC),
+	// "runid" or "none" (default), so scheduled runs don't collide with earlier branches
+	FeatureBranchSuffix string `yaml:"feature_branch_suffix"`
+	// feature_branch as configured, before feature_branch_suffix was applied
+	BaseFeatureBranch string `yaml:"-"`
+
+	// GitLab API connection settings
+	APIBasePath     string `yaml:"api_base_path"`      // Path of the REST API below gitlab_url; defaults to "/api/v4"
+	MaxIdleConns    int    `yaml:"max_idle_conns"`     // Idle keep-alive connections kept to GitLab; defaults to 16
+	MaxConnsPerHost int    `yaml:"max_conns_per_host"` // Cap on open connections to GitLab; zero for no limit
+
+	CloneDelay  time.Duration `yaml:"clone_delay"` // Pause between starting consecutive clones (e.g. "2s"); zero disables it
+	Concurrency int           `yaml:"concurrency"` // Number of projects processed in parallel; defaults to 1
+	// Whether to run fewer than concurrency workers while the system load average exceeds
+	// adaptive_load_threshold (Linux only); defaults to the number of CPUs
+	AdaptiveConcurrency   bool    `yaml:"adaptive_concurrency"`
+	AdaptiveLoadThreshold float64 `yaml:"adaptive_load_threshold"`
+
+	CloneSubmodules bool          `yaml:"clone_submodules"` // Whether to also clone git submodules
+	CloneRetries    int           `yaml:"clone_retries"`    // Extra attempts for a failing clone
+	CloneTimeout    time.Duration `yaml:"clone_timeout"`    // Limit on each clone attempt, on top of the per-repository timeout; zero for none
+	// Whether to create the feature branch through the GitLab API instead of cloning when Ansible is not run
+	CreateBranchViaAPI bool `yaml:"create_branch_via_api"`
+	// Size of repos/ in megabytes past which no further repositories are cloned; zero for no limit
+	MaxWorkspaceSizeMB int `yaml:"max_workspace_size_mb"`
+	// Git wire protocol version (0, 1 or 2) forced for clones; unset uses git's default
+	GitProtocolVersion *int `yaml:"git_protocol_version"`
+	// Extra attempts for a feature branch checkout that fails on a locked index; defaults to 2
+	CheckoutRetries *int `yaml:"checkout_retries"`
+	// Maximum git clone/fetch/pull/push operations started per second across all workers; zero for no limit
+	GitRateLimit float64 `yaml:"git_rate_limit"`
+	// How clone directories under repos/ are named: "basename" (default) or "full-path", which
+	// uses the whole namespaced path so same-named repos in different groups don't collide
+	DirNaming string `yaml:"dir_naming"`
+	// Whether clones mirror the GitLab namespace under repos/, e.g. repos/group/subgroup/repo;
+	// overrides dir_naming
+	PreserveNamespace bool `yaml:"preserve_namespace"`
+	// How Git LFS objects are handled: "auto" (default) downloads them after the clone only if
+	// .gitattributes uses LFS, "skip" leaves the pointer files, "fetch" always runs git lfs pull
+	GitLFS string `yaml:"git_lfs"`
+	// What to do when a repository's clone directory already exists: "error" (default) fails the
+	// repository, "reuse" continues in the existing clone, "clobber" removes it and clones afresh
+	// after confirmation
+	OnExisting string `yaml:"on_existing"`
+	// Whether an existing clone with uncommitted changes may be reused
+	AllowDirty bool `yaml:"allow_dirty"`
+	// Directories, relative to the repository root, that are checked out with a sparse checkout;
+	// empty checks out the whole tree
+	SparseCheckoutPaths []string `yaml:"sparse_checkout_paths"`
+
+	// What to do with repositories that have no commits: "skip" (default) leaves them out of
+	// discovery, "init" clones them and starts the feature branch from scratch
+	OnEmptyRepo string `yaml:"on_empty_repo"`
+	// Whether the temporary clone directory is removed after discovery: "always-clean" (default),
+	// "keep-on-failure" keeps it when a clone or role detection failed, "keep" never removes it
+	TempRetention string `yaml:"temp_retention"`
+
+	// What to do when target_branch does not exist in a repository: "error" (default) skips the
+	// repository, "use-default" clones the repository's default branch instead
+	OnMissingBranch string `yaml:"on_missing_branch"`
+
+	// Ansible settings
+	Env               map[string]string `yaml:"env"`                 // Environment passed to every Ansible run
+	AnsibleRetries    int               `yaml:"ansible_retries"`     // Extra attempts for a failing ansible-playbook run
+	AnsibleRetryDelay time.Duration     `yaml:"ansible_retry_delay"` // Pause between Ansible attempts; defaults to 10s
+	AnsibleTimeout    time.Duration     `yaml:"ansible_timeout"`     // Limit on a repository's Ansible run, on top of the per-repository timeout; zero for none
+	// Playbook or list of playbooks run in order, relative to the workspace root; defaults to ansible/site.yml
+	AnsiblePlaybook Playbooks `yaml:"ansible_playbook"`
+	// Whether the remaining playbooks still run after one fails; the failures are still reported
+	AnsibleContinueOnError bool `yaml:"ansible_continue_on_error"`
+	// How ansible-playbook output is shown: "stream" passes it through as is, "line" prefixes each
+	// line with the repository and never splits one, "run" shows each run's output as one block
+	// when it finishes; defaults to "line" with a concurrency above 1 and "stream" otherwise
+	AnsibleOutput string `yaml:"ansible_output"`
+	// Whether a failed Ansible run fails the repository (the default); false only logs a warning
+	// and still counts the repository as successful
+	AnsibleFailureFatal *bool `yaml:"ansible_failure_fatal"`
+	// Whether Ansible runs once per role after all repositories are cloned, with the clone
+	// directories passed in the roller_repo_paths extra-var, instead of once per repository
+	BatchAnsible bool `yaml:"batch_ansible"`
+	// ansible-playbook binary, as a name looked up in PATH or a full path; defaults to "ansible-playbook"
+	AnsiblePath string `yaml:"ansible_path"`
+	// Python interpreter passed to the playbook as the ansible_python_interpreter extra-var
+	AnsiblePythonInterpreter string `yaml:"ansible_python_interpreter"`
+
+	// Merge request settings, used when create_merge_request is enabled
+	CreateMergeRequest   bool     `yaml:"create_merge_request"`    // Whether to commit, push and open an MR for changes made by Ansible
+	MRLabels             []string `yaml:"mr_labels"`               // Labels applied to created MRs
+	MRAssigneeIDs        []int    `yaml:"mr_assignee_ids"`         // GitLab user IDs assigned to created MRs
+	MRRemoveSourceBranch bool     `yaml:"mr_remove_source_branch"` // Whether the feature branch is deleted once the MR is merged
+	MRAutoMerge          bool     `yaml:"mr_auto_merge"`           // Whether created MRs are set to merge when their pipeline succeeds
+	// Go templates for the MR title and description; {{.RepoName}}, {{.Role}},
+	// {{.FeatureBranch}} and {{.TargetBranch}} are available
+	MRTitle       string `yaml:"mr_title"`
+	MRDescription string `yaml:"mr_description"`
+
+	// Commit signing for the automated commit
+	CommitSign          bool   `yaml:"commit_sign"`           // Whether to sign the automated commit
+	CommitSigningKey    string `yaml:"commit_signing_key"`    // GPG key ID or path to the SSH signing key
+	CommitSigningFormat string `yaml:"commit_signing_format"` // "gpg" (default) or "ssh"
+
+	// Retry settings
+	APIRetries int `yaml:"api_retries"` // Extra attempts for GitLab API reads failing with a network error or 5xx/429
+	// Cap on retries (clone, API and Ansible) across the whole run; once spent, failures are final. Zero means no cap.
+	TotalRetryBudget int `yaml:"total_retry_budget"`
+
+	// Failure tolerance
+	// Highest share of failed repositories (0–1) tolerated before the run exits non-zero; unset never fails the run
+	MaxFailureRatio *float64 `yaml:"max_failure_ratio"`
+
+	// Traceability settings
+	RecordRunID bool   `yaml:"record_run_id"` // Whether each clone records the run ID as git config roller.runId
+	RunID       string `yaml:"-"`             // Identifier of the current run, set at startup rather than read from the file
 }
 
 // Validate checks if the configuration is valid and returns all validation errors
 func (c *Config) Validate() error {
+	return c.validate(LoadOptions{})
+}
+
+// validate is Validate, relaxing the checks opts makes unnecessary
+func (c *Config) validate(opts LoadOptions) error {
 	var errs []string
 
 	if c.GitlabURL == "" {
-		errs = append(errs, "gitlab_url is required")
+		if !opts.LocalOnly {
+			errs = append(errs, "gitlab_url is required")
+		}
 	} else if !strings.HasPrefix(c.GitlabURL, "http://") && !strings.HasPrefix(c.GitlabURL, "https://") {
 		errs = append(errs, "gitlab_url must start with http:// or https://")
 	}
@@ -45,9 +315,219 @@ func (c *Config) Validate() error {
 		errs = append(errs, "target_branch is required")
 	}
 
+	switch c.VisibilityFilter {
+	case "", "private", "internal", "public":
+	default:
+		errs = append(errs, "visibility_filter must be private, internal or public")
+	}
+
+	switch c.DirNaming {
+	case "", DirNamingBasename, DirNamingFullPath:
+	default:
+		errs = append(errs, fmt.Sprintf("dir_naming must be %q or %q", DirNamingBasename, DirNamingFullPath))
+	}
+
+	switch c.OnEmptyRepo {
+	case "", OnEmptyRepoSkip, OnEmptyRepoInit:
+	default:
+		errs = append(errs, fmt.Sprintf("on_empty_repo must be %q or %q", OnEmptyRepoSkip, OnEmptyRepoInit))
+	}
+
+	switch c.OnMissingBranch {
+	case "", OnMissingBranchError, OnMissingBranchUseDefault:
+	default:
+		errs = append(errs, fmt.Sprintf("on_missing_branch must be %q or %q", OnMissingBranchError, OnMissingBranchUseDefault))
+	}
+
+	switch c.AnsibleOutput {
+	case "", AnsibleOutputStream, AnsibleOutputLine, AnsibleOutputRun:
+	default:
+		errs = append(errs, fmt.Sprintf("ansible_output must be %q, %q or %q", AnsibleOutputStream, AnsibleOutputLine, AnsibleOutputRun))
+	}
+
+	switch c.GitLFS {
+	case "", GitLFSAuto, GitLFSSkip, GitLFSFetch:
+	default:
+		errs = append(errs, fmt.Sprintf("git_lfs must be %q, %q or %q", GitLFSAuto, GitLFSSkip, GitLFSFetch))
+	}
+
+	switch c.SortBy {
+	case "", SortByPath, SortByRole, SortByNone:
+	default:
+		errs = append(errs, fmt.Sprintf("sort_by must be %q, %q or %q", SortByPath, SortByRole, SortByNone))
+	}
+
+	switch c.TempRetention {
+	case "", TempRetentionAlwaysClean, TempRetentionKeepOnFailure, TempRetentionKeep:
+	default:
+		errs = append(errs, fmt.Sprintf("temp_retention must be %q, %q or %q", TempRetentionAlwaysClean, TempRetentionKeepOnFailure, TempRetentionKeep))
+	}
+
+	switch c.FeatureBranchSuffix {
+	case "", FeatureBranchSuffixNone, FeatureBranchSuffixDate, FeatureBranchSuffixTimestamp, FeatureBranchSuffixRunID:
+	default:
+		errs = append(errs, fmt.Sprintf("feature_branch_suffix must be %q, %q, %q or %q", FeatureBranchSuffixDate, FeatureBranchSuffixTimestamp, FeatureBranchSuffixRunID, FeatureBranchSuffixNone))
+	}
+
+	switch c.OnExisting {
+	case "", OnExistingError, OnExistingReuse, OnExistingClobber:
+	default:
+		errs = append(errs, fmt.Sprintf("on_existing must be %q, %q or %q", OnExistingError, OnExistingReuse, OnExistingClobber))
+	}
+
+	if c.AutoDiscover != nil {
+		hasGroups := len(c.DiscoveryGroups()) > 0
+		if hasGroups == (c.AutoDiscover.User != "") {
+			errs = append(errs, "auto_discover needs exactly one of group/groups or user")
+		}
+	}
+
+	if c.APIBasePath != "" && !strings.HasPrefix(c.APIBasePath, "/") {
+		errs = append(errs, "api_base_path must start with /")
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, "max_idle_conns must not be negative")
+	}
+	if c.MaxConnsPerHost < 0 {
+		errs = append(errs, "max_conns_per_host must not be negative")
+	}
+	if c.DiscoveryConcurrency < 0 {
+		errs = append(errs, "discovery_concurrency must not be negative")
+	}
+	if c.MaxRepoSizeMB < 0 {
+		errs = append(errs, "max_repo_size_mb must not be negative")
+	}
+	if c.Concurrency < 0 {
+		errs = append(errs, "concurrency must not be negative")
+	}
+	if c.CloneRetries < 0 {
+		errs = append(errs, "clone_retries must not be negative")
+	}
+	if c.AdaptiveLoadThreshold < 0 {
+		errs = append(errs, "adaptive_load_threshold must not be negative")
+	}
+	if c.APIRetries < 0 {
+		errs = append(errs, "api_retries must not be negative")
+	}
+	if c.TotalRetryBudget < 0 {
+		errs = append(errs, "total_retry_budget must not be negative")
+	}
+	if c.AnsibleRetries < 0 {
+		errs = append(errs, "ansible_retries must not be negative")
+	}
+	if c.CheckoutRetries != nil && *c.CheckoutRetries < 0 {
+		errs = append(errs, "checkout_retries must not be negative")
+	}
+	if c.MaxWorkspaceSizeMB < 0 {
+		errs = append(errs, "max_workspace_size_mb must not be negative")
+	}
+	if c.AnsibleTimeout < 0 {
+		errs = append(errs, "ansible_timeout must not be negative")
+	}
+	if c.AnsibleRetryDelay < 0 {
+		errs = append(errs, "ansible_retry_delay must not be negative")
+	}
+	if v := c.GitProtocolVersion; v != nil && (*v < 0 || *v > 2) {
+		errs = append(errs, "git_protocol_version must be 0, 1 or 2")
+	}
+	if v := c.MaxFailureRatio; v != nil && (*v < 0 || *v > 1) {
+		errs = append(errs, "max_failure_ratio must be between 0 and 1")
+	}
+	if c.GitRateLimit < 0 {
+		errs = append(errs, "git_rate_limit must not be negative")
+	}
+	if c.CloneTimeout < 0 {
+		errs = append(errs, "clone_timeout must not be negative")
+	}
+	if c.CloneDelay < 0 {
+		errs = append(errs, "clone_delay must not be negative")
+	}
+
+	if _, err := template.New("mr_title").Parse(c.MRTitle); err != nil {
+		errs = append(errs, fmt.Sprintf("mr_title is not a valid template: %v", err))
+	}
+	if _, err := template.New("mr_description").Parse(c.MRDescription); err != nil {
+		errs = append(errs, fmt.Sprintf("mr_description is not a valid template: %v", err))
+	}
+
+	if c.CommitSign {
+		if c.CommitSigningKey == "" {
+			errs = append(errs, "commit_signing_key is required when commit_sign is enabled")
+		}
+		switch c.CommitSigningFormat {
+		case "", SigningFormatGPG, SigningFormatSSH:
+		default:
+			errs = append(errs, fmt.Sprintf("commit_signing_format must be %q or %q", SigningFormatGPG, SigningFormatSSH))
+		}
+	}
+
+	detectorFiles := make([]string, 0, len(c.CustomDetectors))
+	for file := range c.CustomDetectors {
+		detectorFiles = append(detectorFiles, file)
+	}
+	sort.Strings(detectorFiles)
+	for _, file := range detectorFiles {
+		role := c.CustomDetectors[file]
+		if strings.TrimSpace(file) == "" {
+			errs = append(errs, "custom_detectors file names must not be empty")
+		}
+		if strings.TrimSpace(role) == "" {
+			errs = append(errs, fmt.Sprintf("custom_detectors[%q] must name a role", file))
+		}
+	}
+
+	errs = append(errs, validateRepoPaths("detect_ignore_dirs", c.DetectIgnoreDirs)...)
+
+	for i, playbook := range c.AnsiblePlaybook {
+		if strings.TrimSpace(playbook) == "" {
+			errs = append(errs, fmt.Sprintf("ansible_playbook[%d] must not be empty", i))
+		}
+	}
+
+	groups := make([]string, 0, len(c.GroupDefaults))
+	for group := range c.GroupDefaults {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		if strings.TrimSpace(group) == "" {
+			errs = append(errs, "group_defaults keys must name a group")
+		}
+		if branch := c.GroupDefaults[group].FeatureBranch; branch != "" {
+			if err := ValidBranchName(branch); err != nil {
+				errs = append(errs, fmt.Sprintf("group_defaults[%s].feature_branch: %v", group, err))
+			}
+		}
+	}
+
+	errs = append(errs, validateRepoPaths("sparse_checkout_paths", c.SparseCheckoutPaths)...)
+	for _, proj := range c.Projects {
+		errs = append(errs, validateRepoPaths(fmt.Sprintf("projects[%s].sparse_checkout_paths", proj.RepoPath), proj.SparseCheckoutPaths)...)
+		if proj.FeatureBranch != "" {
+			if err := ValidBranchName(proj.FeatureBranch); err != nil {
+				errs = append(errs, fmt.Sprintf("projects[%s].feature_branch: %v", proj.RepoPath, err))
+			}
+		}
+		if proj.CloneTimeout < 0 {
+			errs = append(errs, fmt.Sprintf("projects[%s].clone_timeout must not be negative", proj.RepoPath))
+		}
+		if proj.CloneRetries != nil && *proj.CloneRetries < 0 {
+			errs = append(errs, fmt.Sprintf("projects[%s].clone_retries must not be negative", proj.RepoPath))
+		}
+		if proj.AnsibleTimeout < 0 {
+			errs = append(errs, fmt.Sprintf("projects[%s].ansible_timeout must not be negative", proj.RepoPath))
+		}
+	}
+
+	for i, label := range c.MRLabels {
+		if strings.TrimSpace(label) == "" {
+			errs = append(errs, fmt.Sprintf("mr_labels[%d] must not be empty", i))
+		}
+	}
+
 	// Validate that at least one source of projects is specified
-	if len(c.Projects) == 0 && (c.AutoDiscover == nil || c.AutoDiscover.Group == "") {
-		errs = append(errs, "either projects or auto_discover.group must be specified")
+	if len(c.Projects) == 0 && !c.HasDiscovery() && !opts.ProjectsFromStdin && !opts.LocalOnly {
+		errs = append(errs, "either projects or auto_discover must be specified")
 	}
 
 	if len(errs) > 0 {
@@ -56,38 +536,475 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-// LoadConfig reads and parses the configuration file from the given path
-func LoadConfig(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// validateRepoPaths checks that every entry of paths is a non-empty path
+// inside the repository
+func validateRepoPaths(field string, paths []string) []string {
+	var errs []string
+	for i, p := range paths {
+		switch {
+		case strings.TrimSpace(p) == "":
+			errs = append(errs, fmt.Sprintf("%s[%d] must not be empty", field, i))
+		case filepath.IsAbs(p) || strings.HasPrefix(p, "/"):
+			errs = append(errs, fmt.Sprintf("%s[%d] must be relative to the repository root: %s", field, i, p))
+		case filepath.Clean(p) == ".." || strings.HasPrefix(filepath.Clean(p), "../"):
+			errs = append(errs, fmt.Sprintf("%s[%d] must stay inside the repository: %s", field, i, p))
+		}
+	}
+	return errs
+}
+
+// ApplyOverlay merges the YAML document in data over the configuration.
+// Values present in the overlay win, lists replace the base list entirely and
+// maps are merged key by key; anything the overlay omits is left untouched.
+// Keys that match no setting are rejected unless allowUnknownFields is set.
+func (c *Config) ApplyOverlay(data []byte, allowUnknownFields bool) error {
+	return decodeStrict(data, c, allowUnknownFields)
+}
+
+// decodeStrict parses a YAML document into v. Unless allowUnknownFields is
+// set, an unknown key is an error naming it and its line, so a misspelled
+// setting isn't silently ignored.
+func decodeStrict(data []byte, v any, allowUnknownFields bool) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(!allowUnknownFields)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// DefaultCheckoutRetries is used when checkout_retries is not configured
+const DefaultCheckoutRetries = 2
+
+// CheckoutRetriesOrDefault returns checkout_retries, or
+// DefaultCheckoutRetries when it is unset
+func (c *Config) CheckoutRetriesOrDefault() int {
+	if c.CheckoutRetries == nil {
+		return DefaultCheckoutRetries
+	}
+	return *c.CheckoutRetries
+}
+
+// AnsibleFailuresFatal reports whether a failed Ansible run fails its
+// repository, which is the case unless ansible_failure_fatal is false
+func (c *Config) AnsibleFailuresFatal() bool {
+	return c.AnsibleFailureFatal == nil || *c.AnsibleFailureFatal
+}
+
+// DefaultDetectIgnoreDirs are the vendored dependency and build output
+// directories role detection never looks into
+var DefaultDetectIgnoreDirs = []string{"node_modules", "vendor", ".venv", "target", "dist"}
+
+// DetectionIgnoredDirs returns DefaultDetectIgnoreDirs followed by
+// detect_ignore_dirs
+func (c *Config) DetectionIgnoredDirs() []string {
+	return append(append([]string(nil), DefaultDetectIgnoreDirs...), c.DetectIgnoreDirs...)
+}
+
+// IsManualOnly reports whether repositories with role are excluded from
+// automatic processing by manual_only_roles
+func (c *Config) IsManualOnly(role string) bool {
+	if role == "" {
+		return false
+	}
+	for _, r := range c.ManualOnlyRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoveryGroups returns the groups configured for auto-discovery, with
+// auto_discover.group first and duplicates removed
+func (c *Config) DiscoveryGroups() []string {
+	if c.AutoDiscover == nil {
+		return nil
+	}
+	var groups []string
+	seen := make(map[string]bool)
+	for _, g := range append([]string{c.AutoDiscover.Group}, c.AutoDiscover.Groups...) {
+		if g == "" || seen[g] {
+			continue
+		}
+		seen[g] = true
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// DiscoveryUser returns the user whose namespace is auto-discovered, if any
+func (c *Config) DiscoveryUser() string {
+	if c.AutoDiscover == nil {
+		return ""
+	}
+	return c.AutoDiscover.User
+}
+
+// DiscoverySource describes what auto-discovery scans, for log messages
+func (c *Config) DiscoverySource() string {
+	if user := c.DiscoveryUser(); user != "" {
+		return "user " + user
+	}
+	return "group " + strings.Join(c.DiscoveryGroups(), ", ")
+}
+
+// HasDiscovery reports whether any groups or a user are configured for
+// auto-discovery
+func (c *Config) HasDiscovery() bool {
+	return len(c.DiscoveryGroups()) > 0 || c.DiscoveryUser() != ""
+}
+
+// Environment variables that override branch settings from the config file
+const (
+	EnvTargetBranch  = "ROLLER_TARGET_BRANCH"
+	EnvFeatureBranch = "ROLLER_FEATURE_BRANCH"
+)
+
+// applyEnvOverrides replaces target_branch and feature_branch with the values
+// of ROLLER_TARGET_BRANCH and ROLLER_FEATURE_BRANCH when those are set
+func (c *Config) applyEnvOverrides() {
+	if v := os.Getenv(EnvTargetBranch); v != "" {
+		c.TargetBranch = v
+	}
+	if v := os.Getenv(EnvFeatureBranch); v != "" {
+		c.FeatureBranch = v
+	}
+}
+
+// Inferred holds settings derived from the environment, such as the origin
+// remote of the current clone, that fill in what the config leaves unset
+type Inferred struct {
+	GitlabURL string
+	Group     string
+}
+
+// applyInferred fills gitlab_url and the discovery group from inf where the
+// config sets neither. A group is only inferred for configs that list no
+// projects, so an explicit project list never turns into a group scan.
+func (c *Config) applyInferred(inf *Inferred) {
+	if inf == nil {
+		return
+	}
+	if c.GitlabURL == "" {
+		c.GitlabURL = inf.GitlabURL
+	}
+	if inf.Group != "" && !c.HasDiscovery() && len(c.Projects) == 0 {
+		if c.AutoDiscover == nil {
+			c.AutoDiscover = &AutoDiscover{}
+		}
+		c.AutoDiscover.Group = inf.Group
+	}
+}
+
+// LoadConfig reads and parses the configuration file from the given path,
+// merging any overlay files over it in order before validating the result.
+// Branch settings are resolved with the precedence: command-line flags
+// (applied by the caller), then ROLLER_TARGET_BRANCH/ROLLER_FEATURE_BRANCH,
+// then overlays, then the config file itself.
+func LoadConfig(path string, overlays ...string) (*Config, error) {
+	return LoadConfigs([]string{path}, LoadOptions{}, overlays...)
+}
+
+// LoadOptions adjusts how LoadConfigs completes and validates the config
+type LoadOptions struct {
+	Inferred          *Inferred // Fills in settings the files leave unset, if given
+	ProjectsFromStdin bool      // Projects are also read from stdin, so the config may name none
+	LocalOnly         bool      // Only repositories already on disk are processed, so neither GitLab nor projects are needed
+	// Keys in config, overlay and projects files that match no setting are accepted instead of rejected
+	AllowUnknownFields bool
+}
+
+// LoadConfigs is LoadConfig for several config files, e.g. one per team.
+// Each file is merged over the previous ones like an overlay, except that
+// their projects lists are concatenated; projects listed more than once are
+// kept where they first appear. Settings the files leave unset are filled
+// from opts.Inferred, if given, before validation.
+func LoadConfigs(paths []string, opts LoadOptions, overlays ...string) (*Config, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no config file given")
 	}
 
 	var c Config
-	if err = yaml.Unmarshal(b, &c); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	projectsFileDir := filepath.Dir(paths[0])
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		projects, projectsFile := c.Projects, c.ProjectsFile
+		c.Projects, c.ProjectsFile = nil, ""
+		if err := decodeStrict(b, &c, opts.AllowUnknownFields); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		c.Projects = append(projects, c.Projects...)
+		// A projects_file is relative to the file that names it
+		if c.ProjectsFile != "" {
+			projectsFileDir = filepath.Dir(path)
+		} else {
+			c.ProjectsFile = projectsFile
+		}
 	}
 
-	if err := c.Validate(); err != nil {
+	for _, overlay := range overlays {
+		b, err := os.ReadFile(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overlay file %s: %w", overlay, err)
+		}
+		if err := c.ApplyOverlay(b, opts.AllowUnknownFields); err != nil {
+			return nil, fmt.Errorf("failed to parse overlay file %s: %w", overlay, err)
+		}
+	}
+
+	c.applyEnvOverrides()
+	c.applyInferred(opts.Inferred)
+
+	if c.ProjectsFile != "" {
+		file := c.ProjectsFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(projectsFileDir, file)
+		}
+		projects, err := LoadProjectsFile(file, opts.AllowUnknownFields)
+		if err != nil {
+			return nil, err
+		}
+		c.Projects = append(c.Projects, projects...)
+	}
+	if len(paths) > 1 {
+		c.Projects = UniqueProjects(c.Projects)
+	}
+
+	if err := c.validate(opts); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	return &c, nil
 }
 
+// LoadProjectsFile reads repository specs from a file holding either a YAML
+// "projects:" block in the config format or a newline-delimited path list.
+// Unknown keys in a YAML block are rejected unless allowUnknownFields is set.
+func LoadProjectsFile(path string, allowUnknownFields bool) ([]RepoSpec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read projects file: %w", err)
+	}
+
+	// A "projects:" key makes it a YAML block, even if the list is empty
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err == nil && hasTopLevelKey(&root, "projects") {
+		var doc struct {
+			Projects []RepoSpec `yaml:"projects"`
+		}
+		if err := decodeStrict(b, &doc, allowUnknownFields); err != nil {
+			return nil, fmt.Errorf("failed to parse projects file %s: %w", path, err)
+		}
+		return doc.Projects, nil
+	}
+
+	projects, err := ParseProjectList(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse projects file %s: %w", path, err)
+	}
+	return projects, nil
+}
+
+// hasTopLevelKey reports whether the YAML document root is a mapping with key
+func hasTopLevelKey(root *yaml.Node, key string) bool {
+	if root.Kind != yaml.DocumentNode || len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+		return false
+	}
+	m := root.Content[0].Content
+	for i := 0; i < len(m); i += 2 {
+		if m[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// UniqueProjects returns projects with later duplicates of the same path
+// removed, keeping the first occurrence
+func UniqueProjects(projects []RepoSpec) []RepoSpec {
+	seen := make(map[string]bool, len(projects))
+	unique := make([]RepoSpec, 0, len(projects))
+	for _, p := range projects {
+		if seen[p.RepoPath] {
+			continue
+		}
+		seen[p.RepoPath] = true
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// SortProjects orders projects in place according to a sort_by value: by
+// path (the default), by role and then path, or not at all for "none"
+func SortProjects(projects []RepoSpec, sortBy string) {
+	switch sortBy {
+	case SortByNone:
+	case SortByRole:
+		sort.SliceStable(projects, func(i, j int) bool {
+			if projects[i].RoleName != projects[j].RoleName {
+				return projects[i].RoleName < projects[j].RoleName
+			}
+			return projects[i].RepoPath < projects[j].RepoPath
+		})
+	default:
+		sort.SliceStable(projects, func(i, j int) bool { return projects[i].RepoPath < projects[j].RepoPath })
+	}
+}
+
+// ParseProjectList reads newline-delimited repository paths from r, ignoring
+// blank lines and lines starting with "#"
+func ParseProjectList(r io.Reader) ([]RepoSpec, error) {
+	var projects []RepoSpec
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		projects = append(projects, RepoSpec{RepoPath: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read project list: %w", err)
+	}
+	return projects, nil
+}
+
+// projectCSVColumns are the columns of a project CSV, in the order assumed
+// when the file has no header row
+var projectCSVColumns = []string{"path", "role", "target_branch"}
+
+// ParseProjectCSV reads repository specs from CSV with the columns path, role
+// and target_branch. A leading header row naming the columns is optional and,
+// when present, lets them appear in any order or be partly omitted. Rows with
+// an empty path are skipped.
+func ParseProjectCSV(r io.Reader) ([]RepoSpec, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := projectCSVColumns
+	firstRow := 1
+	if containsFold(rows[0], "path") {
+		columns = make([]string, len(rows[0]))
+		for i, name := range rows[0] {
+			columns[i] = strings.ToLower(strings.TrimSpace(name))
+		}
+		rows = rows[1:]
+		firstRow = 2
+	}
+
+	var projects []RepoSpec
+	for i, row := range rows {
+		var p RepoSpec
+		for j, value := range row {
+			if j >= len(columns) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			switch columns[j] {
+			case "path":
+				p.RepoPath = value
+			case "role":
+				p.RoleName = value
+			case "target_branch":
+				p.TargetBranch = value
+			}
+		}
+		if p.RepoPath == "" {
+			log.Printf("⚠️  Warning: Skipping project CSV line %d with an empty path", firstRow+i)
+			continue
+		}
+		projects = append(projects, p)
+	}
+	return projects, nil
+}
+
+// containsFold reports whether values contains target, ignoring case and
+// surrounding whitespace
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnknownRoleKey is the key used by the grouped export for projects whose
+// role could not be detected
+const UnknownRoleKey = "unknown"
+
+// ExportOptions controls the layout of the discovered-projects file
+type ExportOptions struct {
+	// GroupByRole writes a "roles" map of role → repository paths instead of
+	// the flat "projects" list
+	GroupByRole bool
+	// Matrix writes a bare list of {PROJECT, ROLE} entries, ready for a GitLab
+	// CI "parallel: matrix:" block; metadata is not recorded in this format
+	Matrix bool
+	// Metadata, when set, is recorded in the file header for provenance
+	Metadata *ExportMetadata
+}
+
+// matrixEntry is one job of a GitLab CI parallel matrix
+type matrixEntry struct {
+	Project string `yaml:"PROJECT"`
+	Role    string `yaml:"ROLE"`
+}
+
+// ExportMetadata records where and when a project list was discovered
+type ExportMetadata struct {
+	SourceGroup  string
+	DiscoveredAt time.Time
+}
+
 // ExportDiscoveredProjects writes the discovered projects to a YAML file
-func ExportDiscoveredProjects(path string, projects []RepoSpec) error {
+func ExportDiscoveredProjects(path string, projects []RepoSpec, opts ExportOptions) error {
 	if len(projects) == 0 {
 		return fmt.Errorf("no projects to export")
 	}
 
-	out := struct {
-		Projects []RepoSpec `yaml:"projects"`
-	}{
-		Projects: projects,
+	var out struct {
+		SourceGroup  string              `yaml:"source_group,omitempty"`
+		DiscoveredAt string              `yaml:"discovered_at,omitempty"`
+		Projects     []RepoSpec          `yaml:"projects,omitempty"`
+		Roles        map[string][]string `yaml:"roles,omitempty"`
+	}
+	if opts.Metadata != nil {
+		out.SourceGroup = opts.Metadata.SourceGroup
+		out.DiscoveredAt = opts.Metadata.DiscoveredAt.UTC().Format(time.RFC3339)
+	}
+	if opts.GroupByRole {
+		out.Roles = groupByRole(projects)
+	} else {
+		out.Projects = projects
 	}
 
-	data, err := yaml.Marshal(out)
+	var doc interface{} = out
+	if opts.Matrix {
+		entries := make([]matrixEntry, len(projects))
+		for i, p := range projects {
+			role := p.RoleName
+			if role == "" {
+				role = UnknownRoleKey
+			}
+			entries[i] = matrixEntry{Project: p.RepoPath, Role: role}
+		}
+		doc = entries
+	}
+
+	data, err := yaml.Marshal(doc)
 	if err != nil {
 		return fmt.Errorf("failed to marshal projects: %w", err)
 	}
@@ -98,3 +1015,57 @@ func ExportDiscoveredProjects(path string, projects []RepoSpec) error {
 
 	return nil
 }
+
+// WriteBootstrapConfig validates c and writes its core settings (GitLab URL,
+// branches, discovery, cleanup and projects) to path as a runnable config.
+// An existing file is never overwritten.
+func WriteBootstrapConfig(path string, c *Config) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("generated configuration is invalid: %w", err)
+	}
+
+	out := struct {
+		GitlabURL     string        `yaml:"gitlab_url"`
+		TargetBranch  string        `yaml:"target_branch"`
+		FeatureBranch string        `yaml:"feature_branch"`
+		AutoDiscover  *AutoDiscover `yaml:"auto_discover,omitempty"`
+		Cleanup       bool          `yaml:"cleanup"`
+		Projects      []RepoSpec    `yaml:"projects"`
+	}{
+		GitlabURL:     c.GitlabURL,
+		TargetBranch:  c.TargetBranch,
+		FeatureBranch: c.FeatureBranch,
+		AutoDiscover:  c.AutoDiscover,
+		Cleanup:       c.Cleanup,
+		Projects:      c.Projects,
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return f.Close()
+}
+
+// groupByRole maps each role to the paths of its projects, keeping project
+// order within a role
+func groupByRole(projects []RepoSpec) map[string][]string {
+	roles := make(map[string][]string)
+	for _, p := range projects {
+		role := p.RoleName
+		if role == "" {
+			role = UnknownRoleKey
+		}
+		roles[role] = append(roles[role], p.RepoPath)
+	}
+	return roles
+}
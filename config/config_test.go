@@ -0,0 +1,452 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeFile writes content to name inside dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const baseConfig = `gitlab_url: https://gitlab.example.com
+target_branch: main
+feature_branch: roller-updates
+`
+
+func TestParseProjectList(t *testing.T) {
+	stdin := strings.NewReader("group/a\n\n# a comment\n  group/sub/b  \n#group/c\n")
+	got, err := ParseProjectList(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RepoSpec{{RepoPath: "group/a"}, {RepoPath: "group/sub/b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseProjectList() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadConfigsRequiresProjectSource(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "roller.yaml", baseConfig)
+
+	_, err := LoadConfigs([]string{path}, LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "either projects or auto_discover must be specified") {
+		t.Fatalf("expected a missing project source error, got %v", err)
+	}
+	if _, err := LoadConfigs([]string{path}, LoadOptions{ProjectsFromStdin: true}); err != nil {
+		t.Errorf("projects from stdin should satisfy the project source check: %v", err)
+	}
+}
+
+func TestLoadConfigsMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeFile(t, dir, "base.yaml", baseConfig+"concurrency: 2\nprojects:\n  - path: team-a/app\n    role: java\n  - path: shared/lib\n    role: java\n"),
+		writeFile(t, dir, "team-b.yaml", "concurrency: 4\nprojects:\n  - path: team-b/api\n    role: python\n  - path: shared/lib\n    role: node\n"),
+		writeFile(t, dir, "team-c.yaml", "feature_branch: team-c-updates\nprojects:\n  - path: team-c/web\n    role: node\n  - path: team-a/app\n"),
+	}
+
+	c, err := LoadConfigs(paths, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Projects are concatenated in file order, keeping the first of each path
+	want := []RepoSpec{
+		{RepoPath: "team-a/app", RoleName: "java"},
+		{RepoPath: "shared/lib", RoleName: "java"},
+		{RepoPath: "team-b/api", RoleName: "python"},
+		{RepoPath: "team-c/web", RoleName: "node"},
+	}
+	if !reflect.DeepEqual(c.Projects, want) {
+		t.Errorf("projects = %v, want %v", c.Projects, want)
+	}
+	// Later files override the scalars they set and leave the rest alone
+	if c.Concurrency != 4 {
+		t.Errorf("concurrency = %d, want 4 from team-b.yaml", c.Concurrency)
+	}
+	if c.FeatureBranch != "team-c-updates" {
+		t.Errorf("feature_branch = %q, want team-c-updates from team-c.yaml", c.FeatureBranch)
+	}
+	if c.TargetBranch != "main" {
+		t.Errorf("target_branch = %q, want main from base.yaml", c.TargetBranch)
+	}
+}
+
+func TestLoadConfigsLocalOnly(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "roller.yaml", "target_branch: main\nfeature_branch: roller-updates\n")
+
+	_, err := LoadConfigs([]string{path}, LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "gitlab_url is required") {
+		t.Fatalf("expected gitlab_url to be required, got %v", err)
+	}
+	if _, err := LoadConfigs([]string{path}, LoadOptions{LocalOnly: true}); err != nil {
+		t.Errorf("a local-only run should need neither gitlab_url nor projects: %v", err)
+	}
+}
+
+func TestLoadConfigsRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name, config, overlay, projects, wantErr string
+	}{
+		{name: "config", config: baseConfig + "feature_brnach: typo\nprojects:\n  - path: team/app\n", wantErr: "field feature_brnach not found"},
+		{name: "overlay", config: baseConfig + "projects:\n  - path: team/app\n", overlay: "concurency: 4\n", wantErr: "field concurency not found"},
+		{name: "projects file", config: baseConfig + "projects_file: projects.yaml\n", projects: "projects:\n  - path: team/app\n    rol: java\n", wantErr: "field rol not found"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := writeFile(t, dir, "roller.yaml", tt.config)
+		var overlays []string
+		if tt.overlay != "" {
+			overlays = append(overlays, writeFile(t, dir, "overlay.yaml", tt.overlay))
+		}
+		if tt.projects != "" {
+			writeFile(t, dir, "projects.yaml", tt.projects)
+		}
+
+		_, err := LoadConfigs([]string{path}, LoadOptions{}, overlays...)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.wantErr, err)
+		}
+		if _, err := LoadConfigs([]string{path}, LoadOptions{AllowUnknownFields: true}, overlays...); err != nil {
+			t.Errorf("%s: unknown fields should be accepted with AllowUnknownFields: %v", tt.name, err)
+		}
+	}
+}
+
+func TestLoadProjectsFile(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          []RepoSpec
+	}{
+		{"newline list", "# team repos\ngroup/a\n\n  group/sub/b\n", []RepoSpec{{RepoPath: "group/a"}, {RepoPath: "group/sub/b"}}},
+		{"projects block", "projects:\n  - path: group/a\n    role: java\n  - path: group/b\n", []RepoSpec{{RepoPath: "group/a", RoleName: "java"}, {RepoPath: "group/b"}}},
+		{"empty projects block", "projects:\n", nil},
+		{"empty projects list", "projects: []\n", []RepoSpec{}},
+	}
+	for _, tt := range tests {
+		got, err := LoadProjectsFile(writeFile(t, t.TempDir(), "projects.txt", tt.content), false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	c := Config{
+		TargetBranch: "main",
+		Concurrency:  2,
+		MRLabels:     []string{"automated", "deps"},
+		AnsibleRoles: map[string]string{"java": "java_app", "node": "node_app"},
+	}
+	overlay := `target_branch: release
+mr_labels: [prod]
+ansible_roles:
+  node: node_prod
+  python: python_app
+`
+	if err := c.ApplyOverlay([]byte(overlay), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.TargetBranch != "release" || c.Concurrency != 2 {
+		t.Errorf("target_branch %s and concurrency %d, want the overlay's release and the base's 2", c.TargetBranch, c.Concurrency)
+	}
+	if want := []string{"prod"}; !reflect.DeepEqual(c.MRLabels, want) {
+		t.Errorf("mr_labels %v, want the overlay list %v replacing the base one", c.MRLabels, want)
+	}
+	want := map[string]string{"java": "java_app", "node": "node_prod", "python": "python_app"}
+	if !reflect.DeepEqual(c.AnsibleRoles, want) {
+		t.Errorf("ansible_roles %v, want the maps merged: %v", c.AnsibleRoles, want)
+	}
+}
+
+func TestValidateCommitSigning(t *testing.T) {
+	c := Config{GitlabURL: "https://gitlab.example.com", TargetBranch: "main", FeatureBranch: "roller-updates", Projects: []RepoSpec{{RepoPath: "team/app"}}, CommitSign: true}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "commit_signing_key is required") {
+		t.Errorf("expected a missing signing key error, got %v", err)
+	}
+	c.CommitSigningKey = "ABCD1234"
+	if err := c.Validate(); err != nil {
+		t.Errorf("signing with a key should be valid: %v", err)
+	}
+}
+
+func TestValidateMRTemplates(t *testing.T) {
+	c := Config{GitlabURL: "https://gitlab.example.com", TargetBranch: "main", FeatureBranch: "roller-updates", Projects: []RepoSpec{{RepoPath: "team/app"}}, MRTitle: "Update {{.RepoName"}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "mr_title is not a valid template") {
+		t.Errorf("expected an invalid mr_title error, got %v", err)
+	}
+}
+
+func TestExportDiscoveredProjectsGroupByRole(t *testing.T) {
+	projects := []RepoSpec{
+		{RepoPath: "team/api", RoleName: "pom"},
+		{RepoPath: "team/web", RoleName: "node"},
+		{RepoPath: "team/core", RoleName: "pom"},
+		{RepoPath: "team/docs"},
+	}
+	path := filepath.Join(t.TempDir(), "discovered.yaml")
+	if err := ExportDiscoveredProjects(path, projects, ExportOptions{GroupByRole: true}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"roles": map[string]any{
+		"pom":          []any{"team/api", "team/core"},
+		"node":         []any{"team/web"},
+		UnknownRoleKey: []any{"team/docs"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := ExportDiscoveredProjects(path, projects, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	flat, err := LoadExportedProjects(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flat) != len(projects) {
+		t.Errorf("flat export holds %d projects, want %d", len(flat), len(projects))
+	}
+}
+
+func TestExportDiscoveredProjectsMetadata(t *testing.T) {
+	projects := []RepoSpec{{RepoPath: "team/api", RoleName: "pom"}}
+	path := filepath.Join(t.TempDir(), "discovered.yaml")
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	if err := ExportDiscoveredProjects(path, projects, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "source_group") || strings.Contains(string(data), "discovered_at") {
+		t.Errorf("plain export should carry no metadata:\n%s", data)
+	}
+
+	meta := &ExportMetadata{SourceGroup: "platform/team", DiscoveredAt: at}
+	if err := ExportDiscoveredProjects(path, projects, ExportOptions{Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		SourceGroup  string     `yaml:"source_group"`
+		DiscoveredAt string     `yaml:"discovered_at"`
+		Projects     []RepoSpec `yaml:"projects"`
+	}
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SourceGroup != "platform/team" || got.DiscoveredAt != "2024-03-01T08:30:00Z" {
+		t.Errorf("got source_group %q, discovered_at %q, want platform/team, 2024-03-01T08:30:00Z", got.SourceGroup, got.DiscoveredAt)
+	}
+	if len(got.Projects) != 1 || got.Projects[0].RepoPath != "team/api" {
+		t.Errorf("got projects %+v, want team/api", got.Projects)
+	}
+}
+
+func TestParseProjectCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []RepoSpec
+	}{
+		{"header", "path,role,target_branch\nteam/api,pom,develop\n\"team/web, legacy\",node,\nteam/lib,,\n",
+			[]RepoSpec{{RepoPath: "team/api", RoleName: "pom", TargetBranch: "develop"}, {RepoPath: "team/web, legacy", RoleName: "node"}, {RepoPath: "team/lib"}}},
+		{"reordered header", "target_branch,owner,PATH\nrelease,alice,team/api\n",
+			[]RepoSpec{{RepoPath: "team/api", TargetBranch: "release"}}},
+		{"no header", "team/api,pom,develop\nteam/lib\n",
+			[]RepoSpec{{RepoPath: "team/api", RoleName: "pom", TargetBranch: "develop"}, {RepoPath: "team/lib"}}},
+		{"empty path", "path,role\n,pom\nteam/api,pom\n",
+			[]RepoSpec{{RepoPath: "team/api", RoleName: "pom"}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseProjectCSV(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ParseProjectCSV(strings.NewReader("path\n\"team/api\n")); err == nil {
+		t.Error("an unterminated quoted field should be an error")
+	}
+}
+
+func TestLoadConfigBranchEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "roller.yaml", baseConfig+"projects:\n  - path: team/app\n")
+	overlay := writeFile(t, dir, "ci.yaml", "feature_branch: overlay-updates\n")
+
+	c, err := LoadConfig(path, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TargetBranch != "main" || c.FeatureBranch != "overlay-updates" {
+		t.Fatalf("without overrides got %q/%q, want main/overlay-updates", c.TargetBranch, c.FeatureBranch)
+	}
+
+	t.Setenv(EnvTargetBranch, "release/2.0")
+	t.Setenv(EnvFeatureBranch, "ci-1234")
+	c, err = LoadConfig(path, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TargetBranch != "release/2.0" {
+		t.Errorf("target_branch = %q, want release/2.0 from %s", c.TargetBranch, EnvTargetBranch)
+	}
+	if c.FeatureBranch != "ci-1234" {
+		t.Errorf("feature_branch = %q, want ci-1234 from %s over the overlay", c.FeatureBranch, EnvFeatureBranch)
+	}
+
+	// An empty variable leaves the config value alone
+	t.Setenv(EnvTargetBranch, "")
+	c, err = LoadConfig(path, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TargetBranch != "main" {
+		t.Errorf("target_branch = %q, want main with %s empty", c.TargetBranch, EnvTargetBranch)
+	}
+}
+
+func TestSortProjects(t *testing.T) {
+	apiOrder := []RepoSpec{
+		{RepoPath: "team/web", RoleName: "node"},
+		{RepoPath: "team/api", RoleName: "pom"},
+		{RepoPath: "team/docs"},
+		{RepoPath: "team/cli", RoleName: "node"},
+	}
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{"", []string{"team/api", "team/cli", "team/docs", "team/web"}},
+		{SortByPath, []string{"team/api", "team/cli", "team/docs", "team/web"}},
+		{SortByRole, []string{"team/docs", "team/cli", "team/web", "team/api"}},
+		{SortByNone, []string{"team/web", "team/api", "team/docs", "team/cli"}},
+	}
+	for _, tt := range tests {
+		projects := append([]RepoSpec(nil), apiOrder...)
+		SortProjects(projects, tt.sortBy)
+		var got []string
+		for _, p := range projects {
+			got = append(got, p.RepoPath)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort_by %q: got %v, want %v", tt.sortBy, got, tt.want)
+		}
+	}
+}
+
+func TestExportDiscoveredProjectsMatrix(t *testing.T) {
+	projects := []RepoSpec{
+		{RepoPath: "team/api", RoleName: "pom", TargetBranch: "develop"},
+		{RepoPath: "team/docs"},
+	}
+	path := filepath.Join(t.TempDir(), "matrix.yaml")
+	meta := &ExportMetadata{SourceGroup: "team", DiscoveredAt: time.Now()}
+	if err := ExportDiscoveredProjects(path, projects, ExportOptions{Matrix: true, Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A bare list, so it can be pasted under "parallel: matrix:"
+	var got []map[string]string
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("matrix is not a list of entries: %v\n%s", err, data)
+	}
+	want := []map[string]string{
+		{"PROJECT": "team/api", "ROLE": "pom"},
+		{"PROJECT": "team/docs", "ROLE": UnknownRoleKey},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestValidateDiscoverySource(t *testing.T) {
+	tests := []struct {
+		name    string
+		ad      AutoDiscover
+		wantErr bool
+	}{
+		{"group", AutoDiscover{Group: "team"}, false},
+		{"user", AutoDiscover{User: "jdoe"}, false},
+		{"both", AutoDiscover{Group: "team", User: "jdoe"}, true},
+		{"neither", AutoDiscover{}, true},
+	}
+	for _, tt := range tests {
+		c := Config{GitlabURL: "https://gitlab.example.com", TargetBranch: "main", FeatureBranch: "roller-updates", AutoDiscover: &tt.ad}
+		err := c.Validate()
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "exactly one of group/groups or user")) {
+			t.Errorf("%s: expected the discovery source error, got %v", tt.name, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateCustomDetectors(t *testing.T) {
+	c := Config{GitlabURL: "https://gitlab.example.com", TargetBranch: "main", FeatureBranch: "roller-updates", Projects: []RepoSpec{{RepoPath: "team/app"}},
+		CustomDetectors: map[string]string{"deps.edn": "clojure", "build.sbt": " "}}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), `custom_detectors["build.sbt"] must name a role`) {
+		t.Errorf("expected an empty role error, got %v", err)
+	}
+	c.CustomDetectors["build.sbt"] = "scala"
+	if err := c.Validate(); err != nil {
+		t.Errorf("custom detectors with roles should be valid: %v", err)
+	}
+}
+
+func TestValidateSparseCheckoutPaths(t *testing.T) {
+	c := Config{GitlabURL: "https://gitlab.example.com", TargetBranch: "main", FeatureBranch: "roller-updates",
+		SparseCheckoutPaths: []string{"services/billing", "/etc"},
+		Projects:            []RepoSpec{{RepoPath: "team/app", SparseCheckoutPaths: []string{"../other"}}}}
+	err := c.Validate()
+	for _, want := range []string{"sparse_checkout_paths[1] must be relative to the repository root: /etc", "projects[team/app].sparse_checkout_paths[0] must stay inside the repository: ../other"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+	c.SparseCheckoutPaths = []string{"services/billing"}
+	c.Projects[0].SparseCheckoutPaths = []string{"apps/web"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("relative paths should be valid: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadExportedProjects reads a file written by ExportDiscoveredProjects in
+// any of its shapes: a projects list, a role → paths map or a CI matrix.
+// Projects grouped under UnknownRoleKey or exported with it as their matrix
+// role come back with an empty role.
+func LoadExportedProjects(path string) ([]RepoSpec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exported projects: %w", err)
+	}
+
+	var matrix []matrixEntry
+	if err := yaml.Unmarshal(b, &matrix); err == nil {
+		projects := make([]RepoSpec, len(matrix))
+		for i, e := range matrix {
+			projects[i] = RepoSpec{RepoPath: e.Project, RoleName: knownRole(e.Role)}
+		}
+		return projects, nil
+	}
+
+	var doc struct {
+		Projects []RepoSpec          `yaml:"projects"`
+		Roles    map[string][]string `yaml:"roles"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse exported projects %s: %w", path, err)
+	}
+	projects := doc.Projects
+	roles := make([]string, 0, len(doc.Roles))
+	for role := range doc.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		for _, repoPath := range doc.Roles[role] {
+			projects = append(projects, RepoSpec{RepoPath: repoPath, RoleName: knownRole(role)})
+		}
+	}
+	return projects, nil
+}
+
+// knownRole maps UnknownRoleKey back to an empty role
+func knownRole(role string) string {
+	if role == UnknownRoleKey {
+		return ""
+	}
+	return role
+}
+
+// RoleChange is a repository whose detected role differs between two
+// discoveries
+type RoleChange struct {
+	RepoPath string `json:"repo_path"`
+	OldRole  string `json:"old_role"`
+	NewRole  string `json:"new_role"`
+}
+
+// DiffedProject is a repository that was added or removed
+type DiffedProject struct {
+	RepoPath string `json:"repo_path"`
+	Role     string `json:"role,omitempty"`
+}
+
+// ProjectDiff is what changed between two discovered project lists
+type ProjectDiff struct {
+	Added       []DiffedProject `json:"added"`
+	Removed     []DiffedProject `json:"removed"`
+	RoleChanges []RoleChange    `json:"role_changes"`
+}
+
+// Empty reports whether the two lists held the same projects and roles
+func (d ProjectDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.RoleChanges) == 0
+}
+
+// DiffProjects compares a previous project list with a new one, matching
+// projects by path. Each part of the result is sorted by path.
+func DiffProjects(old, new []RepoSpec) ProjectDiff {
+	oldByPath := make(map[string]RepoSpec, len(old))
+	for _, p := range old {
+		oldByPath[p.RepoPath] = p
+	}
+	newByPath := make(map[string]RepoSpec, len(new))
+	for _, p := range new {
+		newByPath[p.RepoPath] = p
+	}
+
+	d := ProjectDiff{Added: []DiffedProject{}, Removed: []DiffedProject{}, RoleChanges: []RoleChange{}}
+	for path, p := range newByPath {
+		before, ok := oldByPath[path]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, DiffedProject{RepoPath: path, Role: p.RoleName})
+		case before.RoleName != p.RoleName:
+			d.RoleChanges = append(d.RoleChanges, RoleChange{RepoPath: path, OldRole: before.RoleName, NewRole: p.RoleName})
+		}
+	}
+	for path, p := range oldByPath {
+		if _, ok := newByPath[path]; !ok {
+			d.Removed = append(d.Removed, DiffedProject{RepoPath: path, Role: p.RoleName})
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].RepoPath < d.Added[j].RepoPath })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].RepoPath < d.Removed[j].RepoPath })
+	sort.Slice(d.RoleChanges, func(i, j int) bool { return d.RoleChanges[i].RepoPath < d.RoleChanges[j].RepoPath })
+	return d
+}
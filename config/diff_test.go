@@ -0,0 +1,60 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffProjects(t *testing.T) {
+	old := []RepoSpec{
+		{RepoPath: "team/api", RoleName: "pom"},
+		{RepoPath: "team/web", RoleName: "node"},
+		{RepoPath: "team/legacy", RoleName: "pip"},
+		{RepoPath: "team/docs"},
+	}
+	new := []RepoSpec{
+		{RepoPath: "team/web", RoleName: "js-monorepo"},
+		{RepoPath: "team/api", RoleName: "pom"},
+		{RepoPath: "team/mobile", RoleName: "swift"},
+		{RepoPath: "team/docs", RoleName: "node"},
+		{RepoPath: "team/cli"},
+	}
+	want := ProjectDiff{
+		Added:   []DiffedProject{{RepoPath: "team/cli"}, {RepoPath: "team/mobile", Role: "swift"}},
+		Removed: []DiffedProject{{RepoPath: "team/legacy", Role: "pip"}},
+		RoleChanges: []RoleChange{
+			{RepoPath: "team/docs", OldRole: "", NewRole: "node"},
+			{RepoPath: "team/web", OldRole: "node", NewRole: "js-monorepo"},
+		},
+	}
+	got := DiffProjects(old, new)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got.Empty() {
+		t.Error("a diff with changes reported as empty")
+	}
+	if d := DiffProjects(old, old); !d.Empty() {
+		t.Errorf("identical lists gave %+v", d)
+	}
+}
+
+func TestLoadExportedProjectsShapes(t *testing.T) {
+	projects := []RepoSpec{{RepoPath: "team/api", RoleName: "pom"}, {RepoPath: "team/docs"}}
+	for _, opts := range []ExportOptions{{}, {GroupByRole: true}, {Matrix: true}} {
+		path := filepath.Join(t.TempDir(), "discovered.yaml")
+		if err := ExportDiscoveredProjects(path, projects, opts); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadExportedProjects(path)
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+			continue
+		}
+		// Every shape compares cleanly with what was exported
+		if d := DiffProjects(projects, got); !d.Empty() {
+			t.Errorf("%+v: reloaded export differs: %+v", opts, d)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import "strings"
+
+// GroupDefaults are branch settings for the projects discovered in one group
+type GroupDefaults struct {
+	TargetBranch  string `yaml:"target_branch,omitempty"`
+	FeatureBranch string `yaml:"feature_branch,omitempty"`
+}
+
+// groupDefaultsFor returns the group_defaults entry for namespace: the entry
+// of the namespace itself or else of its closest parent group
+func (c *Config) groupDefaultsFor(namespace string) (GroupDefaults, bool) {
+	for ns := namespace; ns != ""; {
+		if d, ok := c.GroupDefaults[ns]; ok {
+			return d, true
+		}
+		i := strings.LastIndex(ns, "/")
+		if i < 0 {
+			break
+		}
+		ns = ns[:i]
+	}
+	return GroupDefaults{}, false
+}
+
+// ApplyGroupDefaults fills in the target and feature branch of a discovered
+// project from the group_defaults of its source group, leaving settings the
+// project already has alone. Configured projects take precedence over
+// discovered ones, so their overrides still win.
+func (c *Config) ApplyGroupDefaults(proj RepoSpec) RepoSpec {
+	d, ok := c.groupDefaultsFor(proj.SourceGroup)
+	if !ok {
+		return proj
+	}
+	if proj.TargetBranch == "" {
+		proj.TargetBranch = d.TargetBranch
+	}
+	if proj.FeatureBranch == "" {
+		proj.FeatureBranch = d.FeatureBranch
+	}
+	return proj
+}
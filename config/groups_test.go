@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestApplyGroupDefaults(t *testing.T) {
+	c := &Config{GroupDefaults: map[string]GroupDefaults{
+		"platform":      {TargetBranch: "develop", FeatureBranch: "platform-updates"},
+		"platform/edge": {TargetBranch: "trunk"},
+	}}
+	tests := []struct {
+		name string
+		proj RepoSpec
+		want RepoSpec
+	}{
+		{"group entry", RepoSpec{SourceGroup: "platform"}, RepoSpec{SourceGroup: "platform", TargetBranch: "develop", FeatureBranch: "platform-updates"}},
+		{"closest parent", RepoSpec{SourceGroup: "platform/tools"}, RepoSpec{SourceGroup: "platform/tools", TargetBranch: "develop", FeatureBranch: "platform-updates"}},
+		// The subgroup's entry wins over its parent's and leaves the feature branch global
+		{"own subgroup entry", RepoSpec{SourceGroup: "platform/edge"}, RepoSpec{SourceGroup: "platform/edge", TargetBranch: "trunk"}},
+		{"project override", RepoSpec{SourceGroup: "platform", TargetBranch: "release"}, RepoSpec{SourceGroup: "platform", TargetBranch: "release", FeatureBranch: "platform-updates"}},
+		{"no entry", RepoSpec{SourceGroup: "platformer"}, RepoSpec{SourceGroup: "platformer"}},
+	}
+	for _, tt := range tests {
+		if got := c.ApplyGroupDefaults(tt.proj); got.TargetBranch != tt.want.TargetBranch || got.FeatureBranch != tt.want.FeatureBranch {
+			t.Errorf("%s: branches %q/%q, want %q/%q", tt.name, got.TargetBranch, got.FeatureBranch, tt.want.TargetBranch, tt.want.FeatureBranch)
+		}
+	}
+}
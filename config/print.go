@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in the printed configuration
+const redactedValue = "<redacted>"
+
+// WriteEffective writes c as YAML to w, as it stands after files, overlays,
+// environment variables and flags were applied. Values that may hold
+// secrets, i.e. the env maps and any credentials in gitlab_url, are
+// redacted; the GitLab token is never part of the configuration.
+func WriteEffective(w io.Writer, c *Config) error {
+	data, err := yaml.Marshal(redacted(c))
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// redacted returns a copy of c with secrets replaced, leaving c untouched
+func redacted(c *Config) *Config {
+	out := *c
+	out.GitlabURL = redactURL(c.GitlabURL)
+	out.Env = redactEnv(c.Env)
+	out.Projects = make([]RepoSpec, len(c.Projects))
+	for i, proj := range c.Projects {
+		proj.Env = redactEnv(proj.Env)
+		out.Projects[i] = proj
+	}
+	return &out
+}
+
+// redactEnv returns env with every value replaced
+func redactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	out := make(map[string]string, len(env))
+	for k := range env {
+		out[k] = redactedValue
+	}
+	return out
+}
+
+// redactURL masks the password of a URL with user info
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmer asks for approval before destructive actions
+type confirmer struct {
+	in          io.Reader
+	out         io.Writer
+	interactive bool // Whether in is a terminal someone can answer on
+	assumeYes   bool // Approve everything without asking (-yes)
+}
+
+// newConfirmer returns a confirmer prompting on stderr and reading stdin
+func newConfirmer(assumeYes bool) *confirmer {
+	return &confirmer{in: os.Stdin, out: os.Stderr, interactive: isTerminal(os.Stdin), assumeYes: assumeYes}
+}
+
+// canPrompt reports whether confirm would ask instead of failing
+func (c *confirmer) canPrompt() bool {
+	return c.assumeYes || c.interactive
+}
+
+// confirm asks whether action should go ahead and reports the answer; only
+// "y" or "yes" approves. Without a terminal there is nobody to ask, so
+// rather than hang it fails unless -yes was given.
+func (c *confirmer) confirm(action string) (bool, error) {
+	if c.assumeYes {
+		return true, nil
+	}
+	if !c.interactive {
+		return false, fmt.Errorf("%s needs confirmation, but stdin is not a terminal; pass -yes to proceed", action)
+	}
+	fmt.Fprintf(c.out, "%s? [y/N] ", action)
+	line, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
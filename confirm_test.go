@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		interactive bool
+		assumeYes   bool
+		want        bool
+		wantErr     bool
+	}{
+		{"yes", "y\n", true, false, true, false},
+		{"yes in words", " YES \n", true, false, true, false},
+		{"no", "n\n", true, false, false, false},
+		{"empty answer", "\n", true, false, false, false},
+		{"end of input", "", true, false, false, false},
+		{"non-terminal", "y\n", false, false, false, true},
+		{"-yes on a terminal", "", true, true, true, false},
+		{"-yes without a terminal", "", false, true, true, false},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		c := &confirmer{in: strings.NewReader(tt.input), out: &out, interactive: tt.interactive, assumeYes: tt.assumeYes}
+		if c.canPrompt() == tt.wantErr {
+			t.Errorf("%s: canPrompt %t, want %t", tt.name, c.canPrompt(), !tt.wantErr)
+		}
+		got, err := c.confirm("Delete 3 clones in repos")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got approval %t, want %t", tt.name, got, tt.want)
+		}
+		// Only an actual prompt is written, never one nobody can answer
+		if prompted := out.String() != ""; prompted != (tt.interactive && !tt.assumeYes) {
+			t.Errorf("%s: prompt %q written unexpectedly", tt.name, out.String())
+		}
+	}
+}
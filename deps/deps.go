@@ -0,0 +1,137 @@
+// Package deps extracts the dependency versions declared in a repository's
+// dependency file.
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// Parser reads the contents of a dependency file and returns a map of
+// dependency name to declared version
+type Parser func(data []byte) (map[string]string, error)
+
+// Updater rewrites the contents of a dependency file to declare the versions
+// in updates (dependency name → version), leaving the rest of the file as it
+// was, and returns the new contents along with the dependencies it changed
+type Updater func(data []byte, updates map[string]string) ([]byte, []string, error)
+
+// parser ties a role to the dependency file it reads and how to parse and
+// update it
+type parser struct {
+	file   string
+	parse  Parser
+	update Updater
+}
+
+// parsers holds the supported parsers keyed by role name
+var parsers = map[string]parser{
+	"pom":  {file: "pom.xml", parse: parsePom, update: updatePom},
+	"pip":  {file: "requirements.txt", parse: parseRequirements, update: updateRequirements},
+	"node": {file: "package.json", parse: parsePackageJSON, update: updatePackageJSON},
+	// JS monorepos declare shared dependencies in the root package.json
+	"js-monorepo": {file: "package.json", parse: parsePackageJSON, update: updatePackageJSON},
+}
+
+// Register adds or replaces the parser used for the given role. Roles
+// registered this way can be parsed but not updated.
+func Register(role, file string, p Parser) {
+	parsers[role] = parser{file: file, parse: p}
+}
+
+// FileName returns the dependency file name read for the given role
+func FileName(role string) (string, bool) {
+	p, ok := parsers[role]
+	return p.file, ok
+}
+
+// ParseDependencies reads the dependency file for role at the root of repoPath
+// and returns a map of dependency name to declared version
+func ParseDependencies(repoPath, role string) (map[string]string, error) {
+	p, ok := parsers[role]
+	if !ok {
+		return nil, fmt.Errorf("no dependency parser for role %q", role)
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, p.file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", p.file, err)
+	}
+
+	result, err := p.parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p.file, err)
+	}
+	return result, nil
+}
+
+// UpToDate reports whether every dependency in desired that current declares
+// is already at the desired version. Desired dependencies the repository does
+// not declare are ignored, since there is nothing to bump.
+func UpToDate(current, desired map[string]string) bool {
+	for name, version := range desired {
+		if have, ok := current[name]; ok && have != version {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateDependencies rewrites the dependency file for role at the root of
+// repoPath in place so that the dependencies in updates declare the given
+// versions. Dependencies the file doesn't declare are not added, and the
+// file's formatting is kept. It returns the names of the dependencies that
+// changed, sorted; the file is only written if there are any.
+func UpdateDependencies(repoPath, role string, updates map[string]string) ([]string, error) {
+	p, ok := parsers[role]
+	if !ok || p.update == nil {
+		return nil, fmt.Errorf("no dependency updater for role %q", role)
+	}
+
+	path := filepath.Join(repoPath, p.file)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", p.file, err)
+	}
+
+	updated, changed, err := p.update(data, updates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update %s: %w", p.file, err)
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changed)
+	changed = slices.Compact(changed)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", p.file, err)
+	}
+	if err := os.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", p.file, err)
+	}
+	return changed, nil
+}
+
+// edit replaces data[start:end] with text
+type edit struct {
+	start, end int
+	text       string
+}
+
+// applyEdits applies non-overlapping edits to data
+func applyEdits(data []byte, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	out := make([]byte, 0, len(data))
+	last := 0
+	for _, e := range edits {
+		out = append(out, data[last:e.start]...)
+		out = append(out, e.text...)
+		last = e.end
+	}
+	return append(out, data[last:]...)
+}
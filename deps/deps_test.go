@@ -0,0 +1,191 @@
+package deps
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDependencies(t *testing.T) {
+	tests := []struct {
+		role string
+		dir  string
+		want map[string]string
+	}{
+		{
+			role: "pom",
+			dir:  "pom",
+			want: map[string]string{
+				"org.slf4j:slf4j-api":                         "2.0.13",
+				"com.fasterxml.jackson.core:jackson-databind": "2.17.1",
+				"junit:junit":                                 "4.13.2",
+			},
+		},
+		{
+			role: "pip",
+			dir:  "pip",
+			want: map[string]string{
+				"requests": "2.32.3",
+				"flask":    ">=3.0,<4",
+				"Django":   "5.0.6",
+				"gunicorn": "",
+			},
+		},
+		{
+			role: "node",
+			dir:  "node",
+			want: map[string]string{
+				"express": "^4.19.2",
+				"lodash":  "4.17.21",
+				"jest":    "^29.7.0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			got, err := ParseDependencies(filepath.Join("testdata", tt.dir), tt.role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDependenciesUnknownRole(t *testing.T) {
+	if _, err := ParseDependencies(filepath.Join("testdata", "pom"), "cobol"); err == nil {
+		t.Error("expected an error for a role without a parser")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("fake", "pom.xml", func(data []byte) (map[string]string, error) {
+		return map[string]string{"size": "known"}, nil
+	})
+	defer delete(parsers, "fake")
+
+	got, err := ParseDependencies(filepath.Join("testdata", "pom"), "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["size"] != "known" {
+		t.Errorf("registered parser was not used: %v", got)
+	}
+	if file, ok := FileName("fake"); !ok || file != "pom.xml" {
+		t.Errorf("FileName() = %q, %v", file, ok)
+	}
+}
+
+func TestUpdateDependenciesGolden(t *testing.T) {
+	tests := []struct {
+		role    string
+		updates map[string]string
+		changed []string
+	}{
+		{
+			role: "pom",
+			updates: map[string]string{
+				"com.fasterxml.jackson.core:jackson-databind": "2.17.2", // Through the jackson.version property
+				"org.slf4j:slf4j-api":                         "2.0.16", // In dependencyManagement
+				"junit:junit":                                 "4.13.2", // Already there
+				"org.example:absent":                          "1.0",
+			},
+			changed: []string{"com.fasterxml.jackson.core:jackson-databind", "org.slf4j:slf4j-api"},
+		},
+		{
+			role: "pip",
+			updates: map[string]string{
+				"requests": "2.32.4",
+				"flask":    "3.0.3",
+				"Django":   "5.0.7",
+				"gunicorn": "22.0.0",
+				"absent":   "1.0",
+			},
+			changed: []string{"Django", "flask", "gunicorn", "requests"},
+		},
+		{
+			role: "node",
+			updates: map[string]string{
+				"lodash": "4.17.22", // In both dependencies and devDependencies
+				"jest":   "^29.7.1",
+				"absent": "1.0",
+			},
+			changed: []string{"jest", "lodash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			file, _ := FileName(tt.role)
+			src := filepath.Join("testdata", tt.role, file)
+			data, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, file), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			changed, err := UpdateDependencies(dir, tt.role, tt.updates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(src + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("updated %s differs from %s.golden:\n%s", file, file, got)
+			}
+		})
+	}
+}
+
+func TestUpdateDependenciesUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "node", "package.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "package.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := UpdateDependencies(dir, "node", map[string]string{"express": "^4.19.2"})
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("UpdateDependencies() = %v, %v; want no changes", changed, err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(data) {
+		t.Error("file rewritten without any change")
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	current := map[string]string{"requests": "2.32.3", "flask": ">=3.0,<4"}
+	tests := []struct {
+		name    string
+		desired map[string]string
+		want    bool
+	}{
+		{"all at the desired version", map[string]string{"requests": "2.32.3"}, true},
+		{"one behind", map[string]string{"requests": "2.33.0", "flask": ">=3.0,<4"}, false},
+		{"undeclared dependencies ignored", map[string]string{"django": "5.0.6"}, true},
+		{"nothing desired", nil, true},
+	}
+	for _, tt := range tests {
+		if got := UpToDate(current, tt.desired); got != tt.want {
+			t.Errorf("%s: UpToDate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package deps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// parsePackageJSON returns the entries of dependencies and devDependencies;
+// dependencies win when a package appears in both
+func parsePackageJSON(data []byte) (map[string]string, error) {
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for name, version := range pkg.DevDependencies {
+		result[name] = version
+	}
+	for name, version := range pkg.Dependencies {
+		result[name] = version
+	}
+	return result, nil
+}
+
+// updatePackageJSON sets the version of each package named in updates in
+// dependencies and devDependencies. Only the version strings are replaced,
+// so indentation and key order stay as they were.
+func updatePackageJSON(data []byte, updates map[string]string) ([]byte, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not a JSON object")
+	}
+
+	var edits []edit
+	var changed []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		if key := tok.(string); key != "dependencies" && key != "devDependencies" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, nil, fmt.Errorf("dependencies must be an object")
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			name := tok.(string)
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, nil, err
+			}
+			version, ok := updates[name]
+			if !ok {
+				continue
+			}
+			quoted, err := json.Marshal(version)
+			if err != nil {
+				return nil, nil, err
+			}
+			if bytes.Equal(raw, quoted) {
+				continue
+			}
+			end := int(dec.InputOffset())
+			edits = append(edits, edit{start: end - len(raw), end: end, text: string(quoted)})
+			changed = append(changed, name)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return applyEdits(data, edits), changed, nil
+}
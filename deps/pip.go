@@ -0,0 +1,88 @@
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// parseRequirements returns name → version specifier for each requirement line.
+// Pinned requirements ("pkg==1.2") map to the bare version, others keep their
+// operator ("pkg>=1.2"), and unpinned requirements map to an empty string.
+func parseRequirements(data []byte) (map[string]string, error) {
+	result := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Strip comments and environment markers
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		// Skip blank lines and pip options such as -r or --index-url
+		if line == "" || strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		name, version := line, ""
+		if i := strings.IndexAny(line, "=<>!~"); i >= 0 {
+			name = line[:i]
+			version = strings.TrimSpace(line[i:])
+			if pinned := strings.TrimLeft(version, "="); len(version)-len(pinned) >= 2 && !strings.ContainsAny(pinned, ",<>!~") {
+				version = strings.TrimSpace(pinned)
+			}
+		}
+		// Drop extras, e.g. "requests[security]"
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		result[strings.TrimSpace(name)] = version
+	}
+
+	return result, scanner.Err()
+}
+
+// updateRequirements pins each requirement named in updates to "==version",
+// keeping its extras, environment markers and comments
+func updateRequirements(data []byte, updates map[string]string) ([]byte, []string, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	var changed []string
+	for i, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		end := len(body)
+		if j := strings.IndexAny(body, "#;"); j >= 0 {
+			end = j
+		}
+		req := body[:end]
+		if trimmed := strings.TrimSpace(req); trimmed == "" || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+
+		nameEnd := len(req)
+		if j := strings.IndexAny(req, "=<>!~"); j >= 0 {
+			nameEnd = j
+		}
+		name := req[:nameEnd]
+		if j := strings.Index(name, "["); j >= 0 {
+			name = name[:j]
+		}
+		version, ok := updates[strings.TrimSpace(name)]
+		if !ok {
+			continue
+		}
+
+		// Whitespace before a marker or comment is kept as it was
+		spacing := req[len(strings.TrimRight(req, " \t")):]
+		pinned := strings.TrimRight(req[:nameEnd], " \t") + "==" + version
+		if pinned+spacing == req {
+			continue
+		}
+		lines[i] = pinned + spacing + line[end:]
+		changed = append(changed, strings.TrimSpace(name))
+	}
+	return []byte(strings.Join(lines, "")), changed, nil
+}
@@ -0,0 +1,158 @@
+package deps
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type pomDependency struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Version    string `xml:"version"`
+}
+
+type pomProject struct {
+	Properties struct {
+		Entries []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"properties"`
+	Dependencies         []pomDependency `xml:"dependencies>dependency"`
+	DependencyManagement []pomDependency `xml:"dependencyManagement>dependencies>dependency"`
+}
+
+// parsePom returns "groupId:artifactId" → version for every dependency with a
+// version, resolving ${property} references from the pom's <properties>
+func parsePom(data []byte) (map[string]string, error) {
+	var p pomProject
+	if err := xml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]string)
+	for _, e := range p.Properties.Entries {
+		props[e.XMLName.Local] = strings.TrimSpace(e.Value)
+	}
+
+	result := make(map[string]string)
+	for _, d := range append(p.DependencyManagement, p.Dependencies...) {
+		version := strings.TrimSpace(d.Version)
+		if version == "" {
+			continue
+		}
+		if strings.HasPrefix(version, "${") && strings.HasSuffix(version, "}") {
+			if v, ok := props[version[2:len(version)-1]]; ok {
+				version = v
+			}
+		}
+		result[strings.TrimSpace(d.GroupID)+":"+strings.TrimSpace(d.ArtifactID)] = version
+	}
+	return result, nil
+}
+
+// pomText is the text content of an element and where it sits in the file
+type pomText struct {
+	value      string
+	start, end int
+}
+
+// updatePom sets the version of each "groupId:artifactId" named in updates,
+// in dependencies and dependencyManagement. A version given as a ${property}
+// reference is updated in <properties> instead. Only the version text is
+// replaced, so the layout and comments of the pom stay as they were.
+func updatePom(data []byte, updates map[string]string) ([]byte, []string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	props := make(map[string]pomText)
+	type dependency struct {
+		key     string
+		version pomText
+	}
+	var found []dependency
+
+	var stack []string
+	var text pomText
+	var groupID, artifactID string
+	var version pomText
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			offset := int(dec.InputOffset())
+			text = pomText{start: offset, end: offset}
+			if t.Name.Local == "dependency" {
+				groupID, artifactID, version = "", "", pomText{}
+			}
+		case xml.CharData:
+			text.value += string(t)
+			text.end = int(dec.InputOffset())
+		case xml.EndElement:
+			path := strings.Join(stack, ">")
+			text.value = strings.TrimSpace(text.value)
+			switch {
+			case len(stack) == 3 && strings.HasPrefix(path, "project>properties>"):
+				props[t.Name.Local] = text
+			case isPomDependency(stack[:len(stack)-1]):
+				switch t.Name.Local {
+				case "groupId":
+					groupID = text.value
+				case "artifactId":
+					artifactID = text.value
+				case "version":
+					version = text
+				}
+			case isPomDependency(stack):
+				found = append(found, dependency{key: groupID + ":" + artifactID, version: version})
+			}
+			stack = stack[:len(stack)-1]
+			text = pomText{}
+		}
+	}
+
+	var edits []edit
+	var changed []string
+	edited := make(map[int]string) // start offset → version written there
+	for _, d := range found {
+		want, ok := updates[d.key]
+		if !ok || d.version.value == "" {
+			continue
+		}
+		target := d.version
+		if strings.HasPrefix(target.value, "${") && strings.HasSuffix(target.value, "}") {
+			prop, ok := props[target.value[2:len(target.value)-1]]
+			if !ok {
+				continue
+			}
+			target = prop
+		}
+		if prev, ok := edited[target.start]; ok {
+			if prev != want {
+				return nil, nil, fmt.Errorf("%s shares its version with a dependency updated to %s", d.key, prev)
+			}
+			continue
+		}
+		if target.value == want {
+			continue
+		}
+		edited[target.start] = want
+		edits = append(edits, edit{start: target.start, end: target.end, text: want})
+		changed = append(changed, d.key)
+	}
+	return applyEdits(data, edits), changed, nil
+}
+
+// isPomDependency reports whether the element path names a dependency
+func isPomDependency(stack []string) bool {
+	path := strings.Join(stack, ">")
+	return path == "project>dependencies>dependency" || path == "project>dependencyManagement>dependencies>dependency"
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"roller/config"
+	"roller/deps"
+)
+
+// printDetection runs the built-in role detection on a local directory and
+// writes the detected role and its dependency file to w. It touches neither
+// GitLab nor git.
+func printDetection(w io.Writer, dir string) error {
+	role, err := detectRepoType(dir, nil, config.DefaultDetectIgnoreDirs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "role: %s\n", role)
+	if file, ok := deps.FileName(role); ok {
+		fmt.Fprintf(w, "dependency file: %s\n", filepath.Join(dir, file))
+	}
+	return nil
+}
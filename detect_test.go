@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"roller/config"
+	"roller/runner"
+)
+
+func TestDetectRepoTypeSkipsVendoredDirs(t *testing.T) {
+	// A node project with pom.xml and requirements.txt files only in vendored
+	// directories, plus a pom.xml in a legacy tools directory
+	repo := filepath.Join("testdata", "detect", "vendored")
+
+	cfg := &config.Config{DetectIgnoreDirs: []string{"tools/legacy"}}
+	role, err := detectRepoType(repo, nil, cfg.DetectionIgnoredDirs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "node" {
+		t.Errorf("role = %q, want node with the vendored files ignored", role)
+	}
+
+	// Only the default ignore list applies without detect_ignore_dirs
+	role, err = detectRepoType(repo, nil, (&config.Config{}).DetectionIgnoredDirs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "pom" {
+		t.Errorf("role = %q, want pom from tools/legacy", role)
+	}
+}
+
+func TestDetectRoleWithDetectorCommand(t *testing.T) {
+	repo := filepath.Join("testdata", "detect", "vendored")
+	cfg := &config.Config{RoleDetectorCommand: "detect-role --layout team"}
+
+	tests := []struct {
+		name string
+		out  string
+		err  error
+		want string
+	}{
+		{"detector role", " clojure \nignored second line\n", nil, "clojure"},
+		{"no output", "\n", nil, "pom"},
+		{"detector fails", "", errors.New("exit status 1"), "pom"},
+	}
+	for _, tt := range tests {
+		f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+			return []byte(tt.out), tt.err
+		}}
+		role, err := detectRole(context.Background(), f, cfg, repo)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if role != tt.want {
+			t.Errorf("%s: role %q, want %q", tt.name, role, tt.want)
+		}
+		want := runner.Cmd{Name: "detect-role", Args: []string{"--layout", "team", repo}}
+		if calls := f.Calls(); len(calls) != 1 || !reflect.DeepEqual(calls[0], want) {
+			t.Errorf("%s: ran %v, want %v", tt.name, calls, want)
+		}
+	}
+}
+
+func TestDetectRepoTypeJSMonorepo(t *testing.T) {
+	tests := []struct {
+		fixture, want string
+	}{
+		{"nx", "js-monorepo"},
+		{"turbo", "js-monorepo"},
+		{"lerna", "js-monorepo"},
+		// Without a workspace tool config a package.json is a plain node project
+		{"node", "node"},
+	}
+	for _, tt := range tests {
+		role, err := detectRepoType(filepath.Join("testdata", "detect", tt.fixture), nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+		if role != tt.want {
+			t.Errorf("%s: role %q, want %q", tt.fixture, role, tt.want)
+		}
+	}
+}
+
+func TestPrintDetection(t *testing.T) {
+	dir := filepath.Join("testdata", "detect", "node")
+	var out strings.Builder
+	if err := printDetection(&out, dir); err != nil {
+		t.Fatal(err)
+	}
+	want := "role: node\ndependency file: " + filepath.Join(dir, "package.json") + "\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	if err := printDetection(&out, filepath.Join("testdata", "detect", "missing")); err == nil {
+		t.Error("a missing directory should be an error")
+	}
+}
+
+func TestDetectRepoTypeRoleMarker(t *testing.T) {
+	// marker declares pom, with surrounding whitespace, over its package.json
+	role, err := detectRepoType(filepath.Join("testdata", "detect", "marker"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "pom" {
+		t.Errorf("role %q, want pom from %s", role, roleMarkerFile)
+	}
+
+	bad := filepath.Join("testdata", "detect", "badmarker")
+	if _, err := detectRepoType(bad, nil, nil); err == nil || !strings.Contains(err.Error(), `unknown role "cobol"`) {
+		t.Errorf("expected an unknown role error, got %v", err)
+	}
+	// A role from a custom detector is accepted
+	role, err = detectRepoType(bad, map[string]string{"JCL.cfg": "cobol"}, nil)
+	if err != nil || role != "cobol" {
+		t.Errorf("got role %q, %v, want the custom role cobol", role, err)
+	}
+}
+
+func TestDetectRepoTypeSwift(t *testing.T) {
+	tests := []struct {
+		fixture, want string
+	}{
+		// The package's example app project doesn't make it an Xcode project
+		{"spm", "swift"},
+		{"xcode", "xcode"},
+	}
+	for _, tt := range tests {
+		role, err := detectRepoType(filepath.Join("testdata", "detect", tt.fixture), nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+		if role != tt.want {
+			t.Errorf("%s: role %q, want %q", tt.fixture, role, tt.want)
+		}
+	}
+}
+
+func TestDetectRepoTypeCustomDetectors(t *testing.T) {
+	custom := map[string]string{"deps.edn": "clojure", "package.json": "bun"}
+	tests := []struct {
+		fixture string
+		custom  map[string]string
+		want    string
+	}{
+		{"clojure", custom, "clojure"},
+		// A custom detector for a built-in file name overrides the built-in role
+		{"node", custom, "bun"},
+		{"node", map[string]string{"deps.edn": "clojure"}, "node"},
+	}
+	for _, tt := range tests {
+		role, err := detectRepoType(filepath.Join("testdata", "detect", tt.fixture), tt.custom, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+		if role != tt.want {
+			t.Errorf("%s with %v: role %q, want %q", tt.fixture, tt.custom, role, tt.want)
+		}
+	}
+	if _, err := detectRepoType(filepath.Join("testdata", "detect", "clojure"), nil, nil); err == nil {
+		t.Error("deps.edn should not be detected without a custom detector")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// groupServer serves the project listing of group team with status and body
+func groupServer(t *testing.T, status int, body string) *config.Config {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.AutoDiscover = &config.AutoDiscover{Group: "team"}
+	return cfg
+}
+
+func TestDiscoverEmptyGroup(t *testing.T) {
+	cfg := groupServer(t, http.StatusOK, `[]`)
+	client := gitlab.NewClient(cfg, "test-token")
+	output := filepath.Join(t.TempDir(), "discovered.yaml")
+
+	err := discoverAndExportProjects(context.Background(), &runner.Fake{}, client, cfg, discoverOptions{OutputPath: output})
+	if err == nil || !strings.Contains(err.Error(), "has no active projects") {
+		t.Fatalf("expected an empty group error without -empty-ok, got %v", err)
+	}
+
+	// With -empty-ok it is only a warning and the run exits 0
+	if err := discoverAndExportProjects(context.Background(), &runner.Fake{}, client, cfg, discoverOptions{OutputPath: output, EmptyOK: true}); err != nil {
+		t.Fatalf("an empty group should be accepted with -empty-ok: %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("nothing should be exported for an empty group, stat: %v", err)
+	}
+}
+
+func TestDiscoverAPIErrorIsNotEmpty(t *testing.T) {
+	cfg := groupServer(t, http.StatusNotFound, `{"message": "404 Group Not Found"}`)
+	client := gitlab.NewClient(cfg, "test-token")
+
+	err := discoverAndExportProjects(context.Background(), &runner.Fake{}, client, cfg, discoverOptions{OutputPath: filepath.Join(t.TempDir(), "discovered.yaml"), EmptyOK: true})
+	if err == nil || strings.Contains(err.Error(), "no active projects") {
+		t.Fatalf("expected the API error even with -empty-ok, got %v", err)
+	}
+}
+
+func TestDiscoverOnlyRole(t *testing.T) {
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/app"}, {"path_with_namespace": "team/lib"}, {"path_with_namespace": "team/tool"}]`)
+	cfg.RoleDetectorCommand = "detect-role"
+	client := gitlab.NewClient(cfg, "test-token")
+	dir := t.TempDir()
+
+	output := filepath.Join(dir, "java.yaml")
+	if err := discoverAndExportProjects(context.Background(), batchRunner(""), client, cfg, discoverOptions{OutputPath: output, OnlyRole: "java"}); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := config.LoadProjectsFile(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.RepoSpec{{RepoPath: "team/app", RoleName: "java"}, {RepoPath: "team/lib", RoleName: "java"}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %+v, want only the java projects %+v", exported, want)
+	}
+
+	// No match is a warning, and nothing is written
+	output = filepath.Join(dir, "go.yaml")
+	if err := discoverAndExportProjects(context.Background(), batchRunner(""), client, cfg, discoverOptions{OutputPath: output, OnlyRole: "go"}); err != nil {
+		t.Fatalf("a role without projects should only warn: %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("%s written although no project matched (stat: %v)", output, err)
+	}
+}
+
+func TestManualOnlyRolesExportedButNotProcessed(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/app"}, {"path_with_namespace": "team/tool"}]`)
+	cfg.RoleDetectorCommand = "detect-role"
+	cfg.ManualOnlyRoles = []string{"python"}
+	client := gitlab.NewClient(cfg, "test-token")
+
+	output := filepath.Join(t.TempDir(), "discovered.yaml")
+	if err := discoverAndExportProjects(context.Background(), batchRunner(""), client, cfg, discoverOptions{OutputPath: output}); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := config.LoadProjectsFile(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.RepoSpec{{RepoPath: "team/app", RoleName: "java"}, {RepoPath: "team/tool", RoleName: "python"}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Fatalf("exported %+v, want manual-only projects included %+v", exported, want)
+	}
+
+	f := batchRunner("")
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := processProjects(context.Background(), f, client, cfg, exported, true, cp)
+	if results[0].Status != report.StatusSuccess {
+		t.Errorf("team/app: got %s (%s), want success", results[0].Status, results[0].Error)
+	}
+	if results[1].Status != report.StatusSkipped || results[1].Reason != "manual-only role" {
+		t.Errorf("team/tool: got %s (%s), want skipped as manual-only", results[1].Status, results[1].Reason)
+	}
+	var cloned []string
+	for _, c := range f.Calls() {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			cloned = append(cloned, filepath.Base(c.Args[len(c.Args)-1]))
+		}
+	}
+	if !reflect.DeepEqual(cloned, []string{"app"}) {
+		t.Errorf("cloned %v, want only app", cloned)
+	}
+}
+
+func TestDiscoverWithLanguages(t *testing.T) {
+	responses := map[string]string{
+		"/api/v4/groups/team/projects":           `[{"path_with_namespace": "team/app"}, {"path_with_namespace": "team/tool"}]`,
+		"/api/v4/projects/team%2Fapp/languages":  `{"Java": 81.5, "Shell": 12.1, "Dockerfile": 6.4}`,
+		"/api/v4/projects/team%2Ftool/languages": `{"Python": 50, "Go": 50}`,
+	}
+	var languageRequests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/languages") {
+			languageRequests++
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.AutoDiscover = &config.AutoDiscover{Group: "team"}
+	cfg.RoleDetectorCommand = "detect-role"
+	client := gitlab.NewClient(cfg, "test-token")
+	output := filepath.Join(t.TempDir(), "discovered.yaml")
+
+	if err := discoverAndExportProjects(context.Background(), batchRunner(""), client, cfg, discoverOptions{OutputPath: output}); err != nil {
+		t.Fatal(err)
+	}
+	if languageRequests != 0 {
+		t.Errorf("languages were fetched %d times without -with-languages", languageRequests)
+	}
+
+	if err := discoverAndExportProjects(context.Background(), batchRunner(""), client, cfg, discoverOptions{OutputPath: output, WithLanguages: true}); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := config.LoadProjectsFile(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ties go to the alphabetically first language
+	want := []config.RepoSpec{{RepoPath: "team/app", RoleName: "java", Language: "Java"}, {RepoPath: "team/tool", RoleName: "python", Language: "Go"}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %+v, want %+v", exported, want)
+	}
+}
+
+func TestDiscoverSkipRoles(t *testing.T) {
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/app"}, {"path_with_namespace": "team/tool"}]`)
+	cfg.RoleDetectorCommand = "detect-role"
+	client := gitlab.NewClient(cfg, "test-token")
+	output := filepath.Join(t.TempDir(), "discovered.yaml")
+
+	f := batchRunner("")
+	if err := discoverAndExportProjects(context.Background(), f, client, cfg, discoverOptions{OutputPath: output, SkipRoles: true}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("-skip-roles ran %d commands, want none: %+v", len(calls), calls)
+	}
+	// The export loads back as a project list with empty roles
+	exported, err := config.LoadProjectsFile(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.RepoSpec{{RepoPath: "team/app"}, {RepoPath: "team/tool"}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %+v, want %+v", exported, want)
+	}
+}
+
+func TestDiscoverUnknownRole(t *testing.T) {
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/web"}, {"path_with_namespace": "team/docs"}]`)
+	client := gitlab.NewClient(cfg, "test-token")
+	// Only web has a package manager file to detect
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name != "git" || c.Args[0] != "clone" {
+			return nil, nil
+		}
+		dest := c.Args[len(c.Args)-1]
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return nil, err
+		}
+		if filepath.Base(dest) == "web" {
+			return nil, os.WriteFile(filepath.Join(dest, "package.json"), []byte(`{"name": "web"}`), 0o644)
+		}
+		return nil, os.WriteFile(filepath.Join(dest, "README.md"), []byte("# Docs\n"), 0o644)
+	}}
+
+	tests := []struct {
+		unknownRole, want string
+	}{
+		{"", ""},
+		{"unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		cfg.UnknownRole = tt.unknownRole
+		output := filepath.Join(t.TempDir(), "discovered.yaml")
+		if err := discoverAndExportProjects(context.Background(), f, client, cfg, discoverOptions{OutputPath: output}); err != nil {
+			t.Fatal(err)
+		}
+		exported, err := config.LoadProjectsFile(output, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []config.RepoSpec{{RepoPath: "team/docs", RoleName: tt.want}, {RepoPath: "team/web", RoleName: "node"}}
+		if !reflect.DeepEqual(exported, want) {
+			t.Errorf("unknown_role %q: exported %+v, want %+v", tt.unknownRole, exported, want)
+		}
+	}
+
+	// Processing records the sentinel in the result as well
+	t.Chdir(t.TempDir())
+	var res report.Result
+	if err := cloneAndCreateBranch(context.Background(), f, client, cfg, config.RepoSpec{RepoPath: "team/docs"}, false, nil, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Role != "unknown" {
+		t.Errorf("processed team/docs has role %q, want unknown", res.Role)
+	}
+}
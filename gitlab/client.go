@@ -1,34 +1,150 @@
 package gitlab
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"roller/config"
+	"roller/retry"
 )
 
+// ErrGroupNotFound is returned when the requested group does not exist or is
+// not visible to the token
+var ErrGroupNotFound = errors.New("group not found")
+
+// ErrUserNotFound is returned when the requested user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// APIError is returned when GitLab answers with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("GitLab API error (%d): %s", e.StatusCode, e.Body)
+}
+
+// newAPIError reads the response body into an APIError
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	return &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
 type Client struct {
 	baseURL    string
+	apiBase    string // Path of the API below baseURL, prepended to every endpoint path
 	token      string
 	httpClient *http.Client
+	metrics    *Metrics
+	retries    int // Extra attempts for reads that fail transiently
 }
 
+// apiRetryDelay is the pause before each API retry, multiplied by the attempt
+const apiRetryDelay = time.Second
+
+// NewClient creates a client for the GitLab instance at cfg.GitlabURL. The URL
+// may include a relative-URL prefix (e.g. "https://example.com/gitlab") and an
+// optional trailing "/api/v4" (or api_base_path); both API and clone URLs are
+// derived from the instance root either way.
 func NewClient(cfg *config.Config, token string) *Client {
+	apiBase := apiBasePath(cfg)
 	return &Client{
-		baseURL: cfg.GitlabURL,
+		baseURL: instanceRoot(cfg.GitlabURL, apiBase),
+		apiBase: apiBase,
 		token:   token,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: newTransport(cfg),
 		},
+		metrics: newMetrics(),
+		retries: cfg.APIRetries,
+	}
+}
+
+// DefaultAPIBasePath is the API path used when api_base_path is not set
+const DefaultAPIBasePath = "/api/v4"
+
+// apiBasePath returns the configured API path without a trailing slash
+func apiBasePath(cfg *config.Config) string {
+	if cfg.APIBasePath == "" {
+		return DefaultAPIBasePath
+	}
+	return strings.TrimRight(cfg.APIBasePath, "/")
+}
+
+// defaultMaxIdleConns is the number of idle keep-alive connections kept when
+// max_idle_conns is not set. All requests go to a single host, so the limit
+// applies per host too, instead of net/http's per-host default of 2.
+const defaultMaxIdleConns = 16
+
+// newTransport returns an HTTP transport with the configured connection
+// pooling; max_conns_per_host of zero leaves connections unlimited
+func newTransport(cfg *config.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	idle := cfg.MaxIdleConns
+	if idle <= 0 {
+		idle = defaultMaxIdleConns
 	}
+	t.MaxIdleConns = idle
+	t.MaxIdleConnsPerHost = idle
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	return t
 }
 
+// Metrics returns the request metrics recorded by the client so far
+func (c *Client) Metrics() *Metrics {
+	return c.metrics
+}
+
+// doRequest sends a request to the API. GET requests are retried up to the
+// configured number of times on network errors and 5xx or 429 responses, as
+// long as the retry budget carried by ctx allows it.
 func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body)
+		if method != http.MethodGet || attempt > c.retries || !isTransient(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+		if !retry.FromContext(ctx).Take() {
+			log.Printf("⚠️  Retry budget exhausted, not retrying %s %s", method, path)
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		log.Printf("🔁 Retrying %s %s (attempt %d/%d)", method, path, attempt+1, c.retries+1)
+		select {
+		case <-time.After(time.Duration(attempt) * apiRetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// isTransient reports whether a request outcome is worth retrying
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// send performs a single request attempt. path is relative to the API base
+// path, e.g. "/groups/x/projects".
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	path = c.apiBase + path
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -39,7 +155,9 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body io.Rea
 		req.Header.Set("Content-Type", "application/json")
 	}
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
+	c.metrics.record(endpointName(method, path), time.Since(start), err != nil || resp.StatusCode >= 400)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -47,49 +165,605 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body io.Rea
 	return resp, nil
 }
 
-// BaseURL returns the base URL of the GitLab instance
+// instanceRoot strips trailing slashes and any apiBase suffix from a GitLab
+// URL, keeping a relative-URL prefix intact
+func instanceRoot(rawURL, apiBase string) string {
+	root := strings.TrimRight(rawURL, "/")
+	if apiBase != "" {
+		root = strings.TrimSuffix(root, apiBase)
+	}
+	return strings.TrimRight(root, "/")
+}
+
+// BaseURL returns the base URL of the GitLab instance, including any
+// relative-URL prefix
 func (c *Client) BaseURL() string {
 	return c.baseURL
 }
 
 // CloneURL returns the base URL for git clone operations
 func (c *Client) CloneURL() string {
-	// Remove /api/v4 from the base URL if it exists
-	base := strings.TrimSuffix(c.baseURL, "/api/v4")
-	return base
+	return c.baseURL
 }
 
-func FetchGroupProjects(ctx context.Context, client *Client, group string) ([]config.RepoSpec, error) {
-	path := fmt.Sprintf("/api/v4/groups/%s/projects?per_page=100", group)
-	resp, err := client.doRequest(ctx, "GET", path, nil)
+// RepoCloneURL returns the HTTP(S) clone URL for a namespaced project path
+func (c *Client) RepoCloneURL(repoPath string) string {
+	return fmt.Sprintf("%s/%s.git", c.CloneURL(), strings.Trim(repoPath, "/"))
+}
+
+// ProjectFilter narrows down the projects returned by discovery
+type ProjectFilter struct {
+	Visibility    string // Only keep projects with this visibility; empty keeps all
+	IncludeEmpty  bool   // Keep repositories without any commits
+	MaxRepoSizeMB int    // Drop repositories larger than this; zero keeps all sizes
+	Topic         string // Only keep projects tagged with this topic; empty keeps all
+	SkipForks     bool   // Drop projects forked from another project
+}
+
+// NewProjectFilter builds the discovery filter from the configuration
+func NewProjectFilter(cfg *config.Config) ProjectFilter {
+	return ProjectFilter{
+		Visibility:    cfg.VisibilityFilter,
+		IncludeEmpty:  cfg.OnEmptyRepo == config.OnEmptyRepoInit,
+		MaxRepoSizeMB: cfg.MaxRepoSizeMB,
+		Topic:         cfg.ProjectLabelFilter,
+		SkipForks:     cfg.SkipForks,
+	}
+}
+
+// FetchGroupProjects returns the non-archived projects of a group that pass
+// filter. A group that exists but has no matching projects yields an empty
+// slice and a nil error, while a missing group yields ErrGroupNotFound.
+func FetchGroupProjects(ctx context.Context, client *Client, group string, filter ProjectFilter) ([]config.RepoSpec, error) {
+	path := fmt.Sprintf("/groups/%s/projects", url.PathEscape(group))
+	repos, err := fetchProjectList(ctx, client, path, group, filter)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, group)
+	}
+	return repos, err
+}
+
+// FetchUserProjects returns the non-archived projects in a user's personal
+// namespace that pass filter. A missing user yields ErrUserNotFound.
+func FetchUserProjects(ctx context.Context, client *Client, user string, filter ProjectFilter) ([]config.RepoSpec, error) {
+	path := fmt.Sprintf("/users/%s/projects", url.PathEscape(user))
+	repos, err := fetchProjectList(ctx, client, path, user, filter)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+	return repos, err
+}
+
+// errNotFound is returned by fetchProjectList when the namespace is missing
+var errNotFound = errors.New("not found")
+
+// perPage is the page size requested from list endpoints
+const perPage = 100
+
+// StreamGroupProjects is FetchGroupProjects, handing each project to fn as
+// soon as its page arrives instead of collecting them. An error from fn stops
+// the listing and is returned.
+func StreamGroupProjects(ctx context.Context, client *Client, group string, filter ProjectFilter, fn func(config.RepoSpec) error) error {
+	path := fmt.Sprintf("/groups/%s/projects", url.PathEscape(group))
+	err := walkProjectList(ctx, client, path, group, filter, fn)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, group)
+	}
+	return err
+}
+
+// StreamUserProjects is FetchUserProjects, handing each project to fn as
+// soon as its page arrives
+func StreamUserProjects(ctx context.Context, client *Client, user string, filter ProjectFilter, fn func(config.RepoSpec) error) error {
+	path := fmt.Sprintf("/users/%s/projects", url.PathEscape(user))
+	err := walkProjectList(ctx, client, path, user, filter, fn)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, user)
+	}
+	return err
+}
+
+// fetchProjectList collects every project walkProjectList finds
+func fetchProjectList(ctx context.Context, client *Client, path, namespace string, filter ProjectFilter) ([]config.RepoSpec, error) {
+	repos := []config.RepoSpec{}
+	err := walkProjectList(ctx, client, path, namespace, filter, func(repo config.RepoSpec) error {
+		repos = append(repos, repo)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return repos, nil
+}
+
+// walkProjectList pages through a project list endpoint until GitLab stops
+// announcing a next page, calling fn for each project that passes filter.
+// namespace names the source in log messages and is recorded on each project.
+func walkProjectList(ctx context.Context, client *Client, path, namespace string, filter ProjectFilter, fn func(config.RepoSpec) error) error {
+	query := url.Values{"per_page": {strconv.Itoa(perPage)}}
+	if filter.MaxRepoSizeMB > 0 {
+		query.Set("statistics", "true")
+	}
+
+	missingStats := 0
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		resp, err := client.doRequest(ctx, "GET", path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		projects, err := decodeProjectPage(resp)
+		if err != nil {
+			return err
+		}
+		page = resp.Header.Get("X-Next-Page")
+
+		for _, p := range projects {
+			keep, noStats := filter.admits(p)
+			if noStats {
+				missingStats++
+			}
+			if !keep {
+				continue
+			}
+			if err := fn(p.repoSpec(namespace)); err != nil {
+				return err
+			}
+		}
+	}
+	if missingStats > 0 {
+		log.Printf("⚠️  Warning: GitLab returned no statistics for %d projects in %s; max_repo_size_mb was not applied to them", missingStats, namespace)
+	}
+
+	return nil
+}
+
+// admits reports whether discovery keeps p, logging the skips worth
+// explaining. noStats is set when max_repo_size_mb applies but GitLab
+// returned no statistics, in which case p is kept.
+func (f ProjectFilter) admits(p projectListing) (keep, noStats bool) {
+	if p.Archived {
+		return false, false
+	}
+	if f.MaxRepoSizeMB > 0 {
+		// Statistics are only returned to tokens with at least Reporter access
+		if p.Statistics == nil {
+			noStats = true
+		} else if sizeMB := p.Statistics.RepositorySize / (1024 * 1024); sizeMB > int64(f.MaxRepoSizeMB) {
+			log.Printf("⏭️  Skipping %s: repository is %d MB, above max_repo_size_mb (%d MB)", p.PathWithNamespace, sizeMB, f.MaxRepoSizeMB)
+			return false, false
+		}
+	}
+	if f.Visibility != "" && p.Visibility != f.Visibility {
+		return false, noStats
+	}
+	if f.Topic != "" && !hasTopic(append(p.Topics, p.TagList...), f.Topic) {
+		return false, noStats
+	}
+	if p.ForkedFromProject != nil && f.SkipForks {
+		log.Printf("⏭️  Skipping %s: fork of %s", p.PathWithNamespace, p.ForkedFromProject.PathWithNamespace)
+		return false, noStats
+	}
+	// No default_branch can also mean the token can't see the repository, so only empty_repo counts
+	if p.EmptyRepo && !f.IncludeEmpty {
+		log.Printf("⏭️  Skipping empty repository %s", p.PathWithNamespace)
+		return false, noStats
+	}
+	return true, noStats
+}
+
+// FetchProject looks up a single project and returns it as discovery of
+// namespace would list it, along with whether filter keeps it
+func FetchProject(ctx context.Context, client *Client, projectPath, namespace string, filter ProjectFilter) (config.RepoSpec, bool, error) {
+	path := fmt.Sprintf("/projects/%s", url.PathEscape(projectPath))
+	if filter.MaxRepoSizeMB > 0 {
+		path += "?statistics=true"
+	}
+	resp, err := client.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return config.RepoSpec{}, false, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GitLab API error: %s", string(body))
+		return config.RepoSpec{}, false, newAPIError(resp)
+	}
+	var p projectListing
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return config.RepoSpec{}, false, err
 	}
+	keep, noStats := filter.admits(p)
+	if noStats {
+		log.Printf("⚠️  Warning: GitLab returned no statistics for %s; max_repo_size_mb was not applied to it", projectPath)
+	}
+	return p.repoSpec(namespace), keep, nil
+}
 
-	var projects []struct {
+// projectListing is the subset of a project in a list response used by roller
+type projectListing struct {
+	PathWithNamespace string   `json:"path_with_namespace"`
+	Archived          bool     `json:"archived"`
+	Visibility        string   `json:"visibility"`
+	EmptyRepo         bool     `json:"empty_repo"`
+	Topics            []string `json:"topics"`
+	TagList           []string `json:"tag_list"` // Deprecated name of topics on older GitLab versions
+	Statistics        *struct {
+		RepositorySize int64 `json:"repository_size"` // In bytes
+	} `json:"statistics"`
+	// Set only for forks; GitLab omits it for original projects
+	ForkedFromProject *struct {
 		PathWithNamespace string `json:"path_with_namespace"`
-		Archived          bool   `json:"archived"`
+	} `json:"forked_from_project"`
+}
+
+// repoSpec returns p as a discovered project of namespace
+func (p projectListing) repoSpec(namespace string) config.RepoSpec {
+	return config.RepoSpec{
+		RepoPath:   p.PathWithNamespace,
+		RoleName:   "", // Will be detected during clone
+		Visibility: p.Visibility,
+		EmptyRepo:  p.EmptyRepo,
+		Forked:     p.ForkedFromProject != nil,
+
+		SourceGroup: namespace,
+	}
+}
+
+// decodeProjectPage decodes one page of a project list response and closes
+// its body
+func decodeProjectPage(resp *http.Response) ([]projectListing, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
 	}
+
+	var projects []projectListing
 	if err := json.NewDecoder(resp.Body).Decode(&projects); err != nil {
 		return nil, err
 	}
+	return projects, nil
+}
 
-	var repos []config.RepoSpec
-	for _, p := range projects {
-		if p.Archived {
-			continue
+// hasTopic reports whether topics contains topic, ignoring case as GitLab does
+func hasTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if strings.EqualFold(t, topic) {
+			return true
 		}
-		repos = append(repos, config.RepoSpec{
-			RepoPath: p.PathWithNamespace,
-			RoleName: "", // Will be detected during clone
-		})
 	}
+	return false
+}
 
+// MergeRequestOptions describes a merge request to create
+type MergeRequestOptions struct {
+	SourceBranch       string
+	TargetBranch       string
+	Title              string
+	Description        string
+	Labels             []string
+	AssigneeIDs        []int
+	RemoveSourceBranch bool
+}
+
+// MergeRequest is the subset of the GitLab merge request object used by roller
+type MergeRequest struct {
+	IID    int    `json:"iid"`
+	State  string `json:"state"`
+	WebURL string `json:"web_url"`
+
+	MergeWhenPipelineSucceeds bool `json:"merge_when_pipeline_succeeds"`
+}
+
+// CreateMergeRequest opens a merge request in the project identified by its
+// namespaced path (e.g. "group/subgroup/repo")
+func CreateMergeRequest(ctx context.Context, client *Client, projectPath string, opts MergeRequestOptions) (*MergeRequest, error) {
+	payload := struct {
+		SourceBranch       string `json:"source_branch"`
+		TargetBranch       string `json:"target_branch"`
+		Title              string `json:"title"`
+		Description        string `json:"description,omitempty"`
+		Labels             string `json:"labels,omitempty"`
+		AssigneeIDs        []int  `json:"assignee_ids,omitempty"`
+		RemoveSourceBranch bool   `json:"remove_source_branch"`
+	}{
+		SourceBranch:       opts.SourceBranch,
+		TargetBranch:       opts.TargetBranch,
+		Title:              opts.Title,
+		Description:        opts.Description,
+		Labels:             strings.Join(opts.Labels, ","),
+		AssigneeIDs:        opts.AssigneeIDs,
+		RemoveSourceBranch: opts.RemoveSourceBranch,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode merge request: %w", err)
+	}
+
+	path := fmt.Sprintf("/projects/%s/merge_requests", url.PathEscape(projectPath))
+	resp, err := client.doRequest(ctx, "POST", path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp)
+	}
+
+	var mr MergeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+		return nil, err
+	}
+	return &mr, nil
+}
+
+// SetAutoMerge asks GitLab to merge the merge request once its pipeline
+// succeeds. GitLab answers 405 or 406 when the merge request can't be set to
+// auto-merge, e.g. because it has no pipeline or isn't mergeable yet; those
+// come back as an *APIError.
+func SetAutoMerge(ctx context.Context, client *Client, projectPath string, iid int) (*MergeRequest, error) {
+	path := fmt.Sprintf("/projects/%s/merge_requests/%d/merge?merge_when_pipeline_succeeds=true", url.PathEscape(projectPath), iid)
+	resp, err := client.doRequest(ctx, "PUT", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var mr MergeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+		return nil, err
+	}
+	return &mr, nil
+}
+
+// Branch is the subset of the GitLab branch object used by roller
+type Branch struct {
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+	Default   bool   `json:"default"`
+	Commit    struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+// CreateBranch creates branch from ref on the server side in the project
+// identified by its namespaced path
+func CreateBranch(ctx context.Context, client *Client, projectPath, branch, ref string) (*Branch, error) {
+	query := url.Values{"branch": {branch}, "ref": {ref}}
+	path := fmt.Sprintf("/projects/%s/repository/branches?%s", url.PathEscape(projectPath), query.Encode())
+	resp, err := client.doRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp)
+	}
+
+	var b Branch
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListBranches returns the branches of the project whose name contains
+// search, or all branches when search is empty
+func ListBranches(ctx context.Context, client *Client, projectPath, search string) ([]Branch, error) {
+	query := url.Values{}
+	if search != "" {
+		query.Set("search", search)
+	}
+	path := fmt.Sprintf("/projects/%s/repository/branches", url.PathEscape(projectPath))
+	return listAll[Branch](ctx, client, path, query)
+}
+
+// DeleteBranch deletes branch from the project identified by its namespaced
+// path
+func DeleteBranch(ctx context.Context, client *Client, projectPath, branch string) error {
+	path := fmt.Sprintf("/projects/%s/repository/branches/%s", url.PathEscape(projectPath), url.PathEscape(branch))
+	resp, err := client.doRequest(ctx, "DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return newAPIError(resp)
+	}
+	return nil
+}
+
+// ListMergeRequests returns the merge requests in any state whose source
+// branch is sourceBranch
+func ListMergeRequests(ctx context.Context, client *Client, projectPath, sourceBranch string) ([]MergeRequest, error) {
+	query := url.Values{"source_branch": {sourceBranch}, "state": {"all"}}
+	path := fmt.Sprintf("/projects/%s/merge_requests", url.PathEscape(projectPath))
+	return listAll[MergeRequest](ctx, client, path, query)
+}
+
+// listAll pages through a list endpoint like walkProjectList, until GitLab
+// stops announcing a next page, and returns the items of every page
+func listAll[T any](ctx context.Context, client *Client, path string, query url.Values) ([]T, error) {
+	query.Set("per_page", strconv.Itoa(perPage))
+	var items []T
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		resp, err := client.doRequest(ctx, "GET", path+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []T
+		if resp.StatusCode != http.StatusOK {
+			err = newAPIError(resp)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&batch)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return items, nil
+}
+
+// FetchProjects fetches the projects of several groups, running at most
+// concurrency group fetches at a time. Projects are returned in group order
+// with duplicates (e.g. a subgroup listed alongside its parent) removed.
+func FetchProjects(ctx context.Context, client *Client, groups []string, filter ProjectFilter, concurrency int) ([]config.RepoSpec, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([][]config.RepoSpec, len(groups))
+	errs := make([]error, len(groups))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			results[i], errs[i] = FetchGroupProjects(ctx, client, group, filter)
+		}()
+	}
+	wg.Wait()
+
+	repos := []config.RepoSpec{}
+	seen := make(map[string]bool)
+	for i, group := range groups {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("group %s: %w", group, errs[i])
+		}
+		for _, repo := range results[i] {
+			if seen[repo.RepoPath] {
+				continue
+			}
+			seen[repo.RepoPath] = true
+			repos = append(repos, repo)
+		}
+	}
 	return repos, nil
 }
+
+// FetchProjectLanguages returns the languages GitLab detected in the project
+// identified by its namespaced path, as language → percentage
+func FetchProjectLanguages(ctx context.Context, client *Client, projectPath string) (map[string]float64, error) {
+	path := fmt.Sprintf("/projects/%s/languages", url.PathEscape(projectPath))
+	resp, err := client.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var languages map[string]float64
+	if err := json.NewDecoder(resp.Body).Decode(&languages); err != nil {
+		return nil, err
+	}
+	return languages, nil
+}
+
+// PrimaryLanguage returns the language with the highest percentage, breaking
+// ties alphabetically; it returns "" when languages is empty
+func PrimaryLanguage(languages map[string]float64) string {
+	primary := ""
+	for lang, pct := range languages {
+		if primary == "" || pct > languages[primary] || (pct == languages[primary] && lang < primary) {
+			primary = lang
+		}
+	}
+	return primary
+}
+
+// TokenInfo is the subset of the GitLab personal access token object used by
+// roller
+type TokenInfo struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// FetchTokenInfo returns the scopes of the client's personal access token
+func FetchTokenInfo(ctx context.Context, client *Client) (*TokenInfo, error) {
+	resp, err := client.doRequest(ctx, "GET", "/personal_access_tokens/self", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// CheckProjectAccess verifies that the project identified by its namespaced
+// path exists and is readable with the client's token
+func CheckProjectAccess(ctx context.Context, client *Client, projectPath string) error {
+	path := fmt.Sprintf("/projects/%s", url.PathEscape(projectPath))
+	resp, err := client.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("project not found (404)")
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return fmt.Errorf("access denied (%d)", resp.StatusCode)
+	default:
+		return newAPIError(resp)
+	}
+}
+
+// connectivityTimeout bounds the startup reachability check, so an
+// unreachable host fails the run in seconds rather than at the HTTP timeout
+const connectivityTimeout = 5 * time.Second
+
+// CheckConnectivity sends a HEAD request to the GitLab instance root. Any
+// HTTP response counts as reachable, whatever its status; only timeouts,
+// refused connections, DNS and TLS failures are reported.
+func CheckConnectivity(ctx context.Context, client *Client) error {
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, client.baseURL+"/", nil)
+	if err != nil {
+		return fmt.Errorf("cannot reach GitLab at %s: %w", client.baseURL, err)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach GitLab at %s: %w", client.baseURL, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,472 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"roller/config"
+)
+
+// newTestClient returns a client for a fake GitLab serving handler
+func newTestClient(t *testing.T, cfg *config.Config, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cfg.GitlabURL = srv.URL
+	return NewClient(cfg, "test-token")
+}
+
+func TestFetchGroupProjectsSkipsEmptyRepos(t *testing.T) {
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		// limited has no default_branch because the token can't read its repository
+		w.Write([]byte(`[
+			{"path_with_namespace": "team/app", "default_branch": "main"},
+			{"path_with_namespace": "team/empty", "empty_repo": true},
+			{"path_with_namespace": "team/limited"}
+		]`))
+	}))
+
+	projects, err := FetchGroupProjects(context.Background(), client, "team", ProjectFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, p := range projects {
+		paths = append(paths, p.RepoPath)
+	}
+	if len(paths) != 2 || paths[0] != "team/app" || paths[1] != "team/limited" {
+		t.Errorf("projects = %v, want team/app and team/limited", paths)
+	}
+
+	projects, err = FetchGroupProjects(context.Background(), client, "team", ProjectFilter{IncludeEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 3 || !projects[1].EmptyRepo || projects[2].EmptyRepo {
+		t.Errorf("with IncludeEmpty, projects = %+v", projects)
+	}
+}
+
+func TestListBranchesFollowsPages(t *testing.T) {
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/team/app/repository/branches" || r.URL.Query().Get("search") != "roller" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "1":
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"name": "roller-1"}, {"name": "roller-2"}]`))
+		case "2":
+			w.Write([]byte(`[{"name": "roller-3"}]`))
+		}
+	}))
+
+	branches, err := ListBranches(context.Background(), client, "team/app", "roller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 3 || branches[2].Name != "roller-3" {
+		t.Errorf("branches = %+v, want all three across both pages", branches)
+	}
+}
+
+func TestClientCustomAPIBasePath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gitlab/mock/api/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"path_with_namespace": "team/app"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	// The base path may also be repeated at the end of gitlab_url
+	for _, gitlabURL := range []string{srv.URL + "/gitlab", srv.URL + "/gitlab/mock/api"} {
+		client := NewClient(&config.Config{GitlabURL: gitlabURL, APIBasePath: "/mock/api/"}, "test-token")
+		projects, err := FetchGroupProjects(context.Background(), client, "team", ProjectFilter{})
+		if err != nil {
+			t.Fatalf("%s: %v", gitlabURL, err)
+		}
+		if len(projects) != 1 || projects[0].RepoPath != "team/app" {
+			t.Errorf("%s: projects = %+v, want team/app", gitlabURL, projects)
+		}
+		if got, want := client.CloneURL(), srv.URL+"/gitlab"; got != want {
+			t.Errorf("%s: clone URL %s, want %s", gitlabURL, got, want)
+		}
+	}
+}
+
+func TestClientSubpathURLs(t *testing.T) {
+	var mu sync.Mutex
+	var apiPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		apiPath = r.URL.Path
+		mu.Unlock()
+		w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		gitlabURL, wantAPI, wantClone string
+	}{
+		{"", "/api/v4/groups/team/projects", "/team/app.git"},
+		{"/", "/api/v4/groups/team/projects", "/team/app.git"},
+		{"/api/v4", "/api/v4/groups/team/projects", "/team/app.git"},
+		{"/gitlab", "/gitlab/api/v4/groups/team/projects", "/gitlab/team/app.git"},
+		{"/gitlab/", "/gitlab/api/v4/groups/team/projects", "/gitlab/team/app.git"},
+		{"/gitlab/api/v4", "/gitlab/api/v4/groups/team/projects", "/gitlab/team/app.git"},
+		{"/gitlab/api/v4/", "/gitlab/api/v4/groups/team/projects", "/gitlab/team/app.git"},
+	}
+	for _, tt := range tests {
+		client := NewClient(&config.Config{GitlabURL: srv.URL + tt.gitlabURL}, "test-token")
+		if _, err := FetchGroupProjects(context.Background(), client, "team", ProjectFilter{}); err != nil {
+			t.Fatalf("%q: %v", tt.gitlabURL, err)
+		}
+		mu.Lock()
+		got := apiPath
+		mu.Unlock()
+		if got != tt.wantAPI {
+			t.Errorf("gitlab_url %q: API request to %s, want %s", tt.gitlabURL, got, tt.wantAPI)
+		}
+		if got, want := client.RepoCloneURL("team/app"), srv.URL+tt.wantClone; got != want {
+			t.Errorf("gitlab_url %q: clone URL %s, want %s", tt.gitlabURL, got, want)
+		}
+	}
+}
+
+func TestCheckConnectivityClosedPort(t *testing.T) {
+	// Take a free port and close it again so nothing is listening there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitlabURL := "http://" + l.Addr().String()
+	l.Close()
+
+	err = CheckConnectivity(context.Background(), NewClient(&config.Config{GitlabURL: gitlabURL}, "test-token"))
+	if err == nil || !strings.Contains(err.Error(), "cannot reach GitLab at "+gitlabURL+":") {
+		t.Fatalf("expected an unreachable GitLab error, got %v", err)
+	}
+}
+
+func TestCheckConnectivityReachable(t *testing.T) {
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Any answer, even one refusing the request, means GitLab is up
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	if err := CheckConnectivity(context.Background(), client); err != nil {
+		t.Errorf("reachable GitLab reported as unreachable: %v", err)
+	}
+}
+
+func TestCreateMergeRequestBody(t *testing.T) {
+	var body map[string]any
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/api/v4/projects/team%2Fapp/merge_requests" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"iid": 7, "web_url": "https://gitlab.example.com/team/app/-/merge_requests/7"}`))
+	}))
+
+	mr, err := CreateMergeRequest(context.Background(), client, "team/app", MergeRequestOptions{
+		SourceBranch:       "roller-updates",
+		TargetBranch:       "main",
+		Title:              "Update dependencies",
+		Labels:             []string{"automated", "deps"},
+		AssigneeIDs:        []int{12, 34},
+		RemoveSourceBranch: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.IID != 7 {
+		t.Errorf("merge request IID %d, want 7", mr.IID)
+	}
+
+	want := map[string]any{
+		"source_branch":        "roller-updates",
+		"target_branch":        "main",
+		"title":                "Update dependencies",
+		"labels":               "automated,deps",
+		"assignee_ids":         []any{12.0, 34.0},
+		"remove_source_branch": true,
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("request body %v, want %v", body, want)
+	}
+}
+
+// groupListing returns a client for a fake GitLab listing body as the
+// projects of group team
+func groupListing(t *testing.T, body string) *Client {
+	t.Helper()
+	return newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+// fetchPaths returns the paths of the projects of group team that filter keeps
+func fetchPaths(t *testing.T, client *Client, filter ProjectFilter) []string {
+	t.Helper()
+	projects, err := FetchGroupProjects(context.Background(), client, "team", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, p := range projects {
+		paths = append(paths, p.RepoPath)
+	}
+	return paths
+}
+
+func TestVisibilityFilter(t *testing.T) {
+	client := groupListing(t, `[
+		{"path_with_namespace": "team/secret", "visibility": "private"},
+		{"path_with_namespace": "team/shared", "visibility": "internal"},
+		{"path_with_namespace": "team/open", "visibility": "public"}
+	]`)
+
+	tests := []struct {
+		visibility string
+		want       []string
+	}{
+		{"", []string{"team/secret", "team/shared", "team/open"}},
+		{"private", []string{"team/secret"}},
+		{"internal", []string{"team/shared"}},
+		{"public", []string{"team/open"}},
+	}
+	for _, tt := range tests {
+		if got := fetchPaths(t, client, ProjectFilter{Visibility: tt.visibility}); !slices.Equal(got, tt.want) {
+			t.Errorf("visibility_filter %q: projects %v, want %v", tt.visibility, got, tt.want)
+		}
+	}
+
+	projects, err := FetchGroupProjects(context.Background(), client, "team", ProjectFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projects[0].Visibility != "private" || projects[2].Visibility != "public" {
+		t.Errorf("visibility not recorded on the projects: %+v", projects)
+	}
+}
+
+func TestFetchProjectsBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group, ok := strings.CutPrefix(r.URL.Path, "/api/v4/groups/")
+		group, ok2 := strings.CutSuffix(group, "/projects")
+		if !ok || !ok2 {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		// Every group also shares a project with the others
+		fmt.Fprintf(w, `[{"path_with_namespace": "%s/app"}, {"path_with_namespace": "shared/lib"}]`, group)
+	}))
+	groups := []string{"a", "b", "c", "d", "e"}
+
+	for _, concurrency := range []int{1, 2} {
+		mu.Lock()
+		maxInFlight = 0
+		mu.Unlock()
+		projects, err := FetchProjects(context.Background(), client, groups, ProjectFilter{}, concurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, p := range projects {
+			paths = append(paths, p.RepoPath)
+		}
+		if want := []string{"a/app", "shared/lib", "b/app", "c/app", "d/app", "e/app"}; !slices.Equal(paths, want) {
+			t.Errorf("concurrency %d: projects %v, want %v merged in group order without duplicates", concurrency, paths, want)
+		}
+		mu.Lock()
+		if maxInFlight > concurrency {
+			t.Errorf("concurrency %d: %d group listings ran at once", concurrency, maxInFlight)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestMaxRepoSizeFilter(t *testing.T) {
+	const mb = 1024 * 1024
+	var mu sync.Mutex
+	var statsRequested []bool
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		statsRequested = append(statsRequested, r.URL.Query().Get("statistics") == "true")
+		mu.Unlock()
+		// nostats is listed without statistics, as for a token lacking the scope to read them
+		fmt.Fprintf(w, `[
+			{"path_with_namespace": "team/small", "statistics": {"repository_size": %d}},
+			{"path_with_namespace": "team/limit", "statistics": {"repository_size": %d}},
+			{"path_with_namespace": "team/huge", "statistics": {"repository_size": %d}},
+			{"path_with_namespace": "team/nostats"}
+		]`, 5*mb, 100*mb, 4096*mb)
+	}))
+
+	if got, want := fetchPaths(t, client, ProjectFilter{}), []string{"team/small", "team/limit", "team/huge", "team/nostats"}; !slices.Equal(got, want) {
+		t.Errorf("without a limit: projects %v, want %v", got, want)
+	}
+	if got, want := fetchPaths(t, client, ProjectFilter{MaxRepoSizeMB: 100}), []string{"team/small", "team/limit", "team/nostats"}; !slices.Equal(got, want) {
+		t.Errorf("max_repo_size_mb 100: projects %v, want %v", got, want)
+	}
+	if !slices.Equal(statsRequested, []bool{false, true}) {
+		t.Errorf("statistics requested %v, want only when a limit is set", statsRequested)
+	}
+}
+
+func TestTopicFilter(t *testing.T) {
+	// legacy is from a GitLab version that still calls topics tag_list
+	client := groupListing(t, `[
+		{"path_with_namespace": "team/api", "topics": ["java", "roller-managed"]},
+		{"path_with_namespace": "team/web", "topics": ["node"]},
+		{"path_with_namespace": "team/legacy", "tag_list": ["Roller-Managed"]},
+		{"path_with_namespace": "team/bare"}
+	]`)
+
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"", []string{"team/api", "team/web", "team/legacy", "team/bare"}},
+		{"roller-managed", []string{"team/api", "team/legacy"}},
+		{"node", []string{"team/web"}},
+		{"python", nil},
+	}
+	for _, tt := range tests {
+		if got := fetchPaths(t, client, ProjectFilter{Topic: tt.topic}); !slices.Equal(got, tt.want) {
+			t.Errorf("project_label_filter %q: projects %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestFetchUserProjects(t *testing.T) {
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/users/jdoe/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		// Two pages, paged like the group listing
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"path_with_namespace": "jdoe/dotfiles", "topics": ["roller-managed"]}, {"path_with_namespace": "jdoe/scratch"}]`))
+			return
+		}
+		w.Write([]byte(`[{"path_with_namespace": "jdoe/tool", "topics": ["roller-managed"]}]`))
+	}))
+
+	projects, err := FetchUserProjects(context.Background(), client, "jdoe", ProjectFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, p := range projects {
+		paths = append(paths, p.RepoPath)
+		if p.SourceGroup != "jdoe" {
+			t.Errorf("%s: source %q, want the user jdoe", p.RepoPath, p.SourceGroup)
+		}
+	}
+	if want := []string{"jdoe/dotfiles", "jdoe/scratch", "jdoe/tool"}; !slices.Equal(paths, want) {
+		t.Errorf("projects %v, want %v", paths, want)
+	}
+
+	// Filters apply as for groups
+	projects, err = FetchUserProjects(context.Background(), client, "jdoe", ProjectFilter{Topic: "roller-managed"})
+	if err != nil || len(projects) != 2 {
+		t.Errorf("got %+v, %v, want the 2 tagged projects", projects, err)
+	}
+
+	if _, err := FetchUserProjects(context.Background(), client, "nobody", ProjectFilter{}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("got %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestNewClientTransport(t *testing.T) {
+	tests := []struct {
+		name                       string
+		cfg                        config.Config
+		idle, idlePerHost, perHost int
+	}{
+		{"defaults", config.Config{}, defaultMaxIdleConns, defaultMaxIdleConns, 0},
+		{"tuned", config.Config{MaxIdleConns: 64, MaxConnsPerHost: 8}, 64, 64, 8},
+	}
+	for _, tt := range tests {
+		client := NewClient(&tt.cfg, "test-token")
+		tr, ok := client.httpClient.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("%s: transport is %T, want *http.Transport", tt.name, client.httpClient.Transport)
+		}
+		if tr.MaxIdleConns != tt.idle || tr.MaxIdleConnsPerHost != tt.idlePerHost || tr.MaxConnsPerHost != tt.perHost {
+			t.Errorf("%s: got max idle %d, idle per host %d, per host %d, want %d, %d, %d",
+				tt.name, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, tt.idle, tt.idlePerHost, tt.perHost)
+		}
+		// The rest of net/http's defaults, such as the proxy settings, are kept
+		if tr.Proxy == nil || !tr.ForceAttemptHTTP2 {
+			t.Errorf("%s: transport lost net/http's defaults", tt.name)
+		}
+	}
+}
+
+func TestSkipForksFilter(t *testing.T) {
+	client := groupListing(t, `[
+		{"path_with_namespace": "team/api"},
+		{"path_with_namespace": "team/api-fork", "forked_from_project": {"id": 7, "path_with_namespace": "upstream/api"}},
+		{"path_with_namespace": "team/web", "forked_from_project": null}
+	]`)
+
+	projects, err := FetchGroupProjects(context.Background(), client, "team", ProjectFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forked := make(map[string]bool)
+	for _, p := range projects {
+		forked[p.RepoPath] = p.Forked
+	}
+	if want := map[string]bool{"team/api": false, "team/api-fork": true, "team/web": false}; !reflect.DeepEqual(forked, want) {
+		t.Errorf("forked %v, want %v", forked, want)
+	}
+
+	if got, want := fetchPaths(t, client, ProjectFilter{SkipForks: true}), []string{"team/api", "team/web"}; !slices.Equal(got, want) {
+		t.Errorf("skip_forks: projects %v, want %v", got, want)
+	}
+}
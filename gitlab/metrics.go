@@ -0,0 +1,94 @@
+package gitlab
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram; requests
+// slower than the last bound land in a final overflow bucket
+var LatencyBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// EndpointStats summarizes the requests made to one API endpoint
+type EndpointStats struct {
+	Requests     int
+	Errors       int           // Transport failures and responses with a 4xx/5xx status
+	TotalLatency time.Duration // Sum of all request latencies
+	Buckets      []int         // Request counts per LatencyBuckets bound, plus the overflow bucket
+}
+
+// MeanLatency returns the average latency of the endpoint's requests
+func (s EndpointStats) MeanLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+// Metrics records request counts, errors and latencies per endpoint. It is
+// safe for concurrent use.
+type Metrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointStats
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{endpoints: make(map[string]*EndpointStats)}
+}
+
+// record adds one request to the stats of endpoint
+func (m *Metrics) record(endpoint string, latency time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.endpoints[endpoint]
+	if !ok {
+		s = &EndpointStats{Buckets: make([]int, len(LatencyBuckets)+1)}
+		m.endpoints[endpoint] = s
+	}
+	s.Requests++
+	if failed {
+		s.Errors++
+	}
+	s.TotalLatency += latency
+	s.Buckets[sort.Search(len(LatencyBuckets), func(i int) bool { return latency <= LatencyBuckets[i] })]++
+}
+
+// Snapshot returns a copy of the stats keyed by endpoint, e.g.
+// "GET /api/v4/groups/:id/projects"
+func (m *Metrics) Snapshot() map[string]EndpointStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]EndpointStats, len(m.endpoints))
+	for name, s := range m.endpoints {
+		c := *s
+		c.Buckets = append([]int(nil), s.Buckets...)
+		out[name] = c
+	}
+	return out
+}
+
+// endpointName identifies the endpoint of a request path, dropping the query
+// and replacing the group, project or user identifier with ":id" so that
+// requests for different projects are counted together
+func endpointName(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(path, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "groups" || parts[i] == "projects" || parts[i] == "users" {
+			parts[i+1] = ":id"
+			i++
+		}
+	}
+	return method + " " + strings.Join(parts, "/")
+}
@@ -0,0 +1,46 @@
+package gitlab
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"roller/config"
+)
+
+func TestMetricsCountRequests(t *testing.T) {
+	client := newTestClient(t, &config.Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() == "/api/v4/projects/team%2Fgone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+
+	for _, path := range []string{"team/api", "team/web", "team/gone"} {
+		CheckProjectAccess(context.Background(), client, path)
+	}
+
+	stats := client.Metrics().Snapshot()
+	if len(stats) != 1 {
+		t.Fatalf("got endpoints %v, want requests for different projects counted together", stats)
+	}
+	for name, s := range stats {
+		if name != "GET /api/v4/projects/:id" {
+			t.Errorf("got endpoint %q, want GET /api/v4/projects/:id", name)
+		}
+		if s.Requests != 3 || s.Errors != 1 {
+			t.Errorf("got %d requests, %d errors, want 3 and 1", s.Requests, s.Errors)
+		}
+		total := 0
+		for _, n := range s.Buckets {
+			total += n
+		}
+		if total != s.Requests {
+			t.Errorf("histogram holds %d requests, want %d", total, s.Requests)
+		}
+		if s.MeanLatency() <= 0 {
+			t.Errorf("got mean latency %v, want it recorded", s.MeanLatency())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"roller/ratelimit"
+	"roller/runner"
+)
+
+// rateLimitedGitCommands are the git subcommands that talk to the server and
+// are paced by git_rate_limit
+var rateLimitedGitCommands = map[string]bool{"clone": true, "fetch": true, "pull": true, "push": true}
+
+// gitRateLimiter is a runner that waits for the git rate limiter before
+// running a git command that connects to the server
+type gitRateLimiter struct {
+	runner.Runner
+	bucket *ratelimit.Bucket
+}
+
+// withGitRateLimit wraps r so git network operations are paced to perSecond;
+// r is returned unchanged when no limit is configured
+func withGitRateLimit(r runner.Runner, perSecond float64) runner.Runner {
+	bucket := ratelimit.NewBucket(perSecond)
+	if bucket == nil {
+		return r
+	}
+	return &gitRateLimiter{Runner: r, bucket: bucket}
+}
+
+func (g *gitRateLimiter) Run(ctx context.Context, cmd runner.Cmd) error {
+	if err := g.wait(ctx, cmd); err != nil {
+		return err
+	}
+	return g.Runner.Run(ctx, cmd)
+}
+
+func (g *gitRateLimiter) Output(ctx context.Context, cmd runner.Cmd) ([]byte, error) {
+	if err := g.wait(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return g.Runner.Output(ctx, cmd)
+}
+
+func (g *gitRateLimiter) wait(ctx context.Context, cmd runner.Cmd) error {
+	if cmd.Name != "git" || !rateLimitedGitCommands[gitSubcommand(cmd.Args)] {
+		return nil
+	}
+	return g.bucket.Wait(ctx)
+}
+
+// gitSubcommand returns the subcommand of a git invocation, skipping global
+// options such as "-c key=value"
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c" || args[i] == "-C":
+			i++ // Skip the option's value
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return args[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"roller/runner"
+)
+
+func TestGitRateLimitPacesNetworkCommands(t *testing.T) {
+	f := &runner.Fake{}
+	ctx := context.Background()
+
+	// Local commands and other programs are never held back, even right after
+	// a clone used up the only token of the second
+	slow := withGitRateLimit(f, 1)
+	start := time.Now()
+	slow.Run(ctx, runner.Cmd{Name: "git", Args: []string{"clone", "url", "repos/lib"}})
+	for i := 0; i < 10; i++ {
+		slow.Run(ctx, runner.Cmd{Name: "git", Args: []string{"status", "--porcelain"}})
+		slow.Run(ctx, runner.Cmd{Name: "ansible-playbook", Args: []string{"clone.yml"}})
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("local commands took %v, want them unpaced", elapsed)
+	}
+
+	r := withGitRateLimit(f, 20) // One network operation every 50ms
+	start = time.Now()
+	r.Run(ctx, runner.Cmd{Name: "git", Args: []string{"-c", "protocol.version=1", "clone", "url", "repos/app"}})
+	r.Output(ctx, runner.Cmd{Name: "git", Args: []string{"fetch", "origin"}})
+	r.Run(ctx, runner.Cmd{Name: "git", Args: []string{"push", "origin", "HEAD"}})
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 git network operations at 20/s took %v, want at least 100ms", elapsed)
+	}
+	if got := len(f.Calls()); got != 24 {
+		t.Errorf("got %d commands run, want 24", got)
+	}
+
+	if withGitRateLimit(f, 0) != runner.Runner(f) {
+		t.Error("without git_rate_limit the runner should be returned unwrapped")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// inferFromOrigin reads the origin remote of the clone in dir and derives
+// the GitLab URL and the repository's group from it
+func inferFromOrigin(ctx context.Context, r runner.Runner, dir string) (*config.Inferred, error) {
+	out, err := r.Output(ctx, runner.Cmd{Dir: dir, Name: "git", Args: []string{"remote", "get-url", "origin"}})
+	if err != nil {
+		return nil, fmt.Errorf("git remote get-url origin failed in %s: %w", dir, err)
+	}
+	gitlabURL, group, err := parseRemoteURL(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, err
+	}
+	return &config.Inferred{GitlabURL: gitlabURL, Group: group}, nil
+}
+
+// parseRemoteURL splits a git remote into the GitLab base URL and the
+// namespace holding the repository. HTTPS remotes keep their scheme and
+// port; SSH remotes, either "git@host:group/repo.git" or
+// "ssh://git@host:port/group/repo.git", are assumed to be served over HTTPS.
+// GitLab instances under a relative URL path can't be told apart from a
+// namespace and are not supported.
+func parseRemoteURL(remote string) (gitlabURL, group string, err error) {
+	var host, repoPath string
+	switch {
+	case strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://"):
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid remote URL %q: %w", remote, err)
+		}
+		gitlabURL = u.Scheme + "://" + u.Host
+		repoPath = u.Path
+	case strings.HasPrefix(remote, "ssh://"):
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid remote URL %q: %w", remote, err)
+		}
+		host = u.Hostname()
+		repoPath = u.Path
+	default:
+		// scp-like syntax: [user@]host:path
+		at := strings.LastIndex(remote, "@")
+		hostPath := remote[at+1:]
+		var ok bool
+		host, repoPath, ok = strings.Cut(hostPath, ":")
+		if !ok || host == "" {
+			return "", "", fmt.Errorf("unrecognized remote URL %q", remote)
+		}
+	}
+	if gitlabURL == "" {
+		gitlabURL = "https://" + host
+	}
+
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	group = path.Dir(repoPath)
+	if repoPath == "" || group == "." {
+		return "", "", fmt.Errorf("remote URL %q has no group/repository path", remote)
+	}
+	return gitlabURL, group, nil
+}
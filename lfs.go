@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// lfsSkipSmudgeEnv keeps git-lfs from downloading objects while checking out
+const lfsSkipSmudgeEnv = "GIT_LFS_SKIP_SMUDGE=1"
+
+// lfsCheckoutEnv returns the environment for clones and checkouts. LFS
+// objects are never downloaded implicitly: with git_lfs "skip" the pointer
+// files are all a run gets, otherwise fetchLFS pulls them explicitly.
+func lfsCheckoutEnv() []string {
+	return []string{lfsSkipSmudgeEnv}
+}
+
+// usesLFS reports whether the .gitattributes at the root of destDir routes
+// any files through the LFS filter
+func usesLFS(destDir string) bool {
+	data, err := os.ReadFile(filepath.Join(destDir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), "filter=lfs")
+}
+
+// fetchLFS downloads the LFS objects of the clone in destDir according to
+// git_lfs: always for "fetch", only when .gitattributes uses LFS for "auto"
+// (the default) and never for "skip". A failed pull is fatal only with
+// "fetch"; in "auto" mode the pointer files are left in place.
+func fetchLFS(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath, destDir string) error {
+	switch cfg.GitLFS {
+	case config.GitLFSSkip:
+		return nil
+	case config.GitLFSFetch:
+	default:
+		if !usesLFS(destDir) {
+			return nil
+		}
+	}
+
+	log.Printf("📦 Fetching LFS objects for %s", repoPath)
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"lfs", "pull"}}); err != nil {
+		if cfg.GitLFS == config.GitLFSFetch {
+			return fmt.Errorf("git lfs pull failed in %s: %w", destDir, err)
+		}
+		log.Printf("⚠️  Warning: Could not fetch LFS objects for %s, leaving pointer files (set git_lfs: %s to fail instead): %v", repoPath, config.GitLFSFetch, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+func TestGitLFSModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		lfsRepo  bool
+		pullFail bool
+		wantPull bool
+		wantErr  bool
+	}{
+		{"", true, false, true, false},
+		{"", false, false, false, false},
+		{config.GitLFSAuto, true, true, true, false},
+		{config.GitLFSSkip, true, false, false, false},
+		{config.GitLFSFetch, false, false, true, false},
+		{config.GitLFSFetch, true, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Chdir(t.TempDir())
+		cfg := testConfig()
+		cfg.GitLFS = tt.mode
+		// The clone writes a .gitattributes that does or doesn't route files through LFS
+		f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+			switch {
+			case c.Args[0] == "clone":
+				dest := c.Args[len(c.Args)-1]
+				attrs := "*.sh text eol=lf\n"
+				if tt.lfsRepo {
+					attrs += "*.bin filter=lfs diff=lfs merge=lfs -text\n"
+				}
+				if err := os.MkdirAll(filepath.Join(dest, ".git"), 0o755); err != nil {
+					return nil, err
+				}
+				return nil, os.WriteFile(filepath.Join(dest, ".gitattributes"), []byte(attrs), 0o644)
+			case c.Args[0] == "lfs" && tt.pullFail:
+				return nil, errors.New("git: 'lfs' is not a git command")
+			}
+			return nil, nil
+		}}
+
+		var res report.Result
+		err := cloneAndCreateBranch(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil, &res)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("git_lfs %q, LFS repo %t: got error %v, want error %t", tt.mode, tt.lfsRepo, err, tt.wantErr)
+		}
+		pulled := false
+		for _, c := range f.Calls() {
+			if c.Args[0] == "clone" && !slices.Contains(c.Env, lfsSkipSmudgeEnv) {
+				t.Errorf("git_lfs %q: clone env %v lacks %s", tt.mode, c.Env, lfsSkipSmudgeEnv)
+			}
+			if slices.Equal(c.Args, []string{"lfs", "pull"}) {
+				pulled = true
+			}
+		}
+		if pulled != tt.wantPull {
+			t.Errorf("git_lfs %q, LFS repo %t: git lfs pull run %t, want %t", tt.mode, tt.lfsRepo, pulled, tt.wantPull)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"roller/config"
+	"roller/report"
+	"roller/runner"
+)
+
+// localRepos returns the names of the subdirectories of dir in lexical order.
+// Hidden directories are left out.
+func localRepos(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && e.Name()[0] != '.' {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// processLocalDir treats every subdirectory of dir as a project that is
+// already on disk, for air-gapped setups: each one is checked out on the
+// feature branch, its role detected and Ansible run in place, using
+// cfg.Concurrency workers. Nothing is cloned, pushed or sent to GitLab, so the
+// changes are left in the working trees. Results are returned in directory
+// order.
+func processLocalDir(ctx context.Context, r runner.Runner, cfg *config.Config, dir string, runAnsible bool) ([]report.Result, error) {
+	names, err := localRepos(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	workers := cfg.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	collector := report.NewCollector(len(names))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				collector.Set(i, processLocalRepo(ctx, r, cfg, names[i], filepath.Join(dir, names[i]), runAnsible))
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return collector.Results(), nil
+}
+
+// processLocalRepo prepares the repository in destDir under the per-repo
+// timeout and records its outcome
+func processLocalRepo(ctx context.Context, r runner.Runner, cfg *config.Config, name, destDir string, runAnsible bool) report.Result {
+	res := report.Result{RepoPath: name, Status: report.StatusSuccess}
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, repoTimeout+cfg.AnsibleTimeout)
+	defer cancel()
+
+	err := prepareLocalRepo(ctx, r, cfg, name, destDir, runAnsible, &res)
+	elapsed := time.Since(start)
+	res.DurationMS = elapsed.Milliseconds()
+	if err != nil {
+		log.Printf("⚠️  Error processing %s after %s: %v", name, formatDuration(elapsed), err)
+		res.Status = report.StatusFailed
+		res.Error = err.Error()
+		res.ErrorClass = classifyError(err)
+		return res
+	}
+	log.Printf("✅ done %s in %s", name, formatDuration(elapsed))
+	return res
+}
+
+// prepareLocalRepo is cloneAndCreateBranch for a repository already on disk:
+// it checks out the feature branch, creating it from the current HEAD if
+// needed, detects the role and runs Ansible
+func prepareLocalRepo(ctx context.Context, r runner.Runner, cfg *config.Config, name, destDir string, runAnsible bool, res *report.Result) error {
+	if _, err := os.Stat(filepath.Join(destDir, ".git")); err != nil {
+		return fmt.Errorf("%s is not a git repository", destDir)
+	}
+	if err := reuseClone(ctx, r, cfg, name, destDir, "", ""); err != nil {
+		return err
+	}
+
+	role, err := detectRole(ctx, r, cfg, destDir)
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not detect repository type for %s: %v", name, err)
+		role = cfg.UnknownRole
+	} else {
+		log.Printf("📦 Repository type for %s: %s", name, role)
+	}
+	res.Role = role
+
+	if cfg.IsManualOnly(role) {
+		log.Printf("⏭️  Skipping %s: role %s is manual-only", name, role)
+		res.Status = report.StatusSkipped
+		res.Reason = "manual-only role"
+		return nil
+	}
+	if !runAnsible {
+		log.Printf("⏭️  Skipping Ansible playbook execution for %s", name)
+		return nil
+	}
+
+	actx, cancel := withAnsibleTimeout(ctx, cfg)
+	defer cancel()
+	if err := runAnsiblePlaybook(actx, r, cfg, config.RepoSpec{RepoPath: name, RoleName: role}, destDir, nil); err != nil {
+		if cfg.AnsibleFailuresFatal() {
+			return err
+		}
+		log.Printf("⚠️  Warning: Ansible playbook execution failed for %s: %v", name, err)
+		return nil
+	}
+	log.Printf("✅ Successfully ran Ansible playbook for %s; changes are left uncommitted in %s", name, destDir)
+	return nil
+}
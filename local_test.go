@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"roller/report"
+	"roller/runner"
+)
+
+func TestProcessLocalDir(t *testing.T) {
+	// Ansible runs from the working directory, which has no repos/ at all
+	t.Chdir(t.TempDir())
+	dir := t.TempDir()
+	for _, name := range []string{"app", "lib", "notes", ".cache"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"app", "lib"} {
+		if err := os.Mkdir(filepath.Join(dir, name, ".git"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "detect-role" {
+			return []byte("java"), nil
+		}
+		return nil, nil
+	}}
+	cfg := testConfig()
+	cfg.RoleDetectorCommand = "detect-role"
+
+	results, err := processLocalDir(context.Background(), f, cfg, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hidden directories are left out and anything without .git fails
+	want := []report.Result{
+		{RepoPath: "app", Role: "java", Status: report.StatusSuccess},
+		{RepoPath: "lib", Role: "java", Status: report.StatusSuccess},
+		{RepoPath: "notes", Status: report.StatusFailed},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, res := range results {
+		if res.RepoPath != want[i].RepoPath || res.Role != want[i].Role || res.Status != want[i].Status {
+			t.Errorf("result %d = %s %q %s, want %s %q %s", i, res.RepoPath, res.Role, res.Status, want[i].RepoPath, want[i].Role, want[i].Status)
+		}
+	}
+	if !strings.Contains(results[2].Error, "not a git repository") {
+		t.Errorf("notes failed with %q, want it reported as not a git repository", results[2].Error)
+	}
+
+	// Each playbook run is pointed at its own repository in dir
+	var runs [][]string
+	for _, c := range f.Calls() {
+		if c.Name == defaultAnsiblePath {
+			runs = append(runs, repoPathsArg(c))
+		}
+	}
+	wantRuns := [][]string{{filepath.Join(dir, "app")}, {filepath.Join(dir, "lib")}}
+	if !reflect.DeepEqual(runs, wantRuns) {
+		t.Errorf("ansible-playbook ran for %v, want %v", runs, wantRuns)
+	}
+
+	// The feature branch is checked out in place, never cloned
+	if got := countCalls(f, "git", "clone"); got != 0 {
+		t.Errorf("%d clones, want none", got)
+	}
+	if got := countCalls(f, "git", "checkout"); got == 0 {
+		t.Error("feature branch was never checked out")
+	}
+}
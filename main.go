@@ -2,27 +2,86 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"roller/branches"
 	"roller/config"
+	"roller/deps"
 	"roller/gitlab"
+	"roller/report"
+	"roller/retry"
+	"roller/runner"
+	"roller/tracing"
 )
 
-// detectRepoType checks for common dependency files in the repository
-func detectRepoType(repoPath string) (string, error) {
+// roleMarkerFile lets a repository declare its role explicitly at its root
+const roleMarkerFile = ".roller-role"
+
+// builtinRoles are the roles detectRepoType can return
+var builtinRoles = []string{"pom", "pip", "js-monorepo", "node", "swift", "xcode"}
+
+// isKnownRole reports whether role is a built-in role or has a dependency
+// parser registered
+func isKnownRole(role string) bool {
+	for _, r := range builtinRoles {
+		if r == role {
+			return true
+		}
+	}
+	_, ok := deps.FileName(role)
+	return ok
+}
+
+// isCustomRole reports whether one of the custom detectors yields role
+func isCustomRole(custom map[string]string, role string) bool {
+	for _, r := range custom {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// detectRepoType returns the role declared in the repository's .roller-role
+// file, or otherwise checks for common dependency files in the repository.
+// custom maps additional file names to roles; those take precedence over the
+// built-in detectors, including for file names they share. Directories in
+// ignoreDirs, typically vendored dependencies, are not searched.
+func detectRepoType(repoPath string, custom map[string]string, ignoreDirs []string) (string, error) {
+	if b, err := os.ReadFile(filepath.Join(repoPath, roleMarkerFile)); err == nil {
+		role := strings.TrimSpace(string(b))
+		if !isKnownRole(role) && !isCustomRole(custom, role) {
+			return "", fmt.Errorf("%s declares unknown role %q", roleMarkerFile, role)
+		}
+		return role, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", roleMarkerFile, err)
+	}
+
 	// Check for common dependency files
 	dependencyFiles := map[string]bool{
 		"pom.xml":          false,
 		"requirements.txt": false,
 		"package.json":     false,
+		"Package.swift":    false,
+	}
+	for name := range custom {
+		dependencyFiles[name] = false
 	}
+	hasXcodeProject := false
 
 	// Walk through the repository directory
 	err := filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
@@ -33,6 +92,14 @@ func detectRepoType(repoPath string) (string, error) {
 		if info.IsDir() && info.Name() == ".git" {
 			return filepath.SkipDir
 		}
+		// Xcode projects are directory bundles; there is nothing to find inside them
+		if info.IsDir() && strings.HasSuffix(info.Name(), ".xcodeproj") {
+			hasXcodeProject = true
+			return filepath.SkipDir
+		}
+		if info.IsDir() && path != repoPath && isIgnoredDir(repoPath, path, ignoreDirs) {
+			return filepath.SkipDir
+		}
 		// Check if the file is one of our dependency files
 		if !info.IsDir() {
 			if _, exists := dependencyFiles[info.Name()]; exists {
@@ -46,65 +113,458 @@ func detectRepoType(repoPath string) (string, error) {
 		return "", fmt.Errorf("error scanning repository: %w", err)
 	}
 
+	// Custom detectors win, checked in file name order so the result is stable
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if dependencyFiles[name] {
+			return custom[name], nil
+		}
+	}
+
 	// Determine the role based on found files
 	switch {
 	case dependencyFiles["pom.xml"]:
 		return "pom", nil
 	case dependencyFiles["requirements.txt"]:
 		return "pip", nil
+	case isJSMonorepo(repoPath):
+		return "js-monorepo", nil
 	case dependencyFiles["package.json"]:
 		return "node", nil
+	case dependencyFiles["Package.swift"]:
+		return "swift", nil
+	case hasXcodeProject:
+		return "xcode", nil
 	default:
 		return "", fmt.Errorf("no supported package manager found")
 	}
 }
 
+// monorepoConfigFiles are workspace tool configs that mark a JS monorepo
+// when present at the repository root
+var monorepoConfigFiles = []string{"nx.json", "turbo.json", "lerna.json"}
+
+// isJSMonorepo reports whether the repository root holds an nx, turbo or
+// lerna workspace config
+func isJSMonorepo(repoPath string) bool {
+	for _, name := range monorepoConfigFiles {
+		if info, err := os.Stat(filepath.Join(repoPath, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// detectRole determines the role of the repository at repoPath. When
+// role_detector_command is configured it is run with the repository path as
+// its last argument and the first line of its output is used as the role;
+// built-in detection is the fallback if the command fails or prints nothing.
+func detectRole(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath string) (string, error) {
+	if fields := strings.Fields(cfg.RoleDetectorCommand); len(fields) > 0 {
+		cmd := runner.Cmd{Name: fields[0], Args: append(fields[1:], repoPath)}
+		out, err := r.Output(ctx, cmd)
+		if err != nil {
+			log.Printf("⚠️  Warning: Role detector command failed for %s, using built-in detection: %v", repoPath, err)
+		} else if role, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); role != "" {
+			return strings.TrimSpace(role), nil
+		}
+	}
+	return detectRepoType(repoPath, cfg.CustomDetectors, cfg.DetectionIgnoredDirs())
+}
+
+// isIgnoredDir reports whether dir, below repoPath, matches one of
+// ignoreDirs: a bare name matches a directory of that name anywhere, a path
+// with a slash matches that directory relative to the repository root
+func isIgnoredDir(repoPath, dir string, ignoreDirs []string) bool {
+	rel, err := filepath.Rel(repoPath, dir)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, ignored := range ignoreDirs {
+		ignored = strings.Trim(ignored, "/")
+		if strings.Contains(ignored, "/") {
+			if rel == ignored {
+				return true
+			}
+		} else if filepath.Base(dir) == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cloneDirName returns the directory name used for repoPath's clone. With
+// dir_naming "full-path" the namespace is kept, e.g. "group__sub__repo", so
+// that equally named repositories in different groups get distinct clones.
+// preserve_namespace takes precedence and nests the clone in one directory
+// per namespace level, e.g. "group/sub/repo".
+func cloneDirName(cfg *config.Config, repoPath string) string {
+	if cfg.PreserveNamespace {
+		return filepath.FromSlash(strings.Trim(repoPath, "/"))
+	}
+	if cfg.DirNaming == config.DirNamingFullPath {
+		return strings.ReplaceAll(strings.Trim(repoPath, "/"), "/", "__")
+	}
+	return path.Base(repoPath)
+}
+
+// gitConfigArgs returns the "-c key=value" options placed before the git
+// subcommand of every clone
+func gitConfigArgs(cfg *config.Config) []string {
+	var args []string
+	if cfg.GitProtocolVersion != nil {
+		args = append(args, "-c", "protocol.version="+strconv.Itoa(*cfg.GitProtocolVersion))
+	}
+	return args
+}
+
+// cloneDepth is the history depth used for all clones
+const cloneDepth = 1
+
+// cloneArgs builds the git arguments for cloning cloneURL into destDir. An
+// empty branch clones the remote's default branch. --depth implies
+// --single-branch, and submodules are cloned shallow to match. With noCheckout
+// the working tree is left empty for a sparse checkout.
+func cloneArgs(cfg *config.Config, cloneURL, branch, destDir string, noCheckout bool) []string {
+	args := append(gitConfigArgs(cfg), "clone", "--depth", strconv.Itoa(cloneDepth))
+	if noCheckout {
+		args = append(args, "--no-checkout")
+	}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	if cfg.CloneSubmodules {
+		args = append(args, "--recurse-submodules")
+		if cloneDepth > 0 {
+			args = append(args, "--shallow-submodules")
+		}
+	}
+	return append(args, cloneURL, destDir)
+}
+
+// isMissingBranchError reports whether a failed git clone failed because the
+// requested --branch does not exist on the remote
+func isMissingBranchError(err error) bool {
+	stderr := runner.Stderr(err)
+	return strings.Contains(stderr, "Remote branch") && strings.Contains(stderr, "not found")
+}
+
+// removePartialClone deletes whatever a failed or cancelled clone left in
+// destDir so the next run doesn't trip over a half-cloned repository.
+// Directories that existed before the clone started are left alone.
+func removePartialClone(destDir string, preexisting bool) {
+	if preexisting {
+		return
+	}
+	if err := os.RemoveAll(destDir); err != nil {
+		log.Printf("⚠️  Warning: Failed to remove partial clone %s: %v", destDir, err)
+	}
+}
+
+// defaultCloneRetryDelay is the pause before each clone retry, multiplied by
+// the attempt
+const defaultCloneRetryDelay = 5 * time.Second
+
+// rateLimitRetryDelay is the pause before retrying a rate-limited clone,
+// multiplied by the attempt, when GitLab gave no retry hint
+const rateLimitRetryDelay = 30 * time.Second
+
+// runClone runs a git clone into destDir, retrying up to cfg.CloneRetries
+// times while the retry budget carried by ctx allows it. Only transient failures are
+// retried, so auth errors and missing repositories or branches fail fast. A
+// clone into a directory that already existed is never retried either,
+// since cleaning up between attempts would remove it.
+func runClone(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath, destDir string, cmd runner.Cmd) (err error) {
+	ctx, span := tracing.Start(ctx, "clone", tracing.RepoKey.String(repoPath))
+	defer func() { tracing.End(span, err) }()
+
+	_, statErr := os.Stat(destDir)
+	preexisting := statErr == nil
+
+	for attempt := 1; ; attempt++ {
+		err = runCloneAttempt(ctx, r, cfg, cmd)
+		if err == nil || attempt > cfg.CloneRetries || preexisting || ctx.Err() != nil {
+			return err
+		}
+		class := classifyError(err)
+		if class != report.ErrorClassTransient && class != report.ErrorClassRateLimit {
+			log.Printf("⏭️  Not retrying clone of %s: %s error", repoPath, class)
+			return err
+		}
+		if !retry.FromContext(ctx).Take() {
+			log.Printf("⚠️  Retry budget exhausted, not retrying clone of %s", repoPath)
+			return err
+		}
+		removePartialClone(destDir, false)
+		delay := time.Duration(attempt) * defaultCloneRetryDelay
+		if class == report.ErrorClassRateLimit {
+			delay = time.Duration(attempt) * rateLimitRetryDelay
+			if hint, ok := gitRetryAfter(err); ok {
+				delay = hint
+			}
+			log.Printf("🚦 GitLab rate-limited the clone of %s, backing off for %s", repoPath, delay)
+		}
+		log.Printf("🔁 Retrying clone of %s (attempt %d/%d) in %s: %v", repoPath, attempt+1, cfg.CloneRetries+1, delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// runCloneAttempt runs one clone attempt, bounded by clone_timeout if set
+func runCloneAttempt(ctx context.Context, r runner.Runner, cfg *config.Config, cmd runner.Cmd) error {
+	if cfg.CloneTimeout <= 0 {
+		return r.Run(ctx, cmd)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, cfg.CloneTimeout)
+	defer cancel()
+	err := r.Run(attemptCtx, cmd)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("clone timed out after %s (clone_timeout): %w", cfg.CloneTimeout, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// cloneTarget clones targetBranch of repoPath into destDir and returns the
+// branch that was cloned. When the branch is missing and on_missing_branch is
+// "use-default", the default branch is cloned and returned instead. With
+// noCheckout nothing is checked out yet.
+func cloneTarget(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath, cloneURL, destDir, targetBranch string, noCheckout bool) (string, error) {
+	log.Printf("📥 Cloning %s into %s (branch: %s)", repoPath, destDir, targetBranch)
+	cmd := runner.Cmd{Env: lfsCheckoutEnv(), Name: "git", Args: cloneArgs(cfg, cloneURL, targetBranch, destDir, noCheckout)}
+	err := runClone(ctx, r, cfg, repoPath, destDir, cmd)
+	if err == nil {
+		return targetBranch, nil
+	}
+	if !isMissingBranchError(err) || cfg.OnMissingBranch != config.OnMissingBranchUseDefault {
+		return "", fmt.Errorf("git clone failed for %s: %w", repoPath, err)
+	}
+
+	// The target branch doesn't exist here; fall back to the default branch
+	removePartialClone(destDir, false)
+	cmd = runner.Cmd{Env: lfsCheckoutEnv(), Name: "git", Args: cloneArgs(cfg, cloneURL, "", destDir, noCheckout)}
+	if err := runClone(ctx, r, cfg, repoPath, destDir, cmd); err != nil {
+		return "", fmt.Errorf("git clone of default branch failed for %s: %w", repoPath, err)
+	}
+	out, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"rev-parse", "--abbrev-ref", "HEAD"}})
+	if err != nil {
+		return "", fmt.Errorf("could not determine default branch of %s: %w", repoPath, err)
+	}
+	defaultBranch := strings.TrimSpace(string(out))
+	log.Printf("⚠️  Warning: Target branch %s not found in %s, cloned default branch %s instead", targetBranch, repoPath, defaultBranch)
+	return defaultBranch, nil
+}
+
 // cloneAndCreateBranch clones a single project into "repos/<name>" and creates a feature branch.
 // Returns an error if anything fails.
-func cloneAndCreateBranch(ctx context.Context, client *gitlab.Client, token, targetBranch, featureBranch, repoPath string, runAnsible bool) error {
-	cloneURL := fmt.Sprintf("%s/%s.git", client.CloneURL(), repoPath)
-	repoName := path.Base(repoPath) // e.g., "myrepo" from "group/subgroup/myrepo"
-	destDir := filepath.Join("repos", repoName)
+// The detected role is recorded on res.
+// With a non-nil batch, Ansible is deferred: the prepared clone is queued on
+// batch instead and its playbook and merge request are handled later.
+func cloneAndCreateBranch(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, proj config.RepoSpec, runAnsible bool, batch *ansibleBatch, res *report.Result) error {
+	repoPath := proj.RepoPath
+	cloneURL := client.RepoCloneURL(repoPath)
+	destDir := cloneDestDir(cfg, repoPath) // e.g., "repos/myrepo" from "group/subgroup/myrepo"
 
-	log.Printf("📥 Cloning %s into %s (branch: %s)", repoPath, destDir, targetBranch)
-	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", "--branch", targetBranch, cloneURL, destDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git clone failed for %s: %w", repoPath, err)
+	targetBranch := cfg.TargetBranch
+	if proj.TargetBranch != "" {
+		targetBranch = proj.TargetBranch
+	}
+
+	// Without Ansible there is nothing to change locally, so the branch can be created server-side
+	if cfg.CreateBranchViaAPI && !runAnsible {
+		log.Printf("🌿 Creating branch %s from %s in %s via the GitLab API", cfg.FeatureBranch, targetBranch, repoPath)
+		bctx, span := tracing.Start(ctx, "branch", tracing.RepoKey.String(repoPath))
+		branch, err := gitlab.CreateBranch(bctx, client, repoPath, cfg.FeatureBranch, targetBranch)
+		tracing.End(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to create branch %s in %s: %w", cfg.FeatureBranch, repoPath, err)
+		}
+		log.Printf("✅ Created %s at %s in %s", branch.Name, branch.Commit.ID, repoPath)
+		return nil
+	}
+
+	// A directory that was there before we started is never ours to remove
+	_, statErr := os.Stat(destDir)
+	preexisting := statErr == nil
+	reuse := preexisting && cfg.OnExisting == config.OnExistingReuse
+	if preexisting && cfg.OnExisting == config.OnExistingClobber {
+		// Removal was confirmed up front, so the directory is ours from here on
+		log.Printf("🧹 Removing existing clone %s", destDir)
+		if err := os.RemoveAll(destDir); err != nil {
+			return fmt.Errorf("failed to remove existing clone %s: %w", destDir, err)
+		}
+		preexisting = false
+	}
+
+	// Refuse to start a clone that could fill up the disk mid-way
+	if !reuse {
+		full, usedMB, err := workspaceFull(cfg)
+		if err != nil {
+			return err
+		}
+		if full {
+			log.Printf("⏭️  Skipping %s: workspace %s uses %d MB, reaching max_workspace_size_mb %d", repoPath, reposDir, usedMB, cfg.MaxWorkspaceSizeMB)
+			res.Status = report.StatusSkipped
+			res.Reason = fmt.Sprintf("workspace full (%d MB of max_workspace_size_mb %d)", usedMB, cfg.MaxWorkspaceSizeMB)
+			return nil
+		}
+	}
+
+	if reuse {
+		if err := reuseClone(ctx, r, cfg, repoPath, destDir, cloneURL, targetBranch); err != nil {
+			return err
+		}
+	} else if proj.EmptyRepo {
+		// There is no branch to clone; start the feature branch from an unborn HEAD
+		log.Printf("📥 Cloning empty repository %s into %s", repoPath, destDir)
+		cmd := runner.Cmd{Name: "git", Args: append(gitConfigArgs(cfg), "clone", cloneURL, destDir)}
+		if err := runClone(ctx, r, cfg, repoPath, destDir, cmd); err != nil {
+			removePartialClone(destDir, preexisting)
+			return fmt.Errorf("git clone failed for empty repository %s: %w", repoPath, err)
+		}
+	} else {
+		sparse := sparsePaths(cfg, proj)
+		cloned, err := cloneTarget(ctx, r, cfg, repoPath, cloneURL, destDir, targetBranch, len(sparse) > 0)
+		if err != nil {
+			removePartialClone(destDir, preexisting)
+			return err
+		}
+		targetBranch = cloned
+		if len(sparse) > 0 {
+			if err := sparseCheckout(ctx, r, destDir, targetBranch, sparse, lfsCheckoutEnv()); err != nil {
+				removePartialClone(destDir, preexisting)
+				return err
+			}
+		}
+		if err := fetchLFS(ctx, r, cfg, repoPath, destDir); err != nil {
+			return err
+		}
 	}
 
 	// Now create & checkout the feature branch
-	log.Printf("✨ Checking out feature branch %s in %s", featureBranch, destDir)
-	cmd = exec.CommandContext(ctx, "git", "checkout", "-b", featureBranch)
-	cmd.Dir = destDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git checkout -b %s failed in %s: %w", featureBranch, destDir, err)
+	if !reuse {
+		log.Printf("✨ Checking out feature branch %s in %s", cfg.FeatureBranch, destDir)
+		bctx, span := tracing.Start(ctx, "branch", tracing.RepoKey.String(repoPath))
+		err := createFeatureBranch(bctx, r, cfg, destDir)
+		tracing.End(span, err)
+		if err != nil {
+			return fmt.Errorf("git checkout -b %s failed in %s: %w", cfg.FeatureBranch, destDir, err)
+		}
+	}
+	if cfg.RecordRunID && cfg.RunID != "" {
+		cmd := runner.Cmd{Dir: destDir, Name: "git", Args: []string{"config", runIDGitKey, cfg.RunID}}
+		if err := r.Run(ctx, cmd); err != nil {
+			return fmt.Errorf("failed to record run ID in %s: %w", destDir, err)
+		}
 	}
 
 	// Detect repository type
-	role, err := detectRepoType(destDir)
+	role, err := detectRole(ctx, r, cfg, destDir)
 	if err != nil {
 		log.Printf("⚠️  Warning: Could not detect repository type for %s: %v", repoPath, err)
+		role = cfg.UnknownRole
 	} else {
 		log.Printf("📦 Repository type for %s: %s", repoPath, role)
 	}
+	res.Role = role
+
+	log.Printf("✅ Successfully prepared %s (feature: %s)", repoPath, cfg.FeatureBranch)
 
-	log.Printf("✅ Successfully prepared %s (feature: %s)", repoPath, featureBranch)
+	if cfg.IsManualOnly(role) {
+		log.Printf("⏭️  Skipping %s: role %s is manual-only", repoPath, role)
+		res.Status = report.StatusSkipped
+		res.Reason = "manual-only role"
+		return nil
+	}
+
+	// Nothing to do for repositories already at the desired dependency versions
+	if cfg.SkipIfUpToDate && len(cfg.Updates) > 0 && role != "" {
+		current, err := deps.ParseDependencies(destDir, role)
+		if err != nil {
+			log.Printf("⚠️  Warning: Could not parse dependencies for %s, running anyway: %v", repoPath, err)
+		} else if deps.UpToDate(current, cfg.Updates) {
+			log.Printf("⏭️  %s already up to date, skipping", repoPath)
+			res.Status = report.StatusSkipped
+			res.Reason = "already up to date"
+			return nil
+		}
+	}
 
 	// Run Ansible playbook only if requested
 	if runAnsible {
-		log.Printf("🔧 Running Ansible playbook for %s", repoPath)
-		cmd = exec.CommandContext(ctx, "ansible-playbook", filepath.Join("ansible", "site.yml"))
-		cmd.Dir = "." // Run from the workspace root
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		// Refuse to run the playbook for roles nobody has mapped yet
+		if cfg.StrictRoleMapping {
+			if _, ok := cfg.AnsibleRoles[role]; !ok {
+				return fmt.Errorf("detected role %q for %s has no ansible_roles mapping (strict_role_mapping is enabled)", role, repoPath)
+			}
+		}
+
+		// Find out now, not after the playbook, if the changes couldn't be pushed
+		if cfg.CreateMergeRequest {
+			if err := checkPushAccess(ctx, r, cfg, repoPath, destDir); err != nil {
+				return err
+			}
+		}
+
+		// Make sure the generated changes are based on the current target branch
+		if cfg.RebaseOnTarget {
+			log.Printf("🔄 Rebasing %s onto latest %s", cfg.FeatureBranch, targetBranch)
+			if err := branches.EnsureUpToDate(ctx, r, destDir, targetBranch); err != nil {
+				return err
+			}
+		}
+
+		var bump func() error
+		if cfg.BumpDependencies && len(cfg.Updates) > 0 && role != "" {
+			bump = func() error { return bumpDependencies(cfg, repoPath, destDir, role) }
+		}
+
+		if batch != nil {
+			if bump != nil {
+				if err := bump(); err != nil {
+					return err
+				}
+			}
+			log.Printf("⏸️  Deferring Ansible for %s until all %s repositories are cloned", repoPath, displayRole(role))
+			batch.add(role, batchedRepo{RepoPath: repoPath, DestDir: destDir, TargetBranch: targetBranch, FeatureBranch: cfg.FeatureBranch})
+			return nil
+		}
+
+		actx, span := tracing.Start(ctx, "ansible", tracing.RepoKey.String(repoPath), tracing.RoleKey.String(role))
+		actx, cancel := withAnsibleTimeout(actx, cfg)
+		// The bump is redone after each reset between Ansible attempts
+		err := runAnsiblePlaybook(actx, r, cfg, proj, destDir, bump)
+		cancel()
+		tracing.End(span, err)
+		if err != nil {
+			if cfg.AnsibleFailuresFatal() {
+				return err
+			}
 			log.Printf("⚠️  Warning: Ansible playbook execution failed for %s: %v", repoPath, err)
-		} else {
-			log.Printf("✅ Successfully ran Ansible playbook for %s", repoPath)
+			return nil
+		}
+		log.Printf("✅ Successfully ran Ansible playbook for %s", repoPath)
+
+		// Publish the playbook's changes as a merge request if requested
+		if cfg.CreateMergeRequest {
+			return publishChanges(ctx, r, client, cfg, repoPath, destDir, role, targetBranch, res)
 		}
 	} else {
 		log.Printf("⏭️  Skipping Ansible playbook execution for %s", repoPath)
@@ -113,137 +573,706 @@ func cloneAndCreateBranch(ctx context.Context, client *gitlab.Client, token, tar
 	return nil
 }
 
-// discoverAndExportProjects performs auto-discovery, determines roles, and exports to YAML
-func discoverAndExportProjects(ctx context.Context, client *gitlab.Client, group string, outputPath string) error {
-	// Fetch projects from GitLab group
-	log.Printf("🔍 Fetching projects from group: %s", group)
-	projects, err := gitlab.FetchGroupProjects(ctx, client, group)
+// bumpDependencies rewrites the dependency file in destDir to the configured
+// updates versions
+func bumpDependencies(cfg *config.Config, repoPath, destDir, role string) error {
+	changed, err := deps.UpdateDependencies(destDir, role, cfg.Updates)
+	if err != nil {
+		return fmt.Errorf("failed to bump dependencies of %s: %w", repoPath, err)
+	}
+	if len(changed) > 0 {
+		log.Printf("⬆️  Bumped %s in %s", strings.Join(changed, ", "), repoPath)
+	}
+	return nil
+}
+
+// discoverOptions controls how discovery results are exported
+type discoverOptions struct {
+	OutputPath string // YAML file the projects are written to
+	SplitDir   string // Directory receiving one <group>.yaml per source group instead of OutputPath, if set
+	EmptyOK    bool   // Report an empty group as a warning instead of an error
+	OnlyRole   string // Only export projects whose detected role matches, if set
+	SkipRoles  bool   // List projects without cloning them, leaving roles empty
+
+	GroupByRole   bool // Export a role → paths map instead of a flat project list
+	ExportMatrix  bool // Export a GitLab CI parallel matrix instead of a flat project list
+	WithMetadata  bool // Record the source group and discovery time in the export
+	WithLanguages bool // Look up and export each project's dominant GitLab language
+
+	InventoryCSV  string // CSV file listing every repository, its role and dependency file, if set
+	InventoryDeps bool   // Write one inventory row per dependency instead of per repository
+	ExportGraph   string // File the graph of repositories and the internal packages they depend on is written to, if set
+
+	DiffPath string // Previous export the discovered projects are compared with, if set
+	DiffJSON string // File the comparison with DiffPath is also written to as JSON, if set
+}
+
+// fetchAutoDiscovered lists the projects of the configured discovery groups
+// or user, with group_defaults applied
+func fetchAutoDiscovered(ctx context.Context, client *gitlab.Client, cfg *config.Config) ([]config.RepoSpec, error) {
+	filter := gitlab.NewProjectFilter(cfg)
+	var projects []config.RepoSpec
+	var err error
+	if user := cfg.DiscoveryUser(); user != "" {
+		projects, err = gitlab.FetchUserProjects(ctx, client, user, filter)
+	} else {
+		projects, err = gitlab.FetchProjects(ctx, client, cfg.DiscoveryGroups(), filter, cfg.DiscoveryConcurrency)
+	}
+	for i := range projects {
+		projects[i] = cfg.ApplyGroupDefaults(projects[i])
+	}
+	return projects, err
+}
+
+// sourceGroup names the discovered namespaces for the export metadata: the
+// comma-separated groups, or the user
+func sourceGroup(cfg *config.Config) string {
+	if user := cfg.DiscoveryUser(); user != "" {
+		return user
+	}
+	return strings.Join(cfg.DiscoveryGroups(), ",")
+}
+
+// discoverProjects fetches the projects of the configured groups or user and clones
+// each one into a temporary directory to detect its role and dependencies.
+// It returns the projects with their roles filled in and an inventory entry
+// per project.
+func discoverProjects(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config) ([]config.RepoSpec, []report.InventoryEntry, error) {
+	// Fetch projects from the GitLab groups or user namespace
+	log.Printf("🔍 Fetching projects from %s", cfg.DiscoverySource())
+	projects, err := fetchAutoDiscovered(ctx, client, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to fetch projects: %w", err)
+		return nil, nil, fmt.Errorf("failed to fetch projects: %w", err)
+	}
+	if len(projects) == 0 {
+		return projects, nil, nil
 	}
 
-	// Create temporary directory for cloning
-	tempDir := filepath.Join("repos", "temp")
-	if err := os.MkdirAll(tempDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+	// Create a temporary directory for cloning, unique to this run so that
+	// concurrent runs never clone into or remove each other's directories
+	tempDir, err := os.MkdirTemp("", "roller-discover-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
-	defer os.RemoveAll(tempDir) // Clean up temp directory
+	failed := false
+	defer func() { cleanupTempDir(cfg, tempDir, failed) }()
 
 	// Process each project to determine its role
+	inventory := make([]report.InventoryEntry, len(projects))
 	for i, proj := range projects {
+		inventory[i].RepoPath = proj.RepoPath
+
 		// Clone the repository using the clone URL format
-		cloneURL := fmt.Sprintf("%s/%s.git", client.CloneURL(), proj.RepoPath)
-		repoName := path.Base(proj.RepoPath)
+		cloneURL := client.RepoCloneURL(proj.RepoPath)
+		repoName := cloneDirName(cfg, proj.RepoPath)
 		destDir := filepath.Join(tempDir, repoName)
 
 		log.Printf("📥 Cloning %s to detect role", proj.RepoPath)
-		cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", cloneURL, destDir)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		cmd := runner.Cmd{Env: lfsCheckoutEnv(), Name: "git", Args: append(gitConfigArgs(cfg), "clone", "--depth", "1", cloneURL, destDir)}
+		if err := runClone(ctx, r, cfg, proj.RepoPath, destDir, cmd); err != nil {
 			log.Printf("⚠️  Warning: Failed to clone %s: %v", proj.RepoPath, err)
+			failed = true
 			continue
 		}
 
 		// Detect role
-		role, err := detectRepoType(destDir)
+		role, err := detectRole(ctx, r, cfg, destDir)
 		if err != nil {
 			log.Printf("⚠️  Warning: Could not detect role for %s: %v", proj.RepoPath, err)
+			failed = true
+			projects[i].RoleName = cfg.UnknownRole
+			inventory[i].Role = cfg.UnknownRole
 			continue
 		}
 
 		// Update project with detected role
 		projects[i].RoleName = role
 		log.Printf("✅ Detected role for %s: %s", proj.RepoPath, role)
+
+		inventory[i].Role = role
+		inventory[i].DependencyFile, _ = deps.FileName(role)
+
+		// Report the dependency versions currently declared by the project
+		dependencies, err := deps.ParseDependencies(destDir, role)
+		if err != nil {
+			log.Printf("⚠️  Warning: Could not parse dependencies for %s: %v", proj.RepoPath, err)
+			continue
+		}
+		inventory[i].Dependencies = dependencies
+		log.Printf("📦 Found %d declared dependencies in %s", len(dependencies), proj.RepoPath)
+	}
+
+	return projects, inventory, nil
+}
+
+// listProjects fetches the projects of the configured groups or user without
+// cloning anything, so roles and dependencies are left empty
+func listProjects(ctx context.Context, client *gitlab.Client, cfg *config.Config) ([]config.RepoSpec, []report.InventoryEntry, error) {
+	log.Printf("🔍 Listing projects from %s without role detection", cfg.DiscoverySource())
+	projects, err := fetchAutoDiscovered(ctx, client, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch projects: %w", err)
+	}
+	inventory := make([]report.InventoryEntry, len(projects))
+	for i, proj := range projects {
+		inventory[i].RepoPath = proj.RepoPath
+	}
+	return projects, inventory, nil
+}
+
+// discoverAndExportProjects performs auto-discovery, determines roles, and exports to YAML
+func discoverAndExportProjects(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, opts discoverOptions) (err error) {
+	ctx, span := tracing.Start(ctx, "discovery", tracing.SourceKey.String(cfg.DiscoverySource()))
+	defer func() { tracing.End(span, err) }()
+
+	discoveredAt := time.Now()
+	var projects []config.RepoSpec
+	var inventory []report.InventoryEntry
+	if opts.SkipRoles {
+		projects, inventory, err = listProjects(ctx, client, cfg)
+	} else {
+		projects, inventory, err = discoverProjects(ctx, r, client, cfg)
+	}
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		source := cfg.DiscoverySource()
+		if !opts.EmptyOK {
+			return fmt.Errorf("%s has no active projects", source)
+		}
+		log.Printf("⚠️  Warning: %s has no active projects; nothing to export", source)
+		return nil
+	}
+
+	if opts.InventoryCSV != "" {
+		if err := report.WriteInventoryCSV(opts.InventoryCSV, inventory, opts.InventoryDeps); err != nil {
+			return fmt.Errorf("failed to write inventory: %w", err)
+		}
+		log.Printf("📝 Wrote dependency inventory for %d projects to %s", len(inventory), opts.InventoryCSV)
+	}
+	if opts.ExportGraph != "" {
+		graph := report.BuildDependencyGraph(inventory, cfg.InternalPackagePrefixes)
+		if err := report.WriteGraph(opts.ExportGraph, graph); err != nil {
+			return err
+		}
+		log.Printf("🕸️  Wrote dependency graph of %d packages and %d dependents to %s", len(graph.Packages), len(graph.Edges), opts.ExportGraph)
+	}
+
+	if opts.DiffPath != "" {
+		if err := diffAgainst(opts.DiffPath, opts.DiffJSON, projects, !opts.SkipRoles); err != nil {
+			return fmt.Errorf("failed to compare with %s: %w", opts.DiffPath, err)
+		}
+	}
+
+	// Narrow the export down to a single role if requested
+	if opts.OnlyRole != "" {
+		projects = filterByRole(projects, opts.OnlyRole)
+		if len(projects) == 0 {
+			log.Printf("⚠️  Warning: No projects with role %s found; nothing exported", opts.OnlyRole)
+			return nil
+		}
 	}
 
-	// Export projects to YAML
-	if err := config.ExportDiscoveredProjects(outputPath, projects); err != nil {
+	// Cross-check file-based role detection against GitLab's own language stats
+	if opts.WithLanguages {
+		for i, proj := range projects {
+			languages, err := gitlab.FetchProjectLanguages(ctx, client, proj.RepoPath)
+			if err != nil {
+				log.Printf("⚠️  Warning: Could not fetch languages for %s: %v", proj.RepoPath, err)
+				continue
+			}
+			projects[i].Language = gitlab.PrimaryLanguage(languages)
+		}
+	}
+
+	// Export projects to YAML, in a stable order unless API order was asked for
+	config.SortProjects(projects, cfg.SortBy)
+	exportOpts := config.ExportOptions{GroupByRole: opts.GroupByRole, Matrix: opts.ExportMatrix}
+	if opts.WithMetadata {
+		exportOpts.Metadata = &config.ExportMetadata{
+			SourceGroup:  sourceGroup(cfg),
+			DiscoveredAt: discoveredAt,
+		}
+	}
+	if opts.SplitDir != "" {
+		return exportSplit(opts.SplitDir, projects, exportOpts)
+	}
+	if err := config.ExportDiscoveredProjects(opts.OutputPath, projects, exportOpts); err != nil {
 		return fmt.Errorf("failed to export projects: %w", err)
 	}
 
-	log.Printf("✅ Successfully exported %d projects to %s", len(projects), outputPath)
+	log.Printf("✅ Successfully exported %d projects to %s", len(projects), opts.OutputPath)
+	return nil
+}
+
+// filterByRole returns the projects whose role equals role
+func filterByRole(projects []config.RepoSpec, role string) []config.RepoSpec {
+	var filtered []config.RepoSpec
+	for _, proj := range projects {
+		if proj.RoleName == role {
+			filtered = append(filtered, proj)
+		}
+	}
+	return filtered
+}
+
+// flushTracing sends any buffered spans, giving up after a few seconds so an
+// unreachable collector can't hold up the exit
+func flushTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		log.Printf("⚠️  Warning: Failed to flush traces: %v", err)
+	}
+}
+
+// stringList is a flag that may be given several times, collecting every value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// writeReports writes rep as JSON to jsonPath and as JUnit XML to junitPath,
+// skipping whichever path is empty
+func writeReports(rep report.Report, jsonPath, junitPath string) error {
+	if jsonPath != "" {
+		if err := report.WriteJSON(jsonPath, rep); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		log.Printf("📝 Wrote report to %s", jsonPath)
+	}
+	if junitPath != "" {
+		if err := report.WriteJUnit(junitPath, rep); err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+		log.Printf("📝 Wrote JUnit report to %s", junitPath)
+	}
 	return nil
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run carries out the command line's request. Errors are returned rather than
+// fatal, so that buffered spans are still flushed before main exits.
+func run() error {
 	// Parse command line flags
 	discoverFlag := flag.Bool("discover", false, "Run in discovery mode to detect roles and export to YAML")
 	outputFlag := flag.String("output", "discovered_projects.yaml", "Output file for discovered projects (used with -discover)")
+	splitOutputFlag := flag.String("split-output", "", "Write one <group>.yaml per source group into this directory instead of -output (used with -discover)")
 	runAnsibleFlag := flag.Bool("ansible", true, "Run Ansible playbook after cloning (default: true)")
+	emptyOKFlag := flag.Bool("empty-ok", false, "Treat an empty auto-discovered group as a warning and exit 0 instead of failing")
+	skipRolesFlag := flag.Bool("skip-roles", false, "List discovered projects without cloning them, leaving roles empty (used with -discover)")
+	onlyRoleFlag := flag.String("only-role", "", "Only export discovered projects with this detected role (used with -discover)")
+	groupByRoleFlag := flag.Bool("group-by-role", false, "Export discovered projects as a map of role to repository paths (used with -discover)")
+	exportMatrixFlag := flag.Bool("export-matrix", false, "Export discovered projects as a GitLab CI parallel matrix of PROJECT and ROLE (used with -discover)")
+	withLanguagesFlag := flag.Bool("with-languages", false, "Record each project's dominant GitLab language in the exported file, at one extra API request per project (used with -discover)")
+	withMetadataFlag := flag.Bool("with-metadata", false, "Record the source group and discovery time in the exported file (used with -discover)")
+	inventoryCSVFlag := flag.String("inventory-csv", "", "Write a CSV inventory of repositories, roles and dependency files (used with -discover)")
+	inventoryDepsFlag := flag.Bool("inventory-deps", false, "Write one inventory row per declared dependency (used with -inventory-csv)")
+	exportGraphFlag := flag.String("export-graph", "", "Write a graph of which repositories depend on which internal packages to this file, as DOT for .dot/.gv and JSON otherwise (used with -discover)")
+	diffFlag := flag.String("diff", "", "Compare discovered projects and roles with this previously exported file and report the changes (used with -discover)")
+	diffJSONFlag := flag.String("diff-json", "", "Also write the -diff changes to this file as JSON")
+	projectsStdinFlag := flag.Bool("projects-stdin", false, "Read additional newline-delimited repository paths from stdin")
+	projectsCSVFlag := flag.String("projects-csv", "", "Read additional repositories from a CSV file with path, role and target_branch columns")
+	junitFlag := flag.String("junit", "", "Write per-repository results to this file as JUnit XML")
+	reportFlag := flag.String("report", "", "Write a JSON report of per-repository results to this file")
+	detectFlag := flag.String("detect", "", "Print the role detected for this local directory, then exit")
+	bootstrapFlag := flag.String("bootstrap", "", "Discover this group and write a complete, runnable config to -bootstrap-output, then exit")
+	bootstrapOutputFlag := flag.String("bootstrap-output", "roller.yaml", "Config file written by -bootstrap (never overwritten)")
+	gitlabURLFlag := flag.String("gitlab-url", "", "GitLab URL; overrides gitlab_url (required with -bootstrap)")
+	targetBranchFlag := flag.String("target-branch", "", "Target branch; overrides ROLLER_TARGET_BRANCH and target_branch (-bootstrap defaults to \"main\")")
+	featureBranchFlag := flag.String("feature-branch", "", "Feature branch; overrides ROLLER_FEATURE_BRANCH and feature_branch (-bootstrap defaults to \"roller-updates\")")
+	onExistingFlag := flag.String("on-existing", "", "What to do when a clone directory already exists: \"error\", \"reuse\" or \"clobber\"; overrides on_existing")
+	allowDirtyFlag := flag.Bool("allow-dirty", false, "Reuse existing clones even if they have uncommitted changes (used with -on-existing reuse)")
+	pruneBranchesFlag := flag.Bool("prune-branches", false, "List feature branches whose merge requests are all merged or closed, deleting them with -confirm, then exit")
+	confirmFlag := flag.Bool("confirm", false, "Allow -prune-branches to delete branches")
+	yesFlag := flag.Bool("yes", false, "Answer yes to confirmation prompts, e.g. for non-interactive CI runs; implies -confirm")
+	dryRunFlag := flag.Bool("dry-run", false, "Only report what -prune-branches would delete")
+	resumeFlag := flag.Bool("resume", false, "Skip repositories the checkpoint file records as completed for the feature branch, whatever its feature_branch_suffix")
+	checkpointFlag := flag.String("checkpoint", defaultCheckpointPath, "Checkpoint file recording repositories completed successfully")
+	preflightFlag := flag.Bool("preflight", false, "Verify every configured project exists and is accessible before cloning")
+	serveFlag := flag.String("serve", "", "Listen on this address (e.g. \":8080\") for GitLab push webhooks and process each pushed project, until interrupted")
+	localDirFlag := flag.String("local-dir", "", "Process the git repositories in this directory's subdirectories in place, without GitLab or cloning, then exit")
+	statusFlag := flag.Bool("status", false, "Report the branch, uncommitted and unpushed state of each clone in repos/, then exit")
+	apiMetricsFlag := flag.Bool("api-metrics", false, "Log per-endpoint GitLab API request counts, errors and latencies at the end of the run")
+	colorFlag := flag.String("color", colorAuto, "Color log output: \"auto\" (on a terminal, unless NO_COLOR is set), \"always\" or \"never\"")
+	runIDFlag := flag.String("run-id", "", "Identifier for this run, shown in logs and the report; generated when empty")
+	skipConnectivityFlag := flag.Bool("skip-connectivity-check", false, "Don't check that gitlab_url is reachable before starting")
+	printConfigFlag := flag.Bool("print-config", false, "Print the effective configuration, after overlays, environment variables and flags, as YAML with secrets redacted, then exit")
+	allowUnknownFlag := flag.Bool("allow-unknown-fields", false, "Accept keys in config, overlay and projects files that don't match any setting instead of failing")
+	inferFlag := flag.Bool("infer", false, "Fill in gitlab_url and auto_discover.group from the origin remote of the current directory when the config leaves them unset")
+	var configFlag stringList
+	flag.Var(&configFlag, "config", "Config file to load; repeat to merge several, concatenating their projects (default roller.yaml)")
+	overlayFlag := flag.String("overlay", "", "Optional YAML file merged over roller.yaml (e.g. per-environment overrides)")
 	flag.Parse()
 
-	// 1. Load config: bail out immediately if it fails
-	cfg, err := config.LoadConfig("roller.yaml")
+	runID := startRun(*runIDFlag, time.Now())
+
+	color, err := useColor(*colorFlag, isTerminal(os.Stderr), os.Getenv("NO_COLOR") != "")
+	if err != nil {
+		return err
+	}
+	if color {
+		log.SetOutput(colorWriter{w: os.Stderr})
+	}
+
+	// Spans are exported only when OTEL_EXPORTER_OTLP_* asks for it
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to set up tracing: %w", err)
+	}
+	defer flushTracing(shutdownTracing)
+
+	// Detection diagnostics need neither a config nor GitLab
+	if *detectFlag != "" {
+		if err := printDetection(os.Stdout, *detectFlag); err != nil {
+			return fmt.Errorf("Detection failed: %w", err)
+		}
+		return nil
+	}
+
+	// Bootstrapping produces roller.yaml, so it runs before any config is loaded
+	if *bootstrapFlag != "" {
+		err := bootstrapConfig(context.Background(), runner.New(), os.Getenv("GITLAB_TOKEN"), bootstrapOptions{
+			Group:         *bootstrapFlag,
+			GitlabURL:     *gitlabURLFlag,
+			TargetBranch:  *targetBranchFlag,
+			FeatureBranch: *featureBranchFlag,
+			OutputPath:    *bootstrapOutputFlag,
+		})
+		if err != nil {
+			return fmt.Errorf("Bootstrap failed: %w", err)
+		}
+		return nil
+	}
+
+	// 1. Load config (plus optional overlay): bail out immediately if it fails
+	var overlays []string
+	if *overlayFlag != "" {
+		overlays = append(overlays, *overlayFlag)
+	}
+	var inferred *config.Inferred
+	if *inferFlag {
+		inferred, err = inferFromOrigin(context.Background(), runner.New(), ".")
+		if err != nil {
+			return fmt.Errorf("Could not infer settings from the origin remote: %w", err)
+		}
+		log.Printf("🧭 Inferred GitLab %s and group %s from the origin remote", inferred.GitlabURL, inferred.Group)
+	}
+	configPaths := []string(configFlag)
+	if len(configPaths) == 0 {
+		configPaths = []string{"roller.yaml"}
+	}
+	cfg, err := config.LoadConfigs(configPaths, config.LoadOptions{Inferred: inferred, ProjectsFromStdin: *projectsStdinFlag, LocalOnly: *localDirFlag != "", AllowUnknownFields: *allowUnknownFlag}, overlays...)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		return fmt.Errorf("Error loading config: %w", err)
+	}
+
+	// Explicit flags win over ROLLER_* environment variables and the config file
+	if *gitlabURLFlag != "" {
+		cfg.GitlabURL = *gitlabURLFlag
+	}
+	if *targetBranchFlag != "" {
+		cfg.TargetBranch = *targetBranchFlag
+	}
+	if *featureBranchFlag != "" {
+		cfg.FeatureBranch = *featureBranchFlag
+	}
+	if *onExistingFlag != "" {
+		cfg.OnExisting = *onExistingFlag
+	}
+	if *allowDirtyFlag {
+		cfg.AllowDirty = true
+	}
+	cfg.RunID = runID
+	if cfg.FeatureBranch != "" {
+		if err := cfg.ApplyFeatureBranchSuffix(time.Now()); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
 	}
 
-	// 2. Validate essential config fields
-	if cfg.GitlabURL == "" {
-		log.Fatal("config: gitlab_url is required")
+	// Show what the layered configuration resolved to before anything acts on it
+	if *printConfigFlag {
+		if err := config.WriteEffective(os.Stdout, cfg); err != nil {
+			return fmt.Errorf("Printing config failed: %w", err)
+		}
+		return nil
+	}
+
+	// Every retry of the run, whether of a clone, an API request or Ansible, draws from one budget
+	ctx := retry.NewContext(context.Background(), retry.NewBudget(cfg.TotalRetryBudget))
+
+	// 2. Validate essential config fields; local repositories never talk to GitLab
+	if cfg.GitlabURL == "" && *localDirFlag == "" {
+		return errors.New("config: gitlab_url is required")
 	}
 	if cfg.TargetBranch == "" {
-		log.Fatal("config: target_branch is required")
+		return errors.New("config: target_branch is required")
 	}
 	if cfg.FeatureBranch == "" {
-		log.Fatal("config: feature_branch is required")
+		return errors.New("config: feature_branch is required")
+	}
+	switch cfg.OnExisting {
+	case "", config.OnExistingError, config.OnExistingReuse, config.OnExistingClobber:
+	default:
+		return fmt.Errorf("-on-existing must be %q, %q or %q", config.OnExistingError, config.OnExistingReuse, config.OnExistingClobber)
+	}
+
+	// Workspace status is read-only and needs neither a token nor the network
+	if *statusFlag {
+		statuses, err := workspaceStatus(ctx, runner.New(), reposDir)
+		if err != nil {
+			return fmt.Errorf("Status failed: %w", err)
+		}
+		if err := printStatus(os.Stdout, statuses, cfg.FeatureBranch); err != nil {
+			return fmt.Errorf("Status failed: %w", err)
+		}
+		return nil
+	}
+
+	// Repositories already on disk need no token, discovery or clone
+	if *localDirFlag != "" {
+		if *runAnsibleFlag {
+			if err := checkAnsibleTools(cfg); err != nil {
+				return fmt.Errorf("Ansible is not usable: %w", err)
+			}
+		}
+		runStart := time.Now()
+		results, err := processLocalDir(ctx, runner.New(), cfg, *localDirFlag, *runAnsibleFlag)
+		if err != nil {
+			return fmt.Errorf("Processing %s failed: %w", *localDirFlag, err)
+		}
+		elapsed := time.Since(runStart)
+		logSummary(results, elapsed)
+		if err := writeReports(report.Report{RunID: runID, Results: results, ElapsedMS: elapsed.Milliseconds()}, *reportFlag, *junitFlag); err != nil {
+			return err
+		}
+		return nil
 	}
 
 	// 3. Get token from env
 	token := os.Getenv("GITLAB_TOKEN")
 	if token == "" {
-		log.Fatal("GITLAB_TOKEN environment variable is required")
+		return errors.New("GITLAB_TOKEN environment variable is required")
 	}
 
-	// 4. Initialize GitLab client
+	// 4. Initialize GitLab client and the runner used for git and ansible
 	client := gitlab.NewClient(cfg, token)
+	cmdRunner := withGitRateLimit(runner.New(), cfg.GitRateLimit)
+
+	// An unreachable GitLab would otherwise only surface deep into discovery
+	if !*skipConnectivityFlag {
+		if err := gitlab.CheckConnectivity(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	// Fail early when the token can't do what this run needs
+	deleteBranches := *pruneBranchesFlag && (*confirmFlag || *yesFlag) && !*dryRunFlag
+	if err := checkTokenScopes(ctx, client, requiredScopes(cfg, *discoverFlag, *runAnsibleFlag, deleteBranches)); err != nil {
+		return fmt.Errorf("Token check failed: %w", err)
+	}
+
+	// Webhook mode processes projects as their target branches are pushed to
+	if *serveFlag != "" {
+		secret := os.Getenv("GITLAB_WEBHOOK_SECRET")
+		if secret == "" {
+			return errors.New("GITLAB_WEBHOOK_SECRET environment variable is required with -serve")
+		}
+		if *runAnsibleFlag {
+			if err := checkAnsibleTools(cfg); err != nil {
+				return fmt.Errorf("Ansible is not usable: %w", err)
+			}
+		}
+		if err := os.MkdirAll(reposDir, 0o755); err != nil {
+			return fmt.Errorf("Failed to create directory %q: %w", reposDir, err)
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := serveWebhooks(ctx, cmdRunner, client, cfg, *serveFlag, secret, *runAnsibleFlag); err != nil {
+			return fmt.Errorf("Webhook server failed: %w", err)
+		}
+		return nil
+	}
 
 	// If in discovery mode, run discovery and exit
 	if *discoverFlag {
-		if cfg.AutoDiscover == nil || cfg.AutoDiscover.Group == "" {
-			log.Fatal("auto_discover.group must be specified in config for discovery mode")
+		if !cfg.HasDiscovery() {
+			return errors.New("auto_discover.group, auto_discover.groups or auto_discover.user must be specified in config for discovery mode")
 		}
-		ctx := context.Background()
-		if err := discoverAndExportProjects(ctx, client, cfg.AutoDiscover.Group, *outputFlag); err != nil {
-			log.Fatalf("Discovery failed: %v", err)
+		if *skipRolesFlag && *onlyRoleFlag != "" {
+			return errors.New("-only-role needs role detection and cannot be combined with -skip-roles")
 		}
-		return
+		if *skipRolesFlag && *exportGraphFlag != "" {
+			return errors.New("-export-graph needs dependency parsing and cannot be combined with -skip-roles")
+		}
+		if *groupByRoleFlag && *exportMatrixFlag {
+			return errors.New("-group-by-role and -export-matrix cannot be combined")
+		}
+		err := discoverAndExportProjects(ctx, cmdRunner, client, cfg, discoverOptions{
+			OutputPath: *outputFlag,
+			SplitDir:   *splitOutputFlag,
+			EmptyOK:    *emptyOKFlag,
+			OnlyRole:   *onlyRoleFlag,
+			SkipRoles:  *skipRolesFlag,
+
+			GroupByRole:   *groupByRoleFlag,
+			ExportMatrix:  *exportMatrixFlag,
+			WithMetadata:  *withMetadataFlag,
+			WithLanguages: *withLanguagesFlag,
+
+			InventoryCSV:  *inventoryCSVFlag,
+			InventoryDeps: *inventoryDepsFlag,
+			ExportGraph:   *exportGraphFlag,
+
+			DiffPath: *diffFlag,
+			DiffJSON: *diffJSONFlag,
+		})
+		if err != nil {
+			return fmt.Errorf("Discovery failed: %w", err)
+		}
+		if *apiMetricsFlag {
+			logAPIMetrics(client.Metrics())
+		}
+		return nil
 	}
 
 	// 5. Fetch auto-discovered projects (if configured)
-	ctx := context.Background()
+	if *preflightFlag && len(cfg.Projects) > 0 {
+		if err := preflightProjects(ctx, client, cfg.Projects); err != nil {
+			return fmt.Errorf("Preflight failed: %w", err)
+		}
+	}
+	// Pipelined discovery streams projects straight into processing below;
+	// pruning and clobbering need the complete list up front
+	pipelined := cfg.PipelineDiscovery && cfg.HasDiscovery() && !*pruneBranchesFlag
+	if pipelined && cfg.OnExisting == config.OnExistingClobber {
+		return fmt.Errorf("pipeline_discovery cannot be combined with on_existing %q", config.OnExistingClobber)
+	}
 	var autoProjects []config.RepoSpec
-	if cfg.AutoDiscover != nil && cfg.AutoDiscover.Group != "" {
-		log.Printf("🔍 Fetching auto-discovered projects from group: %s", cfg.AutoDiscover.Group)
-		autoProjects, err = gitlab.FetchGroupProjects(ctx, client, cfg.AutoDiscover.Group)
+	if cfg.HasDiscovery() && !pipelined {
+		source := cfg.DiscoverySource()
+		log.Printf("🔍 Fetching auto-discovered projects from %s", source)
+		autoProjects, err = fetchAutoDiscovered(ctx, client, cfg)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch projects from %s: %w", source, err)
+		}
+		if len(autoProjects) == 0 {
+			log.Printf("⚠️  Warning: %s has no active projects", source)
+		}
+	}
+
+	// 6. Merge manually specified projects + auto-discovered (+ stdin); configured entries win
+	allProjects := append(append([]config.RepoSpec(nil), cfg.Projects...), autoProjects...)
+	if *projectsStdinFlag {
+		stdinProjects, err := config.ParseProjectList(os.Stdin)
 		if err != nil {
-			log.Fatalf("Failed to fetch projects from group %s: %v", cfg.AutoDiscover.Group, err)
+			return fmt.Errorf("Failed to read projects from stdin: %w", err)
+		}
+		log.Printf("📋 Read %d projects from stdin", len(stdinProjects))
+		allProjects = append(allProjects, stdinProjects...)
+	}
+	if *projectsCSVFlag != "" {
+		f, err := os.Open(*projectsCSVFlag)
+		if err != nil {
+			return fmt.Errorf("Failed to open projects CSV: %w", err)
+		}
+		csvProjects, err := config.ParseProjectCSV(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to read projects from %s: %w", *projectsCSVFlag, err)
+		}
+		log.Printf("📋 Read %d projects from %s", len(csvProjects), *projectsCSVFlag)
+		allProjects = append(allProjects, csvProjects...)
+	}
+	allProjects = config.UniqueProjects(allProjects)
+	if len(allProjects) == 0 && !pipelined {
+		if *emptyOKFlag && cfg.HasDiscovery() {
+			log.Printf("⚠️  Warning: Nothing to process; auto-discovered %s is empty", cfg.DiscoverySource())
+			return nil
 		}
+		return errors.New("No projects to process (check config.projects, config.projects_file, config.auto_discover.group, -projects-stdin or -projects-csv)")
 	}
 
-	// 6. Merge manually specified projects + auto-discovered
-	allProjects := append(cfg.Projects, autoProjects...)
-	if len(allProjects) == 0 {
-		log.Fatal("No projects to process (check config.projects or config.auto_discover.group)")
+	// Branch pruning works entirely through the API, so nothing is cloned
+	if *pruneBranchesFlag {
+		if err := pruneBranches(ctx, client, cfg, allProjects, pruneOptions{Confirm: *confirmFlag || *yesFlag, DryRun: *dryRunFlag, Prompt: newConfirmer(*yesFlag)}); err != nil {
+			return fmt.Errorf("Pruning branches failed: %w", err)
+		}
+		return nil
+	}
+
+	if *runAnsibleFlag {
+		if err := checkAnsibleTools(cfg); err != nil {
+			return fmt.Errorf("Ansible is not usable: %w", err)
+		}
 	}
 
-	// 7. Create base "repos" directory once
-	reposDir := "repos"
+	// 7. Create base "repos" directory once, before any worker starts
 	if err := os.MkdirAll(reposDir, 0o755); err != nil {
-		log.Fatalf("Failed to create directory %q: %v", reposDir, err)
+		return fmt.Errorf("Failed to create directory %q: %w", reposDir, err)
 	}
 
-	// 8. Set up a per-clone timeout: e.g., 2 minutes per repo
-	for _, proj := range allProjects {
-		// Create a child context with timeout
-		cloneCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
-		err := cloneAndCreateBranch(cloneCtx, client, token, cfg.TargetBranch, cfg.FeatureBranch, proj.RepoPath, *runAnsibleFlag)
-		cancel()
+	// Clobbering throws away whatever is in the existing clones, so ask first
+	if cfg.OnExisting == config.OnExistingClobber {
+		if existing := existingClones(cfg, allProjects); len(existing) > 0 {
+			ok, err := newConfirmer(*yesFlag).confirm(fmt.Sprintf("Remove %d existing clones under %s (%s) and clone them afresh", len(existing), reposDir, strings.Join(existing, ", ")))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("Aborted: existing clones were left untouched")
+			}
+		}
+	}
 
-		if err != nil {
-			// Here we simply log and continue. You could accumulate errors if you want.
-			log.Printf("⚠️  Error processing %s: %v", proj.RepoPath, err)
+	// 8. Process projects, each with its own timeout, and report in input order
+	cp, err := openCheckpoint(*checkpointFlag, *resumeFlag)
+	if err != nil {
+		return fmt.Errorf("Failed to open checkpoint: %w", err)
+	}
+	runStart := time.Now()
+	var results []report.Result
+	var discoverErr error
+	if pipelined {
+		log.Printf("🔍 Processing auto-discovered projects from %s as they are listed", cfg.DiscoverySource())
+		results, discoverErr = processDiscovered(ctx, cmdRunner, client, cfg, allProjects, *runAnsibleFlag, cp)
+	} else {
+		results = processProjects(ctx, cmdRunner, client, cfg, allProjects, *runAnsibleFlag, cp)
+	}
+	elapsed := time.Since(runStart)
+	logSummary(results, elapsed)
+	if *apiMetricsFlag {
+		logAPIMetrics(client.Metrics())
+	}
+	rep := report.Report{RunID: runID, Results: results, ElapsedMS: elapsed.Milliseconds()}
+	if err := writeReports(rep, *reportFlag, *junitFlag); err != nil {
+		return err
+	}
+
+	if discoverErr != nil {
+		return fmt.Errorf("Failed to fetch projects from %s: %w", cfg.DiscoverySource(), discoverErr)
+	}
+	if pipelined && len(results) == 0 {
+		if !*emptyOKFlag {
+			return fmt.Errorf("No projects to process: auto-discovered %s is empty", cfg.DiscoverySource())
 		}
+		log.Printf("⚠️  Warning: Nothing was processed; auto-discovered %s is empty", cfg.DiscoverySource())
 	}
+
+	if cfg.MaxFailureRatio != nil {
+		return checkFailureRatio(results, *cfg.MaxFailureRatio)
+	}
+	return nil
 }
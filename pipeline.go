@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// streamAutoDiscovered lists the projects of the configured discovery groups,
+// one group after another, or of the user, calling fn for each as soon as its
+// page arrives, with group_defaults applied
+func streamAutoDiscovered(ctx context.Context, client *gitlab.Client, cfg *config.Config, fn func(config.RepoSpec) error) error {
+	filter := gitlab.NewProjectFilter(cfg)
+	emit := fn
+	fn = func(proj config.RepoSpec) error { return emit(cfg.ApplyGroupDefaults(proj)) }
+	if user := cfg.DiscoveryUser(); user != "" {
+		return gitlab.StreamUserProjects(ctx, client, user, filter, fn)
+	}
+	for _, group := range cfg.DiscoveryGroups() {
+		if err := gitlab.StreamGroupProjects(ctx, client, group, filter, fn); err != nil {
+			return fmt.Errorf("group %s: %w", group, err)
+		}
+	}
+	return nil
+}
+
+// processDiscovered processes projects followed by the auto-discovered
+// projects while discovery is still paging through them, so cloning starts
+// with the first page rather than after the last one. Repositories are
+// processed once even if listed more than once. The returned error is the
+// discovery failure, if any; the projects found until then are still
+// processed.
+func processDiscovered(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, projects []config.RepoSpec, runAnsible bool, cp *checkpoint) ([]report.Result, error) {
+	feed := make(chan config.RepoSpec)
+	done := make(chan struct{})
+	var discoverErr error
+
+	go func() {
+		defer close(done)
+		defer close(feed)
+
+		seen := make(map[string]bool)
+		send := func(proj config.RepoSpec) error {
+			if seen[proj.RepoPath] {
+				return nil
+			}
+			seen[proj.RepoPath] = true
+			select {
+			case feed <- proj:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for _, proj := range projects {
+			if err := send(proj); err != nil {
+				discoverErr = err
+				return
+			}
+		}
+		discoverErr = streamAutoDiscovered(ctx, client, cfg, send)
+	}()
+
+	results := processStream(ctx, r, client, cfg, feed, runAnsible, cp)
+	<-done
+	return results, discoverErr
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+func TestProcessDiscoveredStartsBeforeDiscoveryEnds(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// The first clone unblocks the second page of the group listing
+	cloned := make(chan struct{})
+	var once sync.Once
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && slices.Contains(c.Args, "clone") {
+			once.Do(func() { close(cloned) })
+		}
+		return nil, nil
+	}}
+
+	servedEarly := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"path_with_namespace": "team/app"}]`))
+			return
+		}
+		select {
+		case <-cloned:
+		case <-time.After(5 * time.Second):
+			servedEarly = true
+		}
+		w.Write([]byte(`[{"path_with_namespace": "team/lib"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.AutoDiscover = &config.AutoDiscover{Group: "team"}
+	cfg.PipelineDiscovery = true
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := processDiscovered(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, nil, false, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servedEarly {
+		t.Error("the last page was listed before any repository was cloned")
+	}
+	var paths []string
+	for _, res := range results {
+		if res.Status != report.StatusSuccess {
+			t.Errorf("%s is %s: %s", res.RepoPath, res.Status, res.Error)
+		}
+		paths = append(paths, res.RepoPath)
+	}
+	if want := []string{"team/app", "team/lib"}; !slices.Equal(paths, want) {
+		t.Errorf("processed %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// preflightProjects checks that every manually configured project exists and
+// is accessible before anything is cloned. All failures are reported together.
+// Auto-discovered projects come from the API and are assumed to exist.
+func preflightProjects(ctx context.Context, client *gitlab.Client, projects []config.RepoSpec) error {
+	log.Printf("🛫 Checking access to %d configured projects", len(projects))
+
+	var errs []error
+	for _, proj := range projects {
+		if err := gitlab.CheckProjectAccess(ctx, client, proj.RepoPath); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", proj.RepoPath, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d configured projects are not accessible:\n%w", len(errs), len(projects), errors.Join(errs...))
+	}
+
+	log.Printf("✅ All %d configured projects are accessible", len(projects))
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+func TestPreflightProjects(t *testing.T) {
+	status := map[string]int{
+		"/api/v4/projects/team%2Fapi":     http.StatusOK,
+		"/api/v4/projects/team%2Fweb":     http.StatusOK,
+		"/api/v4/projects/team%2Fgone":    http.StatusNotFound,
+		"/api/v4/projects/secret%2Fvault": http.StatusForbidden,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, ok := status[r.URL.EscapedPath()]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.EscapedPath())
+			code = http.StatusTeapot
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	client := gitlab.NewClient(cfg, "test-token")
+
+	ok := []config.RepoSpec{{RepoPath: "team/api"}, {RepoPath: "team/web"}}
+	if err := preflightProjects(context.Background(), client, ok); err != nil {
+		t.Fatalf("accessible projects should pass, got %v", err)
+	}
+
+	mixed := append(ok, config.RepoSpec{RepoPath: "team/gone"}, config.RepoSpec{RepoPath: "secret/vault"})
+	err := preflightProjects(context.Background(), client, mixed)
+	if err == nil {
+		t.Fatal("missing and forbidden projects should fail the preflight")
+	}
+	msg := err.Error()
+	for _, want := range []string{"2 of 4", "team/gone: project not found (404)", "secret/vault: access denied (403)"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q lacks %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "team/api") {
+		t.Errorf("error %q names an accessible project", msg)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+	"roller/tracing"
+)
+
+// processProjects clones and prepares every project using cfg.Concurrency
+// workers and returns one result per project, in the same order as projects.
+// Projects already completed according to cp are skipped, and every project
+// that succeeds is recorded in it.
+func processProjects(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, projects []config.RepoSpec, runAnsible bool, cp *checkpoint) []report.Result {
+	feed := make(chan config.RepoSpec)
+	go func() {
+		defer close(feed)
+		for _, proj := range projects {
+			select {
+			case feed <- proj:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := processStream(ctx, r, client, cfg, feed, runAnsible, cp)
+	for _, proj := range projects[len(results):] {
+		results = append(results, report.Result{RepoPath: proj.RepoPath, Status: report.StatusSkipped, Reason: "run interrupted"})
+	}
+	return results
+}
+
+// processStream is processProjects for projects arriving on a channel, for
+// example while discovery is still listing them. It returns once projects is
+// closed, or ctx is done, and all dispatched projects have finished, with one
+// result per project received, in the order they arrived.
+func processStream(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, projects <-chan config.RepoSpec, runAnsible bool, cp *checkpoint) []report.Result {
+	workers := cfg.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	collector := report.NewCollector(0)
+	claims := newDirClaims()
+	var batch *ansibleBatch
+	if cfg.BatchAnsible && runAnsible {
+		batch = newAnsibleBatch()
+	}
+	var gate *loadGate
+	if cfg.AdaptiveConcurrency && workers > 1 {
+		gate = newLoadGate(workers, loadThreshold(cfg.AdaptiveLoadThreshold), readLoadAvg)
+		gateCtx, stopGate := context.WithCancel(ctx)
+		defer stopGate()
+		go gate.run(gateCtx, loadCheckInterval)
+	}
+	type job struct {
+		index int
+		proj  config.RepoSpec
+	}
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := gate.acquire(ctx); err != nil {
+					collector.Set(j.index, report.Result{RepoPath: j.proj.RepoPath, Status: report.StatusSkipped, Reason: "run interrupted"})
+					continue
+				}
+				res := processProject(ctx, r, client, cfg, j.proj, runAnsible, batch)
+				gate.release()
+				// Batched repositories aren't done until their role's run has settled
+				if batch == nil {
+					recordCompleted(cp, checkpointBranch(cfg, j.proj), res)
+				}
+				collector.Set(j.index, res)
+			}
+		}()
+	}
+
+	// Dispatch projects in arrival order, spacing them out if configured
+	var received []config.RepoSpec
+	dispatched := 0
+	for proj := range projects {
+		i := len(received)
+		received = append(received, proj)
+		collector.Grow(len(received))
+		if cp.done(proj.RepoPath, checkpointBranch(cfg, proj)) {
+			log.Printf("⏭️  Skipping %s: completed in a previous run", proj.RepoPath)
+			collector.Set(i, report.Result{RepoPath: proj.RepoPath, Role: proj.RoleName, Status: report.StatusSkipped, Reason: "completed in a previous run"})
+			continue
+		}
+		if dispatched > 0 && cfg.CloneDelay > 0 {
+			if err := sleepContext(ctx, cfg.CloneDelay); err != nil {
+				log.Printf("⚠️  Interrupted while waiting between clones: %v", err)
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		// Claiming in dispatch order keeps the first of two colliding repositories the winner
+		if err := claims.claim(cloneDestDir(cfg, proj.RepoPath), proj.RepoPath); err != nil {
+			log.Printf("⚠️  Error processing %s: %v", proj.RepoPath, err)
+			collector.Set(i, report.Result{RepoPath: proj.RepoPath, Role: proj.RoleName, Status: report.StatusFailed, Error: err.Error()})
+			continue
+		}
+		jobs <- job{index: i, proj: proj}
+		dispatched++
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Anything never dispatched is recorded as skipped so the report stays complete
+	results := collector.Results()
+	if batch != nil {
+		batch.run(ctx, r, client, cfg, results)
+		for i, res := range results {
+			recordCompleted(cp, checkpointBranch(cfg, received[i]), res)
+		}
+	}
+	for i := range results {
+		if results[i].RepoPath == "" {
+			results[i] = report.Result{RepoPath: received[i].RepoPath, Status: report.StatusSkipped, Reason: "run interrupted"}
+		}
+	}
+	return results
+}
+
+// checkpointBranch returns the feature branch proj is checkpointed under: its
+// own, group or global branch without the suffix, which a date, timestamp or
+// run ID would change in the resumed run
+func checkpointBranch(cfg *config.Config, proj config.RepoSpec) string {
+	return repoConfig(cfg, proj).FeatureBranchBase()
+}
+
+// recordCompleted adds res to the checkpoint under featureBranch if its
+// repository succeeded
+func recordCompleted(cp *checkpoint, featureBranch string, res report.Result) {
+	if res.Status != report.StatusSuccess {
+		return
+	}
+	if err := cp.markDone(res.RepoPath, featureBranch); err != nil {
+		log.Printf("⚠️  Warning: Could not record %s in the checkpoint: %v", res.RepoPath, err)
+	}
+}
+
+// repoTimeout bounds the time spent on one repository, not counting a clone
+// running under clone_timeout
+const repoTimeout = 2 * time.Minute
+
+// processProject runs cloneAndCreateBranch for a single project with a
+// per-repo timeout and records its outcome. With clone_timeout or
+// ansible_timeout set, the clone and the Ansible run have their own budgets
+// on top of repoTimeout, so slow phases don't eat into the others' time.
+// Settings the project overrides are resolved first.
+func processProject(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, proj config.RepoSpec, runAnsible bool, batch *ansibleBatch) report.Result {
+	cfg = repoConfig(cfg, proj)
+	res := report.Result{RepoPath: proj.RepoPath, Role: proj.RoleName, Status: report.StatusSuccess, FeatureBranch: cfg.FeatureBranch}
+	start := time.Now()
+
+	// A configured role is known up front, so manual-only repositories aren't even cloned
+	if cfg.IsManualOnly(proj.RoleName) {
+		log.Printf("⏭️  Skipping %s: role %s is manual-only", proj.RepoPath, proj.RoleName)
+		res.Status = report.StatusSkipped
+		res.Reason = "manual-only role"
+		return res
+	}
+
+	ctx, span := tracing.Start(ctx, "repo", tracing.RepoKey.String(proj.RepoPath))
+	cloneCtx, cancel := context.WithTimeout(ctx, repoTimeout+cfg.CloneTimeout+cfg.AnsibleTimeout)
+	defer cancel()
+
+	err := cloneAndCreateBranch(cloneCtx, r, client, cfg, proj, runAnsible, batch, &res)
+	span.SetAttributes(tracing.RoleKey.String(res.Role))
+	tracing.End(span, err)
+	elapsed := time.Since(start)
+	res.DurationMS = elapsed.Milliseconds()
+	if err != nil {
+		log.Printf("⚠️  Error processing %s after %s: %v", proj.RepoPath, formatDuration(elapsed), err)
+		res.Status = report.StatusFailed
+		res.Error = err.Error()
+		res.ErrorClass = classifyError(err)
+		return res
+	}
+	log.Printf("✅ done %s in %s", proj.RepoPath, formatDuration(elapsed))
+	return res
+}
+
+// repoConfig returns cfg with the feature branch, clone and Ansible settings
+// that proj overrides applied. cfg itself is left untouched, since workers
+// share it.
+func repoConfig(cfg *config.Config, proj config.RepoSpec) *config.Config {
+	if proj.FeatureBranch == "" && proj.CloneTimeout == 0 && proj.CloneRetries == nil && proj.AnsibleTimeout == 0 {
+		return cfg
+	}
+	effective := *cfg
+	if proj.FeatureBranch != "" {
+		// Keep the suffix this run appended to the global feature branch
+		effective.FeatureBranch = proj.FeatureBranch + strings.TrimPrefix(cfg.FeatureBranch, cfg.FeatureBranchBase())
+		effective.BaseFeatureBranch = proj.FeatureBranch
+	}
+	if proj.CloneTimeout > 0 {
+		effective.CloneTimeout = proj.CloneTimeout
+	}
+	if proj.CloneRetries != nil {
+		effective.CloneRetries = *proj.CloneRetries
+	}
+	if proj.AnsibleTimeout > 0 {
+		effective.AnsibleTimeout = proj.AnsibleTimeout
+	}
+	return &effective
+}
+
+// formatDuration renders d with a tenth-of-a-second precision, e.g. "12.3s"
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// slowestReposShown is how many of the slowest repositories the summary lists
+const slowestReposShown = 3
+
+// logSummary prints one line per project in input order, followed by the
+// total elapsed time and the slowest repositories
+func logSummary(results []report.Result, elapsed time.Duration) {
+	log.Printf("📊 Summary (%d projects):", len(results))
+	for _, res := range results {
+		switch res.Status {
+		case report.StatusSuccess:
+			log.Printf("  ✅ %s", res.RepoPath)
+		case report.StatusSkipped:
+			log.Printf("  ⏭️  %s: %s", res.RepoPath, res.Reason)
+		default:
+			log.Printf("  ❌ %s: %s", res.RepoPath, res.Error)
+		}
+	}
+
+	log.Printf("⏱️  Total elapsed: %s", formatDuration(elapsed))
+	slowest := append([]report.Result(nil), results...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].DurationMS > slowest[j].DurationMS })
+	for i := 0; i < len(slowest) && i < slowestReposShown; i++ {
+		log.Printf("  🐢 %s: %s", slowest[i].RepoPath, formatDuration(time.Duration(slowest[i].DurationMS)*time.Millisecond))
+	}
+}
+
+// failureRatio returns the share of results that failed
+func failureRatio(results []report.Result) float64 {
+	if len(results) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, res := range results {
+		if res.Status == report.StatusFailed {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(results))
+}
+
+// checkFailureRatio fails the run only if the share of failed results
+// exceeds maxRatio, logging the ratio either way
+func checkFailureRatio(results []report.Result, maxRatio float64) error {
+	ratio := failureRatio(results)
+	if ratio > maxRatio {
+		return fmt.Errorf("❌ Failure ratio %.1f%% exceeds max_failure_ratio %.1f%%", ratio*100, maxRatio*100)
+	}
+	log.Printf("📉 Failure ratio %.1f%% is within max_failure_ratio %.1f%%", ratio*100, maxRatio*100)
+	return nil
+}
+
+// logAPIMetrics prints the request count, error count and mean latency of
+// every GitLab API endpoint used during the run
+func logAPIMetrics(m *gitlab.Metrics) {
+	stats := m.Snapshot()
+	endpoints := make([]string, 0, len(stats))
+	for name := range stats {
+		endpoints = append(endpoints, name)
+	}
+	sort.Strings(endpoints)
+
+	log.Printf("📈 GitLab API metrics (%d endpoints):", len(endpoints))
+	for _, name := range endpoints {
+		s := stats[name]
+		log.Printf("  %s: %d requests, %d errors, mean %s", name, s.Requests, s.Errors, s.MeanLatency().Round(time.Millisecond))
+	}
+}
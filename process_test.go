@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+func TestRepoConfigOverrides(t *testing.T) {
+	cfg := testConfig()
+	cfg.CloneTimeout = time.Minute
+	cfg.CloneRetries = 1
+	cfg.AnsibleTimeout = 5 * time.Minute
+
+	if got := repoConfig(cfg, config.RepoSpec{RepoPath: "team/app"}); got != cfg {
+		t.Error("a project without overrides should use the global config as is")
+	}
+
+	retries := 4
+	proj := config.RepoSpec{RepoPath: "team/huge", CloneTimeout: 10 * time.Minute, CloneRetries: &retries, AnsibleTimeout: time.Hour}
+	got := repoConfig(cfg, proj)
+	if got.CloneTimeout != 10*time.Minute || got.CloneRetries != 4 || got.AnsibleTimeout != time.Hour {
+		t.Errorf("effective clone_timeout %s, clone_retries %d, ansible_timeout %s; want 10m, 4 and 1h", got.CloneTimeout, got.CloneRetries, got.AnsibleTimeout)
+	}
+	if cfg.CloneTimeout != time.Minute || cfg.CloneRetries != 1 || cfg.AnsibleTimeout != 5*time.Minute {
+		t.Error("overrides leaked into the shared global config")
+	}
+}
+
+func TestProcessProjectUsesRepoCloneRetries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.CloneRetries = 3
+	none := 0
+	f := failingClone()
+
+	// Without its own clone_retries the clone would be retried three times
+	res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app", CloneRetries: &none}, false, nil)
+	if res.Status != report.StatusFailed {
+		t.Fatalf("result %s, want the clone to fail", res.Status)
+	}
+	if got := countCalls(f, "git", "clone"); got != 1 {
+		t.Errorf("clone attempted %d times, want 1 with clone_retries 0 for the project", got)
+	}
+}
+
+func TestProcessProjectsKeepsInputOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.Concurrency = 4
+	names := []string{"a", "b", "c", "d"}
+	var projects []config.RepoSpec
+	for _, name := range names {
+		projects = append(projects, config.RepoSpec{RepoPath: "team/" + name})
+	}
+
+	// Earlier projects clone more slowly, so workers finish in reverse order
+	var mu sync.Mutex
+	var finished []string
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			name := path.Base(c.Args[len(c.Args)-1])
+			delay := map[string]time.Duration{"a": 60, "b": 40, "c": 20, "d": 0}[name] * time.Millisecond
+			time.Sleep(delay)
+			mu.Lock()
+			finished = append(finished, name)
+			mu.Unlock()
+		}
+		return nil, nil
+	}}
+
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := processProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, projects, false, cp)
+	if reflect.DeepEqual(finished, names) {
+		t.Fatalf("clones finished in input order %v; the test needs them out of order", finished)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReports(report.Report{Results: results}, reportPath, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep report.Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range rep.Results {
+		got = append(got, res.RepoPath)
+	}
+	want := []string{"team/a", "team/b", "team/c", "team/d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report lists %v (clones finished %v), want input order %v", got, finished, want)
+	}
+}
+
+func TestCloneDelaySpacesOutClones(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.Concurrency = 3
+	cfg.CloneDelay = 30 * time.Millisecond
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var starts []time.Time
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			mu.Lock()
+			starts = append(starts, time.Now())
+			mu.Unlock()
+		}
+		return nil, nil
+	}}
+	projects := []config.RepoSpec{{RepoPath: "team/a"}, {RepoPath: "team/b"}, {RepoPath: "team/c"}}
+
+	processProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, projects, false, cp)
+	if len(starts) != len(projects) {
+		t.Fatalf("%d clones started, want %d", len(starts), len(projects))
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < cfg.CloneDelay {
+			t.Errorf("clone %d started %s after the previous one, want at least %s", i+1, gap, cfg.CloneDelay)
+		}
+	}
+}
+
+func TestCloneDelayHonorsCancellation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.CloneDelay = time.Hour
+	cp, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// Cancel once the first clone is under way, while the next one waits out the delay
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			cancel()
+		}
+		return nil, nil
+	}}
+
+	start := time.Now()
+	results := processProjects(ctx, f, gitlab.NewClient(cfg, "test-token"), cfg, []config.RepoSpec{{RepoPath: "team/a"}, {RepoPath: "team/b"}}, false, cp)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %s, the delay should have been cut short", elapsed)
+	}
+	if got := countCalls(f, "git", "clone"); got != 1 {
+		t.Errorf("%d clones started, want only the first", got)
+	}
+	if results[1].Status != report.StatusSkipped || results[1].Reason != "run interrupted" {
+		t.Errorf("second project %s (%s), want skipped as interrupted", results[1].Status, results[1].Reason)
+	}
+}
+
+func TestCreateBranchViaAPI(t *testing.T) {
+	t.Chdir(t.TempDir())
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/api/v4/projects/team%2Fapp/repository/branches" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"name": "roller-updates", "commit": {"id": "4b825dc6"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.CreateBranchViaAPI = true
+	f := &runner.Fake{}
+
+	res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil)
+	if res.Status != report.StatusSuccess {
+		t.Fatalf("result %s: %s", res.Status, res.Error)
+	}
+	if query.Get("branch") != "roller-updates" || query.Get("ref") != "main" {
+		t.Errorf("branch created with %v, want roller-updates from main", query)
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("nothing should be cloned, ran %v", calls)
+	}
+}
+
+// captureLog collects the standard logger's output for the rest of the test
+func captureLog(t *testing.T) *strings.Builder {
+	t.Helper()
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestProcessProjectRecordsDuration(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logs := captureLog(t)
+	cfg := testConfig()
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil, nil
+	}}
+
+	res := processProject(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil)
+	if res.DurationMS < 20 {
+		t.Errorf("duration %dms, want at least the 20ms the clone took", res.DurationMS)
+	}
+	data, err := json.Marshal(report.Report{Results: []report.Result{res}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep struct {
+		Results []map[string]any `json:"results"`
+	}
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if ms, ok := rep.Results[0]["duration_ms"].(float64); !ok || int64(ms) != res.DurationMS {
+		t.Errorf("JSON result %v has no duration_ms of %d", rep.Results[0], res.DurationMS)
+	}
+	if !strings.Contains(logs.String(), "✅ done team/app in ") {
+		t.Errorf("no completion log with the elapsed time in:\n%s", logs)
+	}
+
+	logSummary([]report.Result{{RepoPath: "team/fast", DurationMS: 5}, res}, time.Second)
+	if !strings.Contains(logs.String(), "🐢 team/app: ") {
+		t.Errorf("summary doesn't list the slowest repository:\n%s", logs)
+	}
+}
+
+func TestCheckFailureRatio(t *testing.T) {
+	logs := captureLog(t)
+	// One failure in four repositories, with a skip that counts as no failure
+	results := []report.Result{
+		{RepoPath: "team/a", Status: report.StatusSuccess},
+		{RepoPath: "team/b", Status: report.StatusFailed},
+		{RepoPath: "team/c", Status: report.StatusSkipped},
+		{RepoPath: "team/d", Status: report.StatusSuccess},
+	}
+	tests := []struct {
+		max     float64
+		wantErr bool
+	}{
+		{0, true},
+		{0.2, true},
+		{0.25, false},
+		{0.5, false},
+		{1, false},
+	}
+	for _, tt := range tests {
+		err := checkFailureRatio(results, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("max_failure_ratio %v: got %v, want error %v", tt.max, err, tt.wantErr)
+		}
+	}
+	if err := checkFailureRatio(results, 0.2); err == nil || !strings.Contains(err.Error(), "25.0% exceeds max_failure_ratio 20.0%") {
+		t.Errorf("error %v should give the ratio and the threshold", err)
+	}
+	if !strings.Contains(logs.String(), "Failure ratio 25.0% is within max_failure_ratio 50.0%") {
+		t.Errorf("the ratio within the threshold should be logged:\n%s", logs)
+	}
+	if err := checkFailureRatio(nil, 0); err != nil {
+		t.Errorf("an empty run should pass, got %v", err)
+	}
+}
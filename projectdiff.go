@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"roller/config"
+)
+
+// diffAgainst compares the discovered projects with those of a previous
+// export at oldPath, logs the changes and, if jsonPath is set, writes them
+// there as JSON. Without role detection only added and removed repositories
+// are reported.
+func diffAgainst(oldPath, jsonPath string, projects []config.RepoSpec, withRoles bool) error {
+	old, err := config.LoadExportedProjects(oldPath)
+	if err != nil {
+		return err
+	}
+	if !withRoles {
+		for i := range old {
+			old[i].RoleName = ""
+		}
+	}
+
+	d := config.DiffProjects(old, projects)
+	logProjectDiff(oldPath, d)
+	if jsonPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff: %w", err)
+	}
+	if err := os.WriteFile(jsonPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	log.Printf("📝 Wrote discovery diff to %s", jsonPath)
+	return nil
+}
+
+// logProjectDiff prints the added and removed repositories and role changes
+func logProjectDiff(oldPath string, d config.ProjectDiff) {
+	if d.Empty() {
+		log.Printf("🟰 No changes since %s", oldPath)
+		return
+	}
+	log.Printf("🔀 Changes since %s: %d added, %d removed, %d role changes", oldPath, len(d.Added), len(d.Removed), len(d.RoleChanges))
+	for _, p := range d.Added {
+		log.Printf("  + %s (%s)", p.RepoPath, displayRole(p.Role))
+	}
+	for _, p := range d.Removed {
+		log.Printf("  - %s (%s)", p.RepoPath, displayRole(p.Role))
+	}
+	for _, c := range d.RoleChanges {
+		log.Printf("  ~ %s: %s → %s", c.RepoPath, displayRole(c.OldRole), displayRole(c.NewRole))
+	}
+}
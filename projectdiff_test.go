@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"roller/config"
+)
+
+func TestDiffAgainst(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.yaml")
+	old := []config.RepoSpec{{RepoPath: "team/api", RoleName: "pom"}, {RepoPath: "team/web", RoleName: "node"}, {RepoPath: "team/legacy", RoleName: "pip"}}
+	if err := config.ExportDiscoveredProjects(oldPath, old, config.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	projects := []config.RepoSpec{{RepoPath: "team/api", RoleName: "pom"}, {RepoPath: "team/web", RoleName: "js-monorepo"}, {RepoPath: "team/cli"}}
+
+	jsonPath := filepath.Join(dir, "diff.json")
+	if err := diffAgainst(oldPath, jsonPath, projects, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1 added, 1 removed, 1 role changes", "+ team/cli (unknown)", "- team/legacy (pip)", "~ team/web: node → js-monorepo"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, logs)
+		}
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got config.ProjectDiff
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := config.ProjectDiff{
+		Added:       []config.DiffedProject{{RepoPath: "team/cli"}},
+		Removed:     []config.DiffedProject{{RepoPath: "team/legacy", Role: "pip"}},
+		RoleChanges: []config.RoleChange{{RepoPath: "team/web", OldRole: "node", NewRole: "js-monorepo"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON diff %+v, want %+v", got, want)
+	}
+
+	// Without role detection the new roles are all empty, so only membership is compared
+	logs.Reset()
+	unroled := []config.RepoSpec{{RepoPath: "team/api"}, {RepoPath: "team/web"}, {RepoPath: "team/legacy"}}
+	if err := diffAgainst(oldPath, "", unroled, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "No changes since") {
+		t.Errorf("expected no changes without roles:\n%s", logs)
+	}
+}
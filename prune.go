@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// pruneOptions controls what -prune-branches is allowed to do
+type pruneOptions struct {
+	Confirm bool       // Actually delete branches; without it the run only reports
+	DryRun  bool       // Report what would be deleted even if Confirm is set
+	Prompt  *confirmer // Asks whether to delete when Confirm is not set, if a terminal is available
+}
+
+// isFeatureBranch reports whether name follows the feature branch naming,
+// i.e. is feature_branch itself or starts with it followed by a separator.
+// Branches from earlier runs with a different feature_branch_suffix match too.
+func isFeatureBranch(cfg *config.Config, name string) bool {
+	base := cfg.FeatureBranchBase()
+	if name == base {
+		return true
+	}
+	rest, ok := strings.CutPrefix(name, base)
+	return ok && rest != "" && strings.ContainsRune("-_/.", rune(rest[0]))
+}
+
+// prunableBranches returns the feature branches of a project whose merge
+// requests are all merged or closed. Protected and default branches, branches
+// without any merge request and branches with an open one are kept.
+func prunableBranches(ctx context.Context, client *gitlab.Client, cfg *config.Config, repoPath string) ([]string, error) {
+	branches, err := gitlab.ListBranches(ctx, client, repoPath, cfg.FeatureBranchBase())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	var prunable []string
+	for _, b := range branches {
+		if b.Protected || b.Default || b.Name == cfg.TargetBranch || !isFeatureBranch(cfg, b.Name) {
+			continue
+		}
+		mrs, err := gitlab.ListMergeRequests(ctx, client, repoPath, b.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list merge requests for %s: %w", b.Name, err)
+		}
+		if len(mrs) > 0 && allFinished(mrs) {
+			prunable = append(prunable, b.Name)
+		}
+	}
+	return prunable, nil
+}
+
+// allFinished reports whether every merge request is merged or closed
+func allFinished(mrs []gitlab.MergeRequest) bool {
+	for _, mr := range mrs {
+		if mr.State != "merged" && mr.State != "closed" {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneBranches deletes the finished feature branches of every project, or
+// only lists them unless opts.Confirm is set and opts.DryRun is not. Without
+// opts.Confirm, an interactive run lists the branches first and asks whether
+// to delete them.
+func pruneBranches(ctx context.Context, client *gitlab.Client, cfg *config.Config, projects []config.RepoSpec, opts pruneOptions) error {
+	var errs []error
+	prunable := make([][]string, len(projects))
+	total := 0
+	for i, proj := range projects {
+		branches, err := prunableBranches(ctx, client, repoConfig(cfg, proj), proj.RepoPath)
+		if err != nil {
+			log.Printf("❌ %s: %v", proj.RepoPath, err)
+			errs = append(errs, fmt.Errorf("%s: %w", proj.RepoPath, err))
+			continue
+		}
+		prunable[i] = branches
+		total += len(branches)
+	}
+
+	deleteBranches := opts.Confirm && !opts.DryRun
+	if !deleteBranches {
+		for i, proj := range projects {
+			for _, branch := range prunable[i] {
+				log.Printf("🗑️  Would delete %s in %s", branch, proj.RepoPath)
+			}
+		}
+		if total > 0 && !opts.DryRun && opts.Prompt != nil && opts.Prompt.canPrompt() {
+			ok, err := opts.Prompt.confirm(fmt.Sprintf("Delete these %d branches", total))
+			if err != nil {
+				return err
+			}
+			deleteBranches = ok
+		}
+	}
+	if !deleteBranches {
+		log.Printf("🧪 Listed prunable branches only (pass -confirm without -dry-run to delete them)")
+		return errors.Join(errs...)
+	}
+
+	deleted := 0
+	for i, proj := range projects {
+		for _, branch := range prunable[i] {
+			if err := gitlab.DeleteBranch(ctx, client, proj.RepoPath, branch); err != nil {
+				log.Printf("❌ Failed to delete %s in %s: %v", branch, proj.RepoPath, err)
+				errs = append(errs, fmt.Errorf("%s: delete %s: %w", proj.RepoPath, branch, err))
+				continue
+			}
+			deleted++
+			log.Printf("🗑️  Deleted %s in %s", branch, proj.RepoPath)
+		}
+	}
+	log.Printf("✅ Deleted %d merged or closed feature branches", deleted)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// pruneServer is a fake GitLab holding the branches of team/app, the states of
+// their merge requests, and the branches deleted so far
+type pruneServer struct {
+	mu      sync.Mutex
+	deleted []string
+}
+
+// pruneBranchStates maps each branch of team/app to the states of its merge requests
+var pruneBranchStates = map[string][]string{
+	"main":                    {},
+	"roller-updates":          {"merged"},
+	"roller-updates-20260101": {"closed", "merged"},
+	"roller-updates-open":     {"merged", "opened"},
+	"roller-updates-nomr":     {},
+	"roller-updates-keep":     {"merged"}, // Protected
+	"roller-updatesx":         {"merged"}, // Doesn't follow the naming
+}
+
+func (s *pruneServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const branchesPath = "/api/v4/projects/team%2Fapp/repository/branches"
+	switch {
+	case r.Method == http.MethodGet && r.URL.EscapedPath() == branchesPath:
+		var branches []map[string]any
+		for name := range pruneBranchStates {
+			if strings.Contains(name, r.URL.Query().Get("search")) {
+				branches = append(branches, map[string]any{"name": name, "default": name == "main", "protected": name == "roller-updates-keep"})
+			}
+		}
+		json.NewEncoder(w).Encode(branches)
+	case r.Method == http.MethodGet && r.URL.EscapedPath() == "/api/v4/projects/team%2Fapp/merge_requests":
+		mrs := []map[string]any{}
+		for _, state := range pruneBranchStates[r.URL.Query().Get("source_branch")] {
+			mrs = append(mrs, map[string]any{"state": state})
+		}
+		json.NewEncoder(w).Encode(mrs)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.EscapedPath(), branchesPath+"/"):
+		branch, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), branchesPath+"/"))
+		s.mu.Lock()
+		s.deleted = append(s.deleted, branch)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPruneBranches(t *testing.T) {
+	tests := []struct {
+		name string
+		opts pruneOptions
+		want []string
+	}{
+		{"confirm", pruneOptions{Confirm: true}, []string{"roller-updates", "roller-updates-20260101"}},
+		{"dry run", pruneOptions{Confirm: true, DryRun: true}, nil},
+		{"no confirm", pruneOptions{}, nil},
+	}
+	for _, tt := range tests {
+		s := &pruneServer{}
+		srv := httptest.NewServer(s)
+		cfg := testConfig()
+		cfg.GitlabURL = srv.URL
+		projects := []config.RepoSpec{{RepoPath: "team/app"}}
+
+		err := pruneBranches(context.Background(), gitlab.NewClient(cfg, "test-token"), cfg, projects, tt.opts)
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		slices.Sort(s.deleted)
+		if !slices.Equal(s.deleted, tt.want) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, s.deleted, tt.want)
+		}
+	}
+}
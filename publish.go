@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// defaultMRTitle is used when mr_title is not configured
+const defaultMRTitle = "Automated update: {{.FeatureBranch}}"
+
+// mrTemplateData is available to the mr_title and mr_description templates
+type mrTemplateData struct {
+	RepoName      string
+	Role          string
+	FeatureBranch string
+	TargetBranch  string
+}
+
+// renderTemplate executes text (or fallback when text is empty) with data
+func renderTemplate(text, fallback string, data mrTemplateData) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New("mr").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// publishChanges commits whatever Ansible changed in destDir, pushes the
+// feature branch and opens a merge request against targetBranch.
+// Clones without changes are left alone. The commit and push are recorded in
+// res.
+func publishChanges(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, repoPath, destDir, role, targetBranch string, res *report.Result) error {
+	status, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"status", "--porcelain"}})
+	if err != nil {
+		return fmt.Errorf("git status failed in %s: %w", destDir, err)
+	}
+	if strings.TrimSpace(string(status)) == "" {
+		log.Printf("⏭️  No changes in %s, skipping merge request", repoPath)
+		return nil
+	}
+
+	data := mrTemplateData{
+		RepoName:      path.Base(repoPath),
+		Role:          role,
+		FeatureBranch: cfg.FeatureBranch,
+		TargetBranch:  targetBranch,
+	}
+	title, err := renderTemplate(cfg.MRTitle, defaultMRTitle, data)
+	if err != nil {
+		return fmt.Errorf("failed to render mr_title for %s: %w", repoPath, err)
+	}
+	description, err := renderTemplate(cfg.MRDescription, "", data)
+	if err != nil {
+		return fmt.Errorf("failed to render mr_description for %s: %w", repoPath, err)
+	}
+
+	log.Printf("📝 Committing changes in %s", destDir)
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"add", "-A"}}); err != nil {
+		return fmt.Errorf("git add failed in %s: %w", destDir, err)
+	}
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: commitArgs(cfg, title)}); err != nil {
+		if cfg.CommitSign && isSigningError(err) {
+			return fmt.Errorf("commit signing failed in %s (key %s): %w", destDir, cfg.CommitSigningKey, err)
+		}
+		return fmt.Errorf("git commit failed in %s: %w", destDir, err)
+	}
+	sha, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"rev-parse", "HEAD"}})
+	if err != nil {
+		return fmt.Errorf("git rev-parse failed in %s: %w", destDir, err)
+	}
+	res.CommitSHA = strings.TrimSpace(string(sha))
+
+	log.Printf("📤 Pushing %s for %s", cfg.FeatureBranch, repoPath)
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"push", "-u", "origin", cfg.FeatureBranch}}); err != nil {
+		return fmt.Errorf("git push failed for %s: %w", repoPath, err)
+	}
+	res.Pushed = true
+	res.RemoteRef = "refs/heads/" + cfg.FeatureBranch
+
+	mr, err := gitlab.CreateMergeRequest(ctx, client, repoPath, gitlab.MergeRequestOptions{
+		SourceBranch:       cfg.FeatureBranch,
+		TargetBranch:       targetBranch,
+		Title:              title,
+		Description:        description,
+		Labels:             cfg.MRLabels,
+		AssigneeIDs:        cfg.MRAssigneeIDs,
+		RemoveSourceBranch: cfg.MRRemoveSourceBranch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create merge request for %s: %w", repoPath, err)
+	}
+
+	log.Printf("🔀 Opened merge request !%d for %s: %s", mr.IID, repoPath, mr.WebURL)
+
+	if cfg.MRAutoMerge {
+		autoMerge(ctx, client, repoPath, mr.IID)
+	}
+	return nil
+}
+
+// checkPushAccess makes sure the feature branch can be pushed from destDir
+// with the git credentials at hand, so a missing or read-only credential
+// fails the repository before Ansible runs rather than after. The dry run
+// authenticates against the remote without updating it.
+func checkPushAccess(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath, destDir string) error {
+	cmd := runner.Cmd{Dir: destDir, Name: "git", Args: []string{"push", "--dry-run", "origin", "HEAD:refs/heads/" + cfg.FeatureBranch}}
+	if err := r.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("cannot push %s to %s, so no merge request could be opened: %w", cfg.FeatureBranch, repoPath, err)
+	}
+	return nil
+}
+
+// autoMerge sets the merge request to merge once its pipeline succeeds. The
+// merge request is already open, so failures are only logged.
+func autoMerge(ctx context.Context, client *gitlab.Client, repoPath string, iid int) {
+	mr, err := gitlab.SetAutoMerge(ctx, client, repoPath, iid)
+	var apiErr *gitlab.APIError
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusMethodNotAllowed || apiErr.StatusCode == http.StatusNotAcceptable):
+		log.Printf("⚠️  Warning: Merge request !%d for %s can't auto-merge (no pipeline, or not mergeable yet); left open: %s", iid, repoPath, apiErr.Body)
+	case err != nil:
+		log.Printf("⚠️  Warning: Failed to enable auto-merge for merge request !%d in %s: %v", iid, repoPath, err)
+	case mr.State == "merged":
+		log.Printf("✅ Merge request !%d for %s was merged right away", iid, repoPath)
+	default:
+		log.Printf("⏳ Merge request !%d for %s will merge when its pipeline succeeds (state: %s, auto-merge: %t)", iid, repoPath, mr.State, mr.MergeWhenPipelineSucceeds)
+	}
+}
+
+// commitArgs returns the git arguments for the automated commit, adding the
+// signing options when commit_sign is enabled
+func commitArgs(cfg *config.Config, message string) []string {
+	if !cfg.CommitSign {
+		return []string{"commit", "-m", message}
+	}
+	if cfg.CommitSigningFormat == config.SigningFormatSSH {
+		return []string{"-c", "gpg.format=ssh", "-c", "user.signingkey=" + cfg.CommitSigningKey, "commit", "-S", "-m", message}
+	}
+	return []string{"commit", "-S" + cfg.CommitSigningKey, "-m", message}
+}
+
+// isSigningError reports whether a failed git commit failed while signing
+func isSigningError(err error) bool {
+	stderr := runner.Stderr(err)
+	return strings.Contains(stderr, "failed to sign") || strings.Contains(stderr, "signing failed")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// changedClone reports uncommitted changes and fails git commit with
+// commitStderr, if set
+func changedClone(commitStderr string) *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name != "git" {
+			return nil, nil
+		}
+		switch {
+		case c.Args[0] == "status":
+			return []byte(" M pom.xml\n"), nil
+		case commitStderr != "" && (c.Args[0] == "commit" || slices.Contains(c.Args, "commit")):
+			return nil, &runner.Error{Cmd: c, Stderr: commitStderr, Err: errors.New("exit status 128")}
+		}
+		return nil, nil
+	}}
+}
+
+func TestCommitArgsSigning(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{"unsigned", config.Config{}, []string{"commit", "-m", "msg"}},
+		{"gpg", config.Config{CommitSign: true, CommitSigningKey: "ABCD1234"}, []string{"commit", "-SABCD1234", "-m", "msg"}},
+		{"ssh", config.Config{CommitSign: true, CommitSigningKey: "~/.ssh/id_ed25519.pub", CommitSigningFormat: config.SigningFormatSSH},
+			[]string{"-c", "gpg.format=ssh", "-c", "user.signingkey=~/.ssh/id_ed25519.pub", "commit", "-S", "-m", "msg"}},
+	}
+	for _, tt := range tests {
+		if got := commitArgs(&tt.cfg, "msg"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: git %v, want git %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPublishChangesReportsSigningFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.CommitSign = true
+	cfg.CommitSigningKey = "ABCD1234"
+	f := changedClone("error: gpg failed to sign the data\nfatal: failed to write commit object")
+
+	var res report.Result
+	err := publishChanges(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, "team/app", t.TempDir(), "java", "main", &res)
+	if err == nil || !strings.Contains(err.Error(), "commit signing failed") || !strings.Contains(err.Error(), "ABCD1234") {
+		t.Fatalf("expected a signing error naming the key, got %v", err)
+	}
+	if got := countCalls(f, "git", "push"); got != 0 {
+		t.Errorf("pushed %d times after the failed commit", got)
+	}
+}
+
+// mrServer is a fake GitLab that opens merge requests in team/app and, with
+// autoMergeStatus, answers requests to auto-merge them
+type mrServer struct {
+	autoMergeStatus int
+
+	mu         sync.Mutex
+	created    []gitlab.MergeRequestOptions
+	autoMerges int
+}
+
+func (s *mrServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const mrsPath = "/api/v4/projects/team%2Fapp/merge_requests"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.EscapedPath() == mrsPath:
+		var mr struct {
+			SourceBranch string `json:"source_branch"`
+			TargetBranch string `json:"target_branch"`
+			Title        string `json:"title"`
+			Description  string `json:"description"`
+		}
+		json.NewDecoder(r.Body).Decode(&mr)
+		s.created = append(s.created, gitlab.MergeRequestOptions{SourceBranch: mr.SourceBranch, TargetBranch: mr.TargetBranch, Title: mr.Title, Description: mr.Description})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"iid": 1, "state": "opened", "web_url": "https://gitlab.example.com/team/app/-/merge_requests/1"}`))
+	case r.Method == http.MethodPut && r.URL.EscapedPath() == mrsPath+"/1/merge" && r.URL.Query().Get("merge_when_pipeline_succeeds") == "true":
+		s.autoMerges++
+		w.WriteHeader(s.autoMergeStatus)
+		w.Write([]byte(`{"iid": 1, "state": "opened", "merge_when_pipeline_succeeds": true}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// publishConfig returns the test config pointing at a fake merge request server
+func publishConfig(t *testing.T, s *mrServer) *config.Config {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.CreateMergeRequest = true
+	return cfg
+}
+
+func TestPublishChangesRendersMRTemplates(t *testing.T) {
+	s := &mrServer{}
+	cfg := publishConfig(t, s)
+	cfg.MRTitle = "chore({{.Role}}): update {{.RepoName}} on {{.TargetBranch}}"
+	cfg.MRDescription = "Automated changes from {{.FeatureBranch}}."
+
+	var res report.Result
+	if err := publishChanges(context.Background(), changedClone(""), gitlab.NewClient(cfg, "test-token"), cfg, "team/app", t.TempDir(), "java", "main", &res); err != nil {
+		t.Fatal(err)
+	}
+	want := gitlab.MergeRequestOptions{SourceBranch: "roller-updates", TargetBranch: "main", Title: "chore(java): update app on main", Description: "Automated changes from roller-updates."}
+	if len(s.created) != 1 || !reflect.DeepEqual(s.created[0], want) {
+		t.Errorf("opened merge requests %+v, want %+v", s.created, want)
+	}
+}
+
+func TestPublishChangesAutoMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoMerge  bool
+		status     int
+		wantCalls  int
+		wantLogged string
+	}{
+		{"off", false, http.StatusOK, 0, "Opened merge request !1"},
+		{"pipeline", true, http.StatusOK, 1, "will merge when its pipeline succeeds (state: opened, auto-merge: true)"},
+		{"no pipeline", true, http.StatusMethodNotAllowed, 1, "can't auto-merge (no pipeline, or not mergeable yet); left open"},
+	}
+	for _, tt := range tests {
+		logs := captureLog(t)
+		s := &mrServer{autoMergeStatus: tt.status}
+		cfg := publishConfig(t, s)
+		cfg.MRAutoMerge = tt.autoMerge
+
+		var res report.Result
+		// The merge request is open either way, so an auto-merge refusal doesn't fail the repository
+		if err := publishChanges(context.Background(), changedClone(""), gitlab.NewClient(cfg, "test-token"), cfg, "team/app", t.TempDir(), "java", "main", &res); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if s.autoMerges != tt.wantCalls {
+			t.Errorf("%s: %d auto-merge requests, want %d", tt.name, s.autoMerges, tt.wantCalls)
+		}
+		if !strings.Contains(logs.String(), tt.wantLogged) {
+			t.Errorf("%s: log lacks %q:\n%s", tt.name, tt.wantLogged, logs)
+		}
+	}
+}
+
+func TestPublishChangesRecordsCommit(t *testing.T) {
+	const sha = "3f786850e387550fdab836ed7e6dc881de23001b"
+	for _, pushFails := range []bool{false, true} {
+		cfg := publishConfig(t, &mrServer{})
+		f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+			switch c.Args[0] {
+			case "status":
+				return []byte(" M pom.xml\n"), nil
+			case "rev-parse":
+				return []byte(sha + "\n"), nil
+			case "push":
+				if pushFails {
+					return nil, &runner.Error{Cmd: c, Stderr: "remote: You are not allowed to push code to this project.", Err: errors.New("exit status 128")}
+				}
+			}
+			return nil, nil
+		}}
+
+		var res report.Result
+		err := publishChanges(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, "team/app", t.TempDir(), "java", "main", &res)
+		if (err != nil) != pushFails {
+			t.Errorf("push fails %t: got error %v", pushFails, err)
+		}
+		if res.CommitSHA != sha {
+			t.Errorf("push fails %t: commit %q, want %s", pushFails, res.CommitSHA, sha)
+		}
+		wantRef := "refs/heads/roller-updates"
+		if pushFails {
+			wantRef = ""
+		}
+		if res.Pushed == pushFails || res.RemoteRef != wantRef {
+			t.Errorf("push fails %t: got pushed %t to %q, want pushed %t to %q", pushFails, res.Pushed, res.RemoteRef, !pushFails, wantRef)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package ratelimit paces operations shared by concurrent workers.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled at a fixed rate and holding at most one
+// token, so operations are spaced evenly rather than released in bursts. It
+// is safe for concurrent use; a nil Bucket never waits.
+type Bucket struct {
+	mu       sync.Mutex
+	interval time.Duration // Time between two tokens
+	next     time.Time     // When the next token becomes available
+}
+
+// NewBucket returns a bucket allowing perSecond operations per second, or nil
+// (unlimited) when perSecond is zero or negative
+func NewBucket(perSecond float64) *Bucket {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Bucket{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *Bucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	// Reserve the next slot, then sleep until it comes up outside the lock
+	b.mu.Lock()
+	now := time.Now()
+	at := b.next
+	if at.Before(now) {
+		at = now
+	}
+	b.next = at.Add(b.interval)
+	b.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
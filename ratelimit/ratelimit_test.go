@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBucketPacesConcurrentWaiters(t *testing.T) {
+	b := NewBucket(20) // One token every 50ms
+	start := time.Now()
+	var mu sync.Mutex
+	var at []time.Duration
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			at = append(at, time.Since(start))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// The first goes right away and the others follow 50ms apart
+	var last time.Duration
+	for _, d := range at {
+		last = max(last, d)
+	}
+	if last < 140*time.Millisecond {
+		t.Errorf("4 operations at 20/s took %v, want at least 150ms", last)
+	}
+}
+
+func TestBucketWaitHonorsCancellation(t *testing.T) {
+	b := NewBucket(0.5) // One token every 2s
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's deadline error", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("cancelled wait returned after %v", waited)
+	}
+}
+
+func TestNilBucketNeverWaits(t *testing.T) {
+	b := NewBucket(0)
+	if b != nil {
+		t.Fatalf("a zero rate should give no bucket, got %+v", b)
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
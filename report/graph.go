@@ -0,0 +1,107 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GraphEdge records that a repository declares a dependency on a package
+type GraphEdge struct {
+	RepoPath string `json:"repo_path"`
+	Package  string `json:"package"`
+	Version  string `json:"version,omitempty"`
+}
+
+// DependencyGraph links repositories to the shared packages they depend on
+type DependencyGraph struct {
+	Packages []string    `json:"packages"` // Packages depended on, sorted
+	Edges    []GraphEdge `json:"edges"`    // Sorted by package, then repository
+}
+
+// BuildDependencyGraph collects the dependencies of entries whose names start
+// with one of prefixes, i.e. the internal packages shared between
+// repositories. Without prefixes every dependency is included.
+func BuildDependencyGraph(entries []InventoryEntry, prefixes []string) DependencyGraph {
+	g := DependencyGraph{Packages: []string{}, Edges: []GraphEdge{}}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for name, version := range e.Dependencies {
+			if !hasAnyPrefix(name, prefixes) {
+				continue
+			}
+			g.Edges = append(g.Edges, GraphEdge{RepoPath: e.RepoPath, Package: name, Version: version})
+			if !seen[name] {
+				seen[name] = true
+				g.Packages = append(g.Packages, name)
+			}
+		}
+	}
+	sort.Strings(g.Packages)
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Package != g.Edges[j].Package {
+			return g.Edges[i].Package < g.Edges[j].Package
+		}
+		return g.Edges[i].RepoPath < g.Edges[j].RepoPath
+	})
+	return g
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes, or prefixes is
+// empty
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteGraph writes g to path, as Graphviz DOT when path ends in ".dot" or
+// ".gv" and as indented JSON otherwise
+func WriteGraph(path string, g DependencyGraph) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		data = []byte(graphDOT(g))
+	default:
+		var err error
+		data, err = json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal dependency graph: %w", err)
+		}
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write dependency graph: %w", err)
+	}
+	return nil
+}
+
+// graphDOT renders g as a directed graph from repositories to packages, with
+// packages drawn as boxes and edges labelled with the declared version
+func graphDOT(g DependencyGraph) string {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, p := range g.Packages {
+		fmt.Fprintf(&b, "  %s [shape=box];\n", strconv.Quote(p))
+	}
+	for _, e := range g.Edges {
+		if e.Version != "" {
+			fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", strconv.Quote(e.RepoPath), strconv.Quote(e.Package), strconv.Quote(e.Version))
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(e.RepoPath), strconv.Quote(e.Package))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	entries := []InventoryEntry{
+		{RepoPath: "team/api", Dependencies: map[string]string{"com.acme:auth-client": "2.1.0", "com.acme:logging": "1.4.0", "org.slf4j:slf4j-api": "2.0.13"}},
+		{RepoPath: "team/billing", Dependencies: map[string]string{"com.acme:auth-client": "2.0.3", "junit:junit": "4.13.2"}},
+		{RepoPath: "team/web", Dependencies: map[string]string{"@acme/ui-kit": "^3.2.0", "react": "^18.3.1"}},
+		{RepoPath: "team/docs"},
+	}
+	g := BuildDependencyGraph(entries, []string{"com.acme:", "@acme/"})
+	want := DependencyGraph{
+		Packages: []string{"@acme/ui-kit", "com.acme:auth-client", "com.acme:logging"},
+		Edges: []GraphEdge{
+			{RepoPath: "team/web", Package: "@acme/ui-kit", Version: "^3.2.0"},
+			{RepoPath: "team/api", Package: "com.acme:auth-client", Version: "2.1.0"},
+			{RepoPath: "team/billing", Package: "com.acme:auth-client", Version: "2.0.3"},
+			{RepoPath: "team/api", Package: "com.acme:logging", Version: "1.4.0"},
+		},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Fatalf("got %+v, want %+v", g, want)
+	}
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "graph.json")
+	if err := WriteGraph(jsonPath, g); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DependencyGraph
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, want) {
+		t.Errorf("JSON graph decodes to %+v, %v, want %+v", decoded, err, want)
+	}
+
+	dotPath := filepath.Join(dir, "graph.dot")
+	if err := WriteGraph(dotPath, g); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(dotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDOT := `digraph dependencies {
+  rankdir=LR;
+  "@acme/ui-kit" [shape=box];
+  "com.acme:auth-client" [shape=box];
+  "com.acme:logging" [shape=box];
+  "team/web" -> "@acme/ui-kit" [label="^3.2.0"];
+  "team/api" -> "com.acme:auth-client" [label="2.1.0"];
+  "team/billing" -> "com.acme:auth-client" [label="2.0.3"];
+  "team/api" -> "com.acme:logging" [label="1.4.0"];
+}
+`
+	if string(data) != wantDOT {
+		t.Errorf("got DOT\n%s\nwant\n%s", data, wantDOT)
+	}
+}
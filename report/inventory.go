@@ -0,0 +1,63 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// InventoryEntry describes a discovered repository and what it depends on
+type InventoryEntry struct {
+	RepoPath       string
+	Role           string
+	DependencyFile string            // Path of the dependency file relative to the repository root
+	Dependencies   map[string]string // Dependency name → declared version
+}
+
+// inventoryHeader lists the CSV columns; the dependency columns are only
+// filled in when writing one row per dependency
+var inventoryHeader = []string{"repo_path", "role", "dependency_file", "dependency", "version"}
+
+// WriteInventoryCSV writes entries to path as CSV with a header row. By
+// default there is one row per repository; with perDependency there is one
+// row per declared dependency (repositories without dependencies still get
+// a single row).
+func WriteInventoryCSV(path string, entries []InventoryEntry, perDependency bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create inventory file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(inventoryHeader); err != nil {
+		return fmt.Errorf("failed to write inventory: %w", err)
+	}
+
+	for _, e := range entries {
+		if !perDependency || len(e.Dependencies) == 0 {
+			if err := w.Write([]string{e.RepoPath, e.Role, e.DependencyFile, "", ""}); err != nil {
+				return fmt.Errorf("failed to write inventory: %w", err)
+			}
+			continue
+		}
+
+		names := make([]string, 0, len(e.Dependencies))
+		for name := range e.Dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := w.Write([]string{e.RepoPath, e.Role, e.DependencyFile, name, e.Dependencies[name]}); err != nil {
+				return fmt.Errorf("failed to write inventory: %w", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write inventory: %w", err)
+	}
+	return f.Close()
+}
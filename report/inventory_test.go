@@ -0,0 +1,45 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteInventoryCSV(t *testing.T) {
+	entries := []InventoryEntry{
+		{RepoPath: "team/app", Role: "pom", DependencyFile: "pom.xml", Dependencies: map[string]string{"org.slf4j:slf4j-api": "2.0.13", "junit:junit": "4.13.2"}},
+		{RepoPath: "team/tool", Role: "pip", DependencyFile: "requirements.txt"},
+		{RepoPath: "team/docs"},
+	}
+	tests := []struct {
+		name          string
+		perDependency bool
+		want          string
+	}{
+		{"per repository", false, `repo_path,role,dependency_file,dependency,version
+team/app,pom,pom.xml,,
+team/tool,pip,requirements.txt,,
+team/docs,,,,
+`},
+		{"per dependency", true, `repo_path,role,dependency_file,dependency,version
+team/app,pom,pom.xml,junit:junit,4.13.2
+team/app,pom,pom.xml,org.slf4j:slf4j-api,2.0.13
+team/tool,pip,requirements.txt,,
+team/docs,,,,
+`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "inventory.csv")
+		if err := WriteInventoryCSV(path, entries, tt.perDependency); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: CSV\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// junitSuiteName names the single test suite of a JUnit report
+const junitSuiteName = "roller"
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report to path as JUnit XML, with one test case per
+// repository: failed repositories carry their error as the failure message
+// and skipped ones their reason
+func WriteJUnit(path string, rep Report) error {
+	suite := junitTestSuite{
+		Name:  junitSuiteName,
+		Tests: len(rep.Results),
+		Time:  junitSeconds(rep.ElapsedMS),
+	}
+	for _, res := range rep.Results {
+		tc := junitTestCase{
+			Name:      res.RepoPath,
+			ClassName: junitSuiteName,
+			Time:      junitSeconds(res.DurationMS),
+		}
+		if res.Role != "" {
+			tc.ClassName = junitSuiteName + "." + res.Role
+		}
+		switch res.Status {
+		case StatusFailed:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: res.Error}
+		case StatusSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: res.Reason}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JUnit report: %w", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	return nil
+}
+
+// junitSeconds formats milliseconds as the seconds JUnit expects
+func junitSeconds(ms int64) string {
+	return strconv.FormatFloat((time.Duration(ms) * time.Millisecond).Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,53 @@
+package report
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	rep := Report{
+		ElapsedMS: 12500,
+		Results: []Result{
+			{RepoPath: "team/app", Role: "pom", Status: StatusSuccess, DurationMS: 4200},
+			{RepoPath: "team/web", Role: "node", Status: StatusFailed, Error: `git push failed: exit status 128 & "denied"`, DurationMS: 1500},
+			{RepoPath: "team/docs", Status: StatusSkipped, Reason: "manual-only role"},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	if err := WriteJUnit(path, rep); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("report lacks the XML header:\n%s", data)
+	}
+
+	var got junitTestSuite
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, data)
+	}
+	want := junitTestSuite{
+		XMLName:  xml.Name{Local: "testsuite"},
+		Name:     "roller",
+		Tests:    3,
+		Failures: 1,
+		Skipped:  1,
+		Time:     "12.500",
+		Cases: []junitTestCase{
+			{Name: "team/app", ClassName: "roller.pom", Time: "4.200"},
+			{Name: "team/web", ClassName: "roller.node", Time: "1.500", Failure: &junitMessage{Message: `git push failed: exit status 128 & "denied"`}},
+			{Name: "team/docs", ClassName: "roller", Time: "0.000", Skipped: &junitMessage{Message: "manual-only role"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
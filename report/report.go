@@ -0,0 +1,101 @@
+// Package report collects per-repository outcomes of a run and writes them
+// out once the run has finished.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Status values recorded for a repository
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Error classes recorded for a failed repository
+const (
+	ErrorClassTransient = "transient"  // Timeouts, dropped connections, 5xx and 429 responses; worth retrying
+	ErrorClassRateLimit = "rate-limit" // git over HTTPS was rate-limited by GitLab; worth retrying after a pause
+	ErrorClassAuth      = "auth"       // Rejected credentials or missing permissions (401/403)
+	ErrorClassNotFound  = "not-found"  // Missing repository or branch (404)
+	ErrorClassOther     = "other"
+)
+
+// Result is the outcome of processing a single repository
+type Result struct {
+	RepoPath string `json:"repo_path"`
+	Role     string `json:"role,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Reason   string `json:"reason,omitempty"` // Why the repository was skipped
+
+	FeatureBranch string `json:"feature_branch,omitempty"` // Branch the changes were made on, with any group or project override applied
+
+	ErrorClass string `json:"error_class,omitempty"` // Category of Error: "transient", "rate-limit", "auth", "not-found" or "other"
+
+	CommitSHA string `json:"commit_sha,omitempty"` // Commit holding the Ansible changes on the feature branch
+	Pushed    bool   `json:"pushed,omitempty"`     // Whether the feature branch was pushed
+	RemoteRef string `json:"remote_ref,omitempty"` // Ref the feature branch was pushed to
+
+	DurationMS int64 `json:"duration_ms"` // Wall-clock time spent on the repository
+}
+
+// Report is the document written at the end of a run
+type Report struct {
+	RunID     string   `json:"run_id,omitempty"`
+	Results   []Result `json:"results"`
+	ElapsedMS int64    `json:"elapsed_ms"` // Wall-clock time of the whole run
+}
+
+// Collector gathers results from concurrent workers. Each result is stored at
+// the index of its project in the input list, so Results always returns them
+// in input order regardless of the order in which workers finish.
+type Collector struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// NewCollector returns a collector for n projects
+func NewCollector(n int) *Collector {
+	return &Collector{results: make([]Result, n)}
+}
+
+// Grow makes room for n projects, for input lists whose length isn't known
+// up front
+func (c *Collector) Grow(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > len(c.results) {
+		c.results = append(c.results, make([]Result, n-len(c.results))...)
+	}
+}
+
+// Set records the result for the project at index i
+func (c *Collector) Set(i int, r Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[i] = r
+}
+
+// Results returns a copy of all results in input order
+func (c *Collector) Results() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Result(nil), c.results...)
+}
+
+// WriteJSON writes the report to path as indented JSON
+func WriteJSON(path string, rep Report) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return nil
+}
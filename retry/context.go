@@ -0,0 +1,18 @@
+package retry
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying b, so that every retrying
+// operation run under it draws from the same budget
+func NewContext(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// FromContext returns the budget carried by ctx, or nil (unlimited) if it
+// carries none
+func FromContext(ctx context.Context) *Budget {
+	b, _ := ctx.Value(contextKey{}).(*Budget)
+	return b
+}
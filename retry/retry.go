@@ -0,0 +1,48 @@
+// Package retry implements the retry budget shared by every retrying
+// operation of a run.
+package retry
+
+import "sync/atomic"
+
+// Budget caps the total number of retries across a whole run, so that an
+// outage can't turn every repository's retries into a storm of requests. A nil
+// Budget is unlimited.
+type Budget struct {
+	remaining atomic.Int64
+}
+
+// NewBudget returns a budget allowing n retries in total, or nil (unlimited)
+// when n is zero or negative
+func NewBudget(n int) *Budget {
+	if n <= 0 {
+		return nil
+	}
+	b := &Budget{}
+	b.remaining.Store(int64(n))
+	return b
+}
+
+// Take consumes one retry and reports whether it may be attempted. Once the
+// budget is exhausted every call returns false for the rest of the run.
+func (b *Budget) Take() bool {
+	if b == nil {
+		return true
+	}
+	for {
+		n := b.remaining.Load()
+		if n <= 0 {
+			return false
+		}
+		if b.remaining.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// Remaining returns the number of retries left, or -1 for an unlimited budget
+func (b *Budget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	return int(b.remaining.Load())
+}
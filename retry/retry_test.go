@@ -0,0 +1,43 @@
+package retry
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBudgetExhausted(t *testing.T) {
+	b := NewBudget(2)
+	if !b.Take() || !b.Take() {
+		t.Fatal("a budget of 2 should allow two retries")
+	}
+	for i := 0; i < 3; i++ {
+		if b.Take() {
+			t.Fatal("an exhausted budget allowed another retry")
+		}
+	}
+	if got := b.Remaining(); got != 0 {
+		t.Errorf("Remaining() = %d, want 0", got)
+	}
+}
+
+func TestBudgetUnlimited(t *testing.T) {
+	b := NewBudget(0)
+	for i := 0; i < 100; i++ {
+		if !b.Take() {
+			t.Fatal("a zero total_retry_budget should not cap retries")
+		}
+	}
+	if got := b.Remaining(); got != -1 {
+		t.Errorf("Remaining() = %d, want -1", got)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Error("a context without a budget should be unlimited")
+	}
+	b := NewBudget(1)
+	if FromContext(NewContext(context.Background(), b)) != b {
+		t.Error("FromContext did not return the budget stored by NewContext")
+	}
+}
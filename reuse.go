@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// dirtyFilesShown is how many dirty paths are listed when a reuse is refused
+const dirtyFilesShown = 10
+
+// reuseClone prepares an existing clone in destDir instead of cloning again:
+// it refuses a clone of another repository than cloneURL and a dirty working
+// tree unless allow_dirty is set, then checks out the feature branch. If a
+// previous run didn't get as far as creating it, it is created from the
+// latest targetBranch. An empty cloneURL skips the origin check and an empty
+// targetBranch starts the branch from the current HEAD, for repositories that
+// were never cloned by roller.
+func reuseClone(ctx context.Context, r runner.Runner, cfg *config.Config, repoPath, destDir, cloneURL, targetBranch string) error {
+	if _, err := os.Stat(filepath.Join(destDir, ".git")); err != nil {
+		return fmt.Errorf("cannot reuse %s for %s: not a git clone", destDir, repoPath)
+	}
+
+	// With basename directories, repos/app may well be another group's app
+	if cloneURL != "" {
+		origin, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"remote", "get-url", "origin"}})
+		if err != nil {
+			return fmt.Errorf("cannot reuse %s for %s: no origin remote: %w", destDir, repoPath, err)
+		}
+		if got := strings.TrimSpace(string(origin)); !sameRemote(got, cloneURL) {
+			return fmt.Errorf("refusing to reuse %s for %s: it is a clone of %s", destDir, repoPath, got)
+		}
+	}
+
+	out, err := r.Output(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: []string{"status", "--porcelain"}})
+	if err != nil {
+		return fmt.Errorf("git status failed in %s: %w", destDir, err)
+	}
+	if dirty := dirtyFiles(out); len(dirty) > 0 {
+		shown := dirty
+		if len(shown) > dirtyFilesShown {
+			shown = shown[:dirtyFilesShown]
+		}
+		if !cfg.AllowDirty {
+			return fmt.Errorf("refusing to reuse %s: %d uncommitted change(s) left from a previous run (%s); commit or discard them, or pass -allow-dirty", destDir, len(dirty), strings.Join(shown, ", "))
+		}
+		log.Printf("⚠️  Warning: Reusing %s with %d uncommitted change(s): %s", destDir, len(dirty), strings.Join(shown, ", "))
+	}
+
+	log.Printf("♻️  Reusing existing clone of %s in %s", repoPath, destDir)
+	args := []string{"checkout", cfg.FeatureBranch}
+	verify := runner.Cmd{Dir: destDir, Name: "git", Args: []string{"rev-parse", "--verify", "--quiet", "refs/heads/" + cfg.FeatureBranch}}
+	if err := r.Run(ctx, verify); err != nil {
+		args = []string{"checkout", "-b", cfg.FeatureBranch}
+		if targetBranch != "" {
+			fetch := runner.Cmd{Dir: destDir, Name: "git", Args: []string{"fetch", "origin", targetBranch}}
+			if err := r.Run(ctx, fetch); err != nil {
+				return fmt.Errorf("git fetch origin %s failed in %s: %w", targetBranch, destDir, err)
+			}
+			args = append(args, "FETCH_HEAD")
+		}
+	}
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: args}); err != nil {
+		return fmt.Errorf("git %s failed in %s: %w", strings.Join(args, " "), destDir, err)
+	}
+	return nil
+}
+
+// sameRemote reports whether two remote URLs name the same repository,
+// ignoring credentials, a trailing ".git" and the case of the host
+func sameRemote(a, b string) bool {
+	return normalizeRemote(a) == normalizeRemote(b)
+}
+
+// normalizeRemote reduces a remote URL to host and path for comparison
+func normalizeRemote(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(strings.TrimRight(remote, "/"), ".git")
+	}
+	return strings.ToLower(u.Host) + strings.TrimSuffix(strings.TrimRight(u.Path, "/"), ".git")
+}
+
+// dirtyFiles returns the paths listed in git status --porcelain output
+func dirtyFiles(porcelain []byte) []string {
+	var files []string
+	for _, line := range strings.Split(string(porcelain), "\n") {
+		if len(line) > 3 {
+			files = append(files, strings.TrimSpace(line[3:]))
+		}
+	}
+	return files
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// runIDGitKey is the git config key holding the run ID inside each clone
+const runIDGitKey = "roller.runId"
+
+// newRunID returns a sortable, unique identifier for this run, combining the
+// start time with a random suffix so concurrent runs never collide
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return now.UTC().Format("20060102T150405.000000000Z")
+	}
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// startRun returns the run ID, generated unless the -run-id flag gave one,
+// and prefixes every subsequent log line with it
+func startRun(flagValue string, now time.Time) string {
+	runID := flagValue
+	if runID == "" {
+		runID = newRunID(now)
+	}
+	log.SetPrefix("[" + runID + "] ")
+	return runID
+}
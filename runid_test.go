@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+func TestRunIDPropagatesToLogs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logs := captureLog(t)
+	t.Cleanup(func() { log.SetPrefix("") })
+
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	if id := startRun("", now); !strings.HasPrefix(id, "20240301T093000Z-") {
+		t.Errorf("generated run ID %q should start with the run's start time", id)
+	}
+	if a, b := newRunID(now), newRunID(now); a == b {
+		t.Errorf("two runs started together share run ID %q", a)
+	}
+	if id := startRun("nightly-42", now); id != "nightly-42" {
+		t.Errorf("got run ID %q, want the -run-id value nightly-42", id)
+	}
+
+	cfg := testConfig()
+	cfg.RunID = "nightly-42"
+	cfg.RecordRunID = true
+	f := &runner.Fake{}
+	var res report.Result
+	if err := cloneAndCreateBranch(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, false, nil, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		t.Fatal("processing logged nothing")
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "[nightly-42] ") {
+			t.Errorf("log line %q lacks the run ID", line)
+		}
+	}
+	var recorded bool
+	for _, c := range f.Calls() {
+		if c.Name == "git" && slices.Equal(c.Args, []string{"config", runIDGitKey, "nightly-42"}) {
+			recorded = true
+		}
+	}
+	if !recorded {
+		t.Errorf("git config %s nightly-42 was never run in the clone", runIDGitKey)
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is a Runner for tests that records every command and answers it with
+// Respond instead of running anything. It is safe for concurrent use.
+type Fake struct {
+	// Respond returns the output and error for a command; a nil Respond
+	// succeeds with no output
+	Respond func(c Cmd) ([]byte, error)
+	// RespondContext is used instead of Respond if set, for commands that
+	// need the context they run under, e.g. to block until it is done
+	RespondContext func(ctx context.Context, c Cmd) ([]byte, error)
+
+	mu    sync.Mutex
+	calls []Cmd
+}
+
+// Run records c and returns Respond's error
+func (f *Fake) Run(ctx context.Context, c Cmd) error {
+	_, err := f.Output(ctx, c)
+	return err
+}
+
+// Output records c and returns Respond's output and error
+func (f *Fake) Output(ctx context.Context, c Cmd) ([]byte, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, c)
+	f.mu.Unlock()
+	if f.RespondContext != nil {
+		return f.RespondContext(ctx, c)
+	}
+	if f.Respond == nil {
+		return nil, nil
+	}
+	return f.Respond(c)
+}
+
+// Calls returns the commands run so far, in order
+func (f *Fake) Calls() []Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Cmd(nil), f.calls...)
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Multiplexer serializes the output of concurrently running commands onto a
+// single writer, so that lines from different commands never interleave
+type Multiplexer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewMultiplexer returns a multiplexer writing to w
+func NewMultiplexer(w io.Writer) *Multiplexer {
+	return &Multiplexer{w: w}
+}
+
+// Writer returns a writer for one command's output that prefixes every line
+// with prefix. Complete lines are passed on as they arrive; with wholeRun
+// everything is held back until Flush, so a command's output appears as one
+// block. The writer is safe for concurrent use, e.g. as both Cmd.Stdout and
+// Cmd.Stderr.
+func (m *Multiplexer) Writer(prefix string, wholeRun bool) *MuxWriter {
+	return &MuxWriter{m: m, prefix: prefix, wholeRun: wholeRun}
+}
+
+// MuxWriter buffers a command's output for a Multiplexer
+type MuxWriter struct {
+	m        *Multiplexer
+	prefix   string
+	wholeRun bool
+
+	mu  sync.Mutex
+	buf bytes.Buffer // Output not yet passed on
+}
+
+// Write buffers p and, unless the whole run is held back, passes on the
+// complete lines buffered so far
+func (w *MuxWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	if w.wholeRun {
+		return len(p), nil
+	}
+	i := bytes.LastIndexByte(w.buf.Bytes(), '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if err := w.emit(w.buf.Next(i + 1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush passes on everything still buffered, ending an incomplete last line
+func (w *MuxWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	data := w.buf.Bytes()
+	if data[len(data)-1] != '\n' {
+		w.buf.WriteByte('\n')
+	}
+	return w.emit(w.buf.Next(w.buf.Len()))
+}
+
+// emit writes complete lines to the multiplexer in one go, prefixing each
+func (w *MuxWriter) emit(lines []byte) error {
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		i := bytes.IndexByte(lines, '\n')
+		out.WriteString(w.prefix)
+		out.Write(lines[:i+1])
+		lines = lines[i+1:]
+	}
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	_, err := w.m.w.Write(out.Bytes())
+	return err
+}
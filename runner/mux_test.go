@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeInPieces writes n numbered lines for name through w, a few bytes at a
+// time so that lines arrive split across writes
+func writeInPieces(t *testing.T, w *MuxWriter, name string, n int) {
+	for j := 0; j < n; j++ {
+		line := fmt.Sprintf("%s line %d of the output\n", name, j)
+		for len(line) > 0 {
+			k := min(3, len(line))
+			if _, err := w.Write([]byte(line[:k])); err != nil {
+				t.Error(err)
+				return
+			}
+			line = line[k:]
+		}
+	}
+}
+
+func TestMultiplexerLinesDoNotInterleave(t *testing.T) {
+	const workers, lines = 8, 50
+	var out bytes.Buffer
+	m := NewMultiplexer(&out)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		name := fmt.Sprintf("repo%d", i)
+		w := m.Writer("["+name+"] ", false)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writeInPieces(t, w, name, lines)
+			if err := w.Flush(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	next := make(map[string]int)
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	for _, line := range got {
+		var prefix, name string
+		var j int
+		if _, err := fmt.Sscanf(line, "%s %s line %d of the output", &prefix, &name, &j); err != nil || prefix != "["+name+"]" || j != next[name] {
+			t.Fatalf("mangled or out-of-order line %q", line)
+		}
+		next[name]++
+	}
+	if len(got) != workers*lines {
+		t.Errorf("got %d lines, want %d", len(got), workers*lines)
+	}
+}
+
+func TestMultiplexerWholeRun(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMultiplexer(&out)
+	a, b := m.Writer("a: ", true), m.Writer("b: ", false)
+	a.Write([]byte("first\nsec"))
+	b.Write([]byte("other\n"))
+	a.Write([]byte("ond"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "b: other\na: first\na: second\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,118 @@
+// Package runner executes external commands behind an interface so callers
+// can substitute a fake in place of real git and ansible invocations.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Cmd describes a single external command invocation
+type Cmd struct {
+	Dir  string   // Working directory; empty means the current directory
+	Env  []string // Extra KEY=VALUE entries added to the inherited environment
+	Name string
+	Args []string
+
+	// Where Run sends the command's output instead of the runner's own
+	// writers, if set
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// String returns the command line, suitable for logs and error messages
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs commands
+type Runner interface {
+	// Run executes the command, streaming its output
+	Run(ctx context.Context, c Cmd) error
+	// Output executes the command and returns its standard output
+	Output(ctx context.Context, c Cmd) ([]byte, error)
+}
+
+// Error is returned when a command fails. It keeps the command's standard
+// error so callers can inspect why it failed.
+type Error struct {
+	Cmd    Cmd
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Stderr returns the captured standard error of a failed command, or an
+// empty string if err did not come from a Runner
+func Stderr(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Stderr
+	}
+	return ""
+}
+
+// Exec runs commands as real subprocesses
+type Exec struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// New returns an Exec that streams command output to the process's own
+// stdout and stderr
+func New() *Exec {
+	return &Exec{Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+func (r *Exec) command(ctx context.Context, c Cmd) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	return cmd
+}
+
+// Run executes the command, streaming stdout and stderr while also keeping
+// a copy of stderr for the returned error
+func (r *Exec) Run(ctx context.Context, c Cmd) error {
+	stdout, stderrOut := r.Stdout, r.Stderr
+	if c.Stdout != nil {
+		stdout = c.Stdout
+	}
+	if c.Stderr != nil {
+		stderrOut = c.Stderr
+	}
+	var stderr bytes.Buffer
+	cmd := r.command(ctx, c)
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderrOut, &stderr)
+	if err := cmd.Run(); err != nil {
+		return &Error{Cmd: c, Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// Output executes the command and returns its standard output
+func (r *Exec) Output(ctx context.Context, c Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := r.command(ctx, c)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, &Error{Cmd: c, Stderr: stderr.String(), Err: err}
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// scopeRequirement is a token scope an operation of the run needs. Broader
+// scopes that imply it are accepted as well.
+type scopeRequirement struct {
+	Operation string
+	Scope     string
+}
+
+// impliedBy lists, for each scope, the broader scopes that also grant it
+var impliedBy = map[string][]string{
+	"read_api":         {"api"},
+	"read_repository":  {"write_repository", "api"},
+	"write_repository": {"api"},
+}
+
+// requiredScopes returns the scopes the configured run needs
+func requiredScopes(cfg *config.Config, discover, runAnsible, deleteBranches bool) []scopeRequirement {
+	reqs := []scopeRequirement{{"Reading projects", "read_api"}}
+	switch {
+	case deleteBranches:
+		reqs = append(reqs, scopeRequirement{"Branch pruning", "api"})
+	case discover:
+		reqs = append(reqs, scopeRequirement{"Cloning for role detection", "read_repository"})
+	default:
+		reqs = append(reqs, scopeRequirement{"Cloning", "read_repository"})
+		if cfg.CreateBranchViaAPI && !runAnsible {
+			reqs = append(reqs, scopeRequirement{"Creating branches via the API", "api"})
+		}
+		if cfg.CreateMergeRequest && runAnsible {
+			reqs = append(reqs,
+				scopeRequirement{"Pushing the feature branch", "write_repository"},
+				scopeRequirement{"MR creation", "api"})
+		}
+	}
+	return reqs
+}
+
+// hasScope reports whether scopes grants scope directly or through a broader
+// scope
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+		for _, broader := range impliedBy[scope] {
+			if s == broader {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTokenScopes fails when the token lacks a scope the run needs, or is
+// rejected outright. Tokens whose scopes can't be read (e.g. OAuth or job
+// tokens, answered with 403 or 404) are let through with a warning, since
+// GitLab will reject anything they aren't allowed to do anyway.
+func checkTokenScopes(ctx context.Context, client *gitlab.Client, reqs []scopeRequirement) error {
+	info, err := gitlab.FetchTokenInfo(ctx, client)
+	var apiErr *gitlab.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound {
+			log.Printf("⚠️  Warning: Could not read the token's scopes (%d), skipping the scope check", apiErr.StatusCode)
+			return nil
+		}
+		if apiErr.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("GITLAB_TOKEN was rejected as invalid or expired: %w", err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read token scopes: %w", err)
+	}
+
+	var errs []error
+	for _, req := range reqs {
+		if !hasScope(info.Scopes, req.Scope) {
+			errs = append(errs, fmt.Errorf("%s requires '%s' scope but token only has '%s'", req.Operation, req.Scope, strings.Join(info.Scopes, "', '")))
+		}
+	}
+	return errors.Join(errs...)
+}
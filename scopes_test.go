@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// tokenServer returns a client for a fake GitLab answering the token lookup
+// with status and body
+func tokenServer(t *testing.T, status int, body string) *gitlab.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/personal_access_tokens/self" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return gitlab.NewClient(&config.Config{GitlabURL: srv.URL}, "test-token")
+}
+
+func TestCheckTokenScopesInsufficientScope(t *testing.T) {
+	client := tokenServer(t, http.StatusOK, `{"scopes": ["read_api", "read_repository"]}`)
+	cfg := &config.Config{CreateMergeRequest: true}
+
+	err := checkTokenScopes(context.Background(), client, requiredScopes(cfg, false, true, false))
+	if err == nil {
+		t.Fatal("expected read_api to be rejected for MR creation")
+	}
+	if want := "MR creation requires 'api' scope but token only has 'read_api', 'read_repository'"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to contain %q", err, want)
+	}
+}
+
+func TestCheckTokenScopesSufficientScope(t *testing.T) {
+	client := tokenServer(t, http.StatusOK, `{"scopes": ["api"]}`)
+	cfg := &config.Config{CreateMergeRequest: true}
+
+	if err := checkTokenScopes(context.Background(), client, requiredScopes(cfg, false, true, false)); err != nil {
+		t.Errorf("api should imply every required scope: %v", err)
+	}
+}
+
+func TestCheckTokenScopesUnreadableScopes(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		client := tokenServer(t, status, `{"message": "nope"}`)
+		if err := checkTokenScopes(context.Background(), client, requiredScopes(&config.Config{}, true, false, false)); err != nil {
+			t.Errorf("status %d: scope check should be skipped, got %v", status, err)
+		}
+	}
+}
+
+func TestCheckTokenScopesRejectedToken(t *testing.T) {
+	client := tokenServer(t, http.StatusUnauthorized, `{"message": "401 Unauthorized"}`)
+	err := checkTokenScopes(context.Background(), client, requiredScopes(&config.Config{}, true, false, false))
+	if err == nil || !strings.Contains(err.Error(), "invalid or expired") {
+		t.Errorf("expected an invalid token error, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+// webhookPath is where -serve accepts GitLab webhooks
+const webhookPath = "/webhook"
+
+// webhookQueueSize is how many pushed projects may wait for a worker before
+// further events are turned away
+const webhookQueueSize = 100
+
+// pushEvent is the subset of the GitLab push event payload used by -serve
+type pushEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+// deletedRef is the "after" commit of a push that deleted the branch
+const deletedRef = "0000000000000000000000000000000000000000"
+
+// webhookServer turns GitLab push events into queued projects. Only pushes
+// to a project's target branch are acted on, so the feature branches roller
+// pushes itself never trigger another run. A project is queued at most once
+// until its processing has finished.
+type webhookServer struct {
+	cfg     *config.Config
+	client  *gitlab.Client
+	filter  gitlab.ProjectFilter // Applied to projects of the discovery groups, as discovery would
+	secret  string
+	queue   chan config.RepoSpec
+	mu      sync.Mutex
+	pending map[string]bool // Projects queued or being processed
+}
+
+func newWebhookServer(cfg *config.Config, client *gitlab.Client, secret string) *webhookServer {
+	return &webhookServer{
+		cfg:     cfg,
+		client:  client,
+		filter:  gitlab.NewProjectFilter(cfg),
+		secret:  secret,
+		queue:   make(chan config.RepoSpec, webhookQueueSize),
+		pending: make(map[string]bool),
+	}
+}
+
+// ServeHTTP validates the webhook secret token and queues the pushed
+// project if the event matches
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Gitlab-Token")), []byte(s.secret)) != 1 {
+		http.Error(w, "invalid webhook token", http.StatusUnauthorized)
+		return
+	}
+	if event := req.Header.Get("X-Gitlab-Event"); event != "Push Hook" {
+		http.Error(w, "ignored: not a push event", http.StatusAccepted)
+		return
+	}
+
+	var ev pushEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&ev); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	proj, ok, err := s.match(req.Context(), ev)
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not look up %s after a push: %v", ev.Project.PathWithNamespace, err)
+		http.Error(w, "could not look up project", http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		http.Error(w, "ignored: no matching project or branch", http.StatusAccepted)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[proj.RepoPath] {
+		http.Error(w, "already queued", http.StatusAccepted)
+		return
+	}
+	select {
+	case s.queue <- proj:
+		s.pending[proj.RepoPath] = true
+		log.Printf("📬 Queued %s after a push to %s", proj.RepoPath, strings.TrimPrefix(ev.Ref, "refs/heads/"))
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "queue is full", http.StatusServiceUnavailable)
+	}
+}
+
+// match returns the project a push event is for, if it is a configured
+// project or one inside the discovery groups or user, and the push updated
+// its target branch. Projects of the discovery groups are looked up and must
+// pass the discovery filters, so archived, forked, oversized or otherwise
+// filtered projects aren't processed just because they were pushed to.
+func (s *webhookServer) match(ctx context.Context, ev pushEvent) (config.RepoSpec, bool, error) {
+	path := ev.Project.PathWithNamespace
+	if ev.ObjectKind != "push" || path == "" || ev.After == deletedRef {
+		return config.RepoSpec{}, false, nil
+	}
+
+	proj, ok, discovered := config.RepoSpec{}, false, false
+	for _, p := range s.cfg.Projects {
+		if p.RepoPath == path {
+			proj, ok = p, true
+			break
+		}
+	}
+	if !ok {
+		namespaces := s.cfg.DiscoveryGroups()
+		if user := s.cfg.DiscoveryUser(); user != "" {
+			namespaces = []string{user}
+		}
+		for _, ns := range namespaces {
+			if strings.HasPrefix(path, ns+"/") {
+				proj, ok, discovered = s.cfg.ApplyGroupDefaults(config.RepoSpec{RepoPath: path, SourceGroup: ns}), true, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return config.RepoSpec{}, false, nil
+	}
+
+	target := s.cfg.TargetBranch
+	if proj.TargetBranch != "" {
+		target = proj.TargetBranch
+	}
+	if ev.Ref != "refs/heads/"+target {
+		return config.RepoSpec{}, false, nil
+	}
+	if !discovered {
+		return proj, true, nil
+	}
+
+	found, keep, err := gitlab.FetchProject(ctx, s.client, path, proj.SourceGroup, s.filter)
+	if err != nil || !keep {
+		return config.RepoSpec{}, false, err
+	}
+	proj.Visibility, proj.EmptyRepo, proj.Forked = found.Visibility, found.EmptyRepo, found.Forked
+	return proj, true, nil
+}
+
+// done lets a project be queued again
+func (s *webhookServer) done(repoPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, repoPath)
+}
+
+// serveWebhooks listens on addr for GitLab push webhooks and runs every
+// queued project through processProject with cfg.Concurrency workers until
+// ctx is done. Each project's clone is removed once it is processed, so the
+// next push starts from a fresh clone, unless on_existing is "reuse" or the
+// directory existed beforehand.
+func serveWebhooks(ctx context.Context, r runner.Runner, client *gitlab.Client, cfg *config.Config, addr, secret string, runAnsible bool) error {
+	s := newWebhookServer(cfg, client, secret)
+
+	workers := cfg.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for proj := range s.queue {
+				// A directory that was there before is never ours to remove
+				dir := cloneDestDir(cfg, proj.RepoPath)
+				_, statErr := os.Stat(dir)
+				res := processProject(ctx, r, client, cfg, proj, runAnsible, nil)
+				if res.Status == report.StatusFailed {
+					log.Printf("❌ %s: %s", res.RepoPath, res.Error)
+				}
+				if statErr != nil && cfg.OnExisting != config.OnExistingReuse {
+					if err := os.RemoveAll(dir); err != nil {
+						log.Printf("⚠️  Warning: Failed to remove clone of %s: %v", proj.RepoPath, err)
+					}
+				}
+				s.done(proj.RepoPath)
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, s)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	log.Printf("👂 Listening for GitLab push webhooks on %s%s", addr, webhookPath)
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		log.Printf("🛑 Shutting down the webhook server")
+		err = srv.Shutdown(context.Background())
+	}
+
+	// No more events can arrive once the server is down; let the workers drain the queue
+	s.mu.Lock()
+	close(s.queue)
+	s.mu.Unlock()
+	wg.Wait()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+)
+
+// samplePushEvent is a trimmed GitLab push event to ref of project
+func samplePushEvent(project, ref string) string {
+	return `{
+  "object_kind": "push",
+  "event_name": "push",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "ref": "` + ref + `",
+  "user_username": "jsmith",
+  "project": {
+    "name": "` + project[strings.LastIndex(project, "/")+1:] + `",
+    "path_with_namespace": "` + project + `",
+    "default_branch": "main"
+  },
+  "total_commits_count": 1
+}`
+}
+
+// webhookTestServer returns a webhook server for the team group of a fake
+// GitLab, on which team/old is archived and team/fork is a fork
+func webhookTestServer(t *testing.T) *webhookServer {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/team/app":
+			w.Write([]byte(`{"path_with_namespace": "team/app", "visibility": "internal"}`))
+		case "/api/v4/projects/team/old":
+			w.Write([]byte(`{"path_with_namespace": "team/old", "archived": true}`))
+		case "/api/v4/projects/team/fork":
+			w.Write([]byte(`{"path_with_namespace": "team/fork", "forked_from_project": {"path_with_namespace": "upstream/fork"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	cfg := testConfig()
+	cfg.GitlabURL = api.URL
+	cfg.AutoDiscover = &config.AutoDiscover{Group: "team"}
+	cfg.SkipForks = true
+	return newWebhookServer(cfg, gitlab.NewClient(cfg, "test-token"), "s3cret")
+}
+
+// postPush posts a push event for project and ref to s with token
+func postPush(s *webhookServer, token, project, ref string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(samplePushEvent(project, ref)))
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+	req.Header.Set("X-Gitlab-Token", token)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookQueuesPushedProject(t *testing.T) {
+	s := webhookTestServer(t)
+
+	if rec := postPush(s, "s3cret", "team/app", "refs/heads/main"); rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	select {
+	case proj := <-s.queue:
+		if proj.RepoPath != "team/app" || proj.SourceGroup != "team" || proj.Visibility != "internal" {
+			t.Errorf("queued %+v, want team/app of team", proj)
+		}
+	default:
+		t.Fatal("team/app was not queued")
+	}
+
+	// Already pending until its processing is done
+	if rec := postPush(s, "s3cret", "team/app", "refs/heads/main"); !strings.Contains(rec.Body.String(), "already queued") {
+		t.Errorf("second push: %d %s, want it reported as already queued", rec.Code, rec.Body)
+	}
+}
+
+func TestWebhookIgnoresUnmatchedPushes(t *testing.T) {
+	s := webhookTestServer(t)
+	tests := []struct {
+		name, token, project, ref string
+		code                      int
+	}{
+		{"wrong token", "guess", "team/app", "refs/heads/main", http.StatusUnauthorized},
+		{"feature branch", "s3cret", "team/app", "refs/heads/roller-updates", http.StatusAccepted},
+		{"outside the groups", "s3cret", "other/app", "refs/heads/main", http.StatusAccepted},
+		{"archived", "s3cret", "team/old", "refs/heads/main", http.StatusAccepted},
+		{"skipped fork", "s3cret", "team/fork", "refs/heads/main", http.StatusAccepted},
+		{"lookup failure", "s3cret", "team/gone", "refs/heads/main", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if rec := postPush(s, tt.token, tt.project, tt.ref); rec.Code != tt.code {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, rec.Code, strings.TrimSpace(rec.Body.String()), tt.code)
+		}
+	}
+	if n := len(s.queue); n != 0 {
+		t.Errorf("%d projects queued, want none", n)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"roller/config"
+	"roller/runner"
+)
+
+// sparsePaths returns the sparse checkout paths for proj: its own
+// sparse_checkout_paths if set, otherwise the global ones
+func sparsePaths(cfg *config.Config, proj config.RepoSpec) []string {
+	if len(proj.SparseCheckoutPaths) > 0 {
+		return proj.SparseCheckoutPaths
+	}
+	return cfg.SparseCheckoutPaths
+}
+
+// sparseCheckout restricts the clone in destDir, made with --no-checkout, to
+// paths and then checks out branch so only those paths are materialized
+func sparseCheckout(ctx context.Context, r runner.Runner, destDir, branch string, paths, env []string) error {
+	log.Printf("🌱 Sparse checkout of %s in %s", strings.Join(paths, ", "), destDir)
+	args := append([]string{"sparse-checkout", "set"}, paths...)
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Name: "git", Args: args}); err != nil {
+		return fmt.Errorf("git sparse-checkout set failed in %s: %w", destDir, err)
+	}
+	if err := r.Run(ctx, runner.Cmd{Dir: destDir, Env: env, Name: "git", Args: []string{"checkout", branch}}); err != nil {
+		return fmt.Errorf("git checkout %s failed in %s: %w", branch, destDir, err)
+	}
+	return nil
+}
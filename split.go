@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"roller/config"
+)
+
+// splitFileName returns the file name for a source group's export; the
+// slashes of subgroups are replaced so every file lands directly in the
+// output directory
+func splitFileName(group string) string {
+	return strings.ReplaceAll(group, "/", "_") + ".yaml"
+}
+
+// exportSplit writes the projects of each source group to its own file in
+// dir, keeping the order of projects within a group. Each file has the same
+// shape as a single -output export, so it can be used on its own. Groups
+// whose file names collide, like a/b_c and a_b/c, fail the export before
+// anything is written.
+func exportSplit(dir string, projects []config.RepoSpec, opts config.ExportOptions) error {
+	var groups []string
+	byGroup := make(map[string][]config.RepoSpec)
+	for _, proj := range projects {
+		if _, ok := byGroup[proj.SourceGroup]; !ok {
+			groups = append(groups, proj.SourceGroup)
+		}
+		byGroup[proj.SourceGroup] = append(byGroup[proj.SourceGroup], proj)
+	}
+
+	fileGroups := make(map[string]string, len(groups))
+	for _, group := range groups {
+		name := splitFileName(group)
+		if other, ok := fileGroups[name]; ok {
+			return fmt.Errorf("groups %s and %s would both be exported to %s", other, group, name)
+		}
+		fileGroups[name] = group
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create split output directory: %w", err)
+	}
+
+	for _, group := range groups {
+		groupOpts := opts
+		if opts.Metadata != nil {
+			metadata := *opts.Metadata
+			metadata.SourceGroup = group
+			groupOpts.Metadata = &metadata
+		}
+		path := filepath.Join(dir, splitFileName(group))
+		if err := config.ExportDiscoveredProjects(path, byGroup[group], groupOpts); err != nil {
+			return fmt.Errorf("failed to export projects of %s: %w", group, err)
+		}
+		log.Printf("✅ Exported %d projects of %s to %s", len(byGroup[group]), group, path)
+	}
+	return nil
+}
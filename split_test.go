@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"roller/config"
+)
+
+func TestExportSplitWritesOneFilePerGroup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "split")
+	projects := []config.RepoSpec{
+		{RepoPath: "team/app", RoleName: "java", SourceGroup: "team"},
+		{RepoPath: "team/sub/lib", RoleName: "python", SourceGroup: "team/sub"},
+		{RepoPath: "team/tool", RoleName: "node", SourceGroup: "team"},
+	}
+	if err := exportSplit(dir, projects, config.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"team.yaml", "team_sub.yaml"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("files %v, want %v", names, want)
+	}
+
+	want := map[string][]string{
+		"team.yaml":     {"team/app", "team/tool"},
+		"team_sub.yaml": {"team/sub/lib"},
+	}
+	for name, paths := range want {
+		got, err := config.LoadProjectsFile(filepath.Join(dir, name), false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var gotPaths []string
+		for _, p := range got {
+			gotPaths = append(gotPaths, p.RepoPath)
+		}
+		if !reflect.DeepEqual(gotPaths, paths) {
+			t.Errorf("%s lists %v, want %v", name, gotPaths, paths)
+		}
+	}
+}
+
+func TestExportSplitRejectsCollidingGroups(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "split")
+	projects := []config.RepoSpec{
+		{RepoPath: "a/b_c/app", SourceGroup: "a/b_c"},
+		{RepoPath: "a_b/c/lib", SourceGroup: "a_b/c"},
+	}
+	err := exportSplit(dir, projects, config.ExportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "a_b_c.yaml") {
+		t.Fatalf("expected the colliding groups to be refused, got %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written for a refused export: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"roller/runner"
+)
+
+// cloneStatus is the local state of one clone in the workspace
+type cloneStatus struct {
+	Name        string
+	Branch      string
+	Uncommitted int    // Number of changed or untracked paths
+	Unpushed    string // Number of local commits not on origin, or why it's unknown
+}
+
+// workspaceStatus inspects every git clone under workspaceDir without
+// modifying anything. Directories that aren't clones themselves are searched
+// for nested clones, as laid out by preserve_namespace.
+func workspaceStatus(ctx context.Context, r runner.Runner, workspaceDir string) ([]cloneStatus, error) {
+	clones, err := findClones(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace %s: %w", workspaceDir, err)
+	}
+
+	var statuses []cloneStatus
+	for _, name := range clones {
+		dir := filepath.Join(workspaceDir, name)
+		st := cloneStatus{Name: filepath.ToSlash(name)}
+
+		out, err := r.Output(ctx, runner.Cmd{Dir: dir, Name: "git", Args: []string{"branch", "--show-current"}})
+		if err != nil {
+			return nil, fmt.Errorf("git branch failed in %s: %w", dir, err)
+		}
+		st.Branch = strings.TrimSpace(string(out))
+
+		out, err = r.Output(ctx, runner.Cmd{Dir: dir, Name: "git", Args: []string{"status", "--porcelain"}})
+		if err != nil {
+			return nil, fmt.Errorf("git status failed in %s: %w", dir, err)
+		}
+		st.Uncommitted = countLines(out)
+
+		if st.Branch == "" {
+			st.Unpushed = "detached"
+		} else if out, err := r.Output(ctx, runner.Cmd{Dir: dir, Name: "git", Args: []string{"log", "--oneline", "origin/" + st.Branch + "..HEAD"}}); err != nil {
+			// No remote-tracking ref: the branch has never been pushed
+			st.Unpushed = "not pushed"
+		} else {
+			st.Unpushed = strconv.Itoa(countLines(out))
+		}
+
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// findClones returns the paths, relative to workspaceDir, of the git clones
+// below it in lexical order, without descending into the clones
+func findClones(workspaceDir string) ([]string, error) {
+	var clones []string
+	err := filepath.WalkDir(workspaceDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || p == workspaceDir {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(p, ".git")); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(workspaceDir, p)
+		if err != nil {
+			return err
+		}
+		clones = append(clones, rel)
+		return filepath.SkipDir
+	})
+	return clones, err
+}
+
+// countLines counts the non-empty lines in out
+func countLines(out []byte) int {
+	n := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// printStatus writes statuses as a table, flagging clones on featureBranch
+func printStatus(w io.Writer, statuses []cloneStatus, featureBranch string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tBRANCH\tFEATURE\tUNCOMMITTED\tUNPUSHED")
+	for _, st := range statuses {
+		feature := "no"
+		if st.Branch == featureBranch {
+			feature = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", st.Name, st.Branch, feature, st.Uncommitted, st.Unpushed)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"roller/runner"
+)
+
+func TestWorkspaceStatus(t *testing.T) {
+	ws := t.TempDir()
+	for _, dir := range []string{"app", "lib", "team/tool", "notes"} {
+		if err := os.MkdirAll(filepath.Join(ws, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, clone := range []string{"app", "lib", "team/tool"} {
+		if err := os.Mkdir(filepath.Join(ws, clone, ".git"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	branches := map[string]string{"app": "feature/bump\n", "lib": "feature/bump\n", "tool": "\n"}
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		name := filepath.Base(c.Dir)
+		switch c.Args[0] {
+		case "branch":
+			return []byte(branches[name]), nil
+		case "status":
+			if name == "app" {
+				return []byte(" M pom.xml\n?? notes.txt\n"), nil
+			}
+			return nil, nil
+		case "log":
+			if name == "lib" {
+				return nil, &runner.Error{Cmd: c, Stderr: "fatal: bad revision 'origin/feature/bump..HEAD'", Err: errors.New("exit status 128")}
+			}
+			return []byte("1a2b3c4 Bump dependencies\n"), nil
+		}
+		return nil, nil
+	}}
+
+	statuses, err := workspaceStatus(context.Background(), f, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cloneStatus{
+		{Name: "app", Branch: "feature/bump", Uncommitted: 2, Unpushed: "1"},
+		{Name: "lib", Branch: "feature/bump", Uncommitted: 0, Unpushed: "not pushed"},
+		{Name: "team/tool", Branch: "", Uncommitted: 0, Unpushed: "detached"},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d clones %+v, want %d", len(statuses), statuses, len(want))
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("clone %d: got %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+	for _, c := range f.Calls() {
+		if c.Args[0] != "branch" && c.Args[0] != "status" && c.Args[0] != "log" {
+			t.Errorf("status should be read-only, ran git %v", c.Args)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := printStatus(&out, statuses, "feature/bump"); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"app        feature/bump  yes      2            1", "team/tool                no       0            detached"} {
+		if !strings.Contains(out.String(), row) {
+			t.Errorf("table lacks row %q:\n%s", row, out.String())
+		}
+	}
+}
@@ -0,0 +1 @@
+(ns app.core)
@@ -0,0 +1,12 @@
+// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Kit",
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-log.git", from: "1.5.3"),
+    ],
+    targets: [
+        .target(name: "Kit", dependencies: [.product(name: "Logging", package: "swift-log")]),
+    ]
+)
@@ -0,0 +1 @@
+public struct Kit {}
@@ -0,0 +1 @@
+import SwiftUI
@@ -0,0 +1,78 @@
+// Package tracing emits OpenTelemetry spans for the major operations of a
+// run. Spans are only exported when an OTLP endpoint is configured through
+// the standard OTEL_* environment variables; otherwise the global no-op
+// tracer is used and spans cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies roller's tracer
+const instrumentationName = "roller"
+
+// Span attribute keys
+const (
+	RepoKey      = attribute.Key("roller.repo.path")
+	RoleKey      = attribute.Key("roller.role")
+	SourceKey    = attribute.Key("roller.source")     // Discovered groups or user
+	RepoCountKey = attribute.Key("roller.repo.count") // Repositories in a batched run
+)
+
+// Enabled reports whether the environment asks for spans to be exported:
+// an OTLP endpoint is set and OTEL_TRACES_EXPORTER isn't "none"
+func Enabled() bool {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs an OTLP/HTTP exporter as the global tracer provider when
+// Enabled, configured by the standard OTEL_EXPORTER_OTLP_* variables and
+// OTEL_SERVICE_NAME (default "roller"). The returned function flushes and
+// stops the exporter; it does nothing when tracing is disabled.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(instrumentationName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+	"roller/tracing"
+)
+
+// recordSpans installs an in-memory span recorder as the global tracer
+// provider for the rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+// spanAttrs returns the attributes of each ended span by span name
+func spanAttrs(rec *tracetest.SpanRecorder) map[string]map[attribute.Key]string {
+	spans := make(map[string]map[attribute.Key]string)
+	for _, s := range rec.Ended() {
+		attrs := make(map[attribute.Key]string)
+		for _, kv := range s.Attributes() {
+			attrs[kv.Key] = kv.Value.Emit()
+		}
+		spans[s.Name()] = attrs
+	}
+	return spans
+}
+
+// detectingRunner succeeds at everything and reports role for the role detector
+func detectingRunner(role string) *runner.Fake {
+	return &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "detect-role" {
+			return []byte(role), nil
+		}
+		return nil, nil
+	}}
+}
+
+func TestSpansForRepoPhases(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rec := recordSpans(t)
+	cfg := testConfig()
+	cfg.RoleDetectorCommand = "detect-role"
+
+	res := processProject(context.Background(), detectingRunner("java"), gitlab.NewClient(cfg, "test-token"), cfg, config.RepoSpec{RepoPath: "team/app"}, true, nil)
+	if res.Status != report.StatusSuccess {
+		t.Fatalf("result %s: %s", res.Status, res.Error)
+	}
+
+	spans := spanAttrs(rec)
+	for _, name := range []string{"repo", "clone", "branch", "ansible"} {
+		attrs, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span in %v", name, spans)
+			continue
+		}
+		if got := attrs[tracing.RepoKey]; got != "team/app" {
+			t.Errorf("%s span has repo %q, want team/app", name, got)
+		}
+	}
+	if got := spans["ansible"][tracing.RoleKey]; got != "java" {
+		t.Errorf("ansible span has role %q, want java", got)
+	}
+}
+
+func TestSpanForDiscovery(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rec := recordSpans(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"path_with_namespace": "team/app"}]`))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := testConfig()
+	cfg.GitlabURL = srv.URL
+	cfg.AutoDiscover = &config.AutoDiscover{Group: "team"}
+	cfg.RoleDetectorCommand = "detect-role"
+
+	opts := discoverOptions{OutputPath: filepath.Join(t.TempDir(), "discovered.yaml")}
+	if err := discoverAndExportProjects(context.Background(), detectingRunner("java"), gitlab.NewClient(cfg, "test-token"), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := spanAttrs(rec)
+	if got := spans["discovery"][tracing.SourceKey]; got != cfg.DiscoverySource() {
+		t.Errorf("discovery span has source %q, want %q (spans: %v)", got, cfg.DiscoverySource(), spans)
+	}
+	// Role detection clones are traced too
+	if got := spans["clone"][tracing.RepoKey]; got != "team/app" {
+		t.Errorf("discovery clone span has repo %q, want team/app", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"roller/config"
+)
+
+// reposDir is the base directory all clones are created under
+const reposDir = "repos"
+
+// cloneDestDir returns the directory repoPath is cloned into for processing
+func cloneDestDir(cfg *config.Config, repoPath string) string {
+	return filepath.Join(reposDir, cloneDirName(cfg, repoPath))
+}
+
+// existingClones returns the clone directories of projects that already exist
+func existingClones(cfg *config.Config, projects []config.RepoSpec) []string {
+	var existing []string
+	for _, proj := range projects {
+		dir := cloneDestDir(cfg, proj.RepoPath)
+		if _, err := os.Stat(dir); err == nil {
+			existing = append(existing, dir)
+		}
+	}
+	return existing
+}
+
+// cleanupTempDir removes a temporary directory according to temp_retention:
+// always, only when nothing failed, or never
+func cleanupTempDir(cfg *config.Config, dir string, failed bool) {
+	switch {
+	case cfg.TempRetention == config.TempRetentionKeep:
+		log.Printf("🗂️  Keeping temp directory %s (temp_retention: %s)", dir, cfg.TempRetention)
+		return
+	case cfg.TempRetention == config.TempRetentionKeepOnFailure && failed:
+		log.Printf("🗂️  Keeping temp directory %s for debugging since some repositories failed", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("⚠️  Warning: Failed to remove temp directory %s: %v", dir, err)
+	}
+}
+
+// dirClaims hands out clone directories to concurrent workers so that no two
+// repositories of a run ever share, remove or overwrite the same directory
+type dirClaims struct {
+	mu    sync.Mutex
+	owner map[string]string // clone directory → repository path
+}
+
+func newDirClaims() *dirClaims {
+	return &dirClaims{owner: make(map[string]string)}
+}
+
+// claim reserves dir for repoPath. It fails when another repository of the
+// run already holds dir, e.g. same-named repositories in different groups
+// with dir_naming set to "basename".
+func (d *dirClaims) claim(dir, repoPath string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if other, ok := d.owner[dir]; ok && other != repoPath {
+		return fmt.Errorf("clone directory %s is already used by %s in this run (set dir_naming: %s or preserve_namespace to avoid collisions)", dir, other, config.DirNamingFullPath)
+	}
+	d.owner[dir] = repoPath
+	return nil
+}
+
+// workspaceSize returns the total size in bytes of the files under dir, or
+// zero if dir doesn't exist yet
+func workspaceSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// workspaceFull reports whether the clones under reposDir already use up
+// max_workspace_size_mb, returning the megabytes in use
+func workspaceFull(cfg *config.Config) (bool, int64, error) {
+	if cfg.MaxWorkspaceSizeMB <= 0 {
+		return false, 0, nil
+	}
+	size, err := workspaceSize(reposDir)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to measure workspace %s: %w", reposDir, err)
+	}
+	usedMB := size / (1 << 20)
+	return usedMB >= int64(cfg.MaxWorkspaceSizeMB), usedMB, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"roller/config"
+	"roller/gitlab"
+	"roller/report"
+	"roller/runner"
+)
+
+func TestConcurrentClonesUseDistinctDirs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.DirNaming = config.DirNamingFullPath
+	client := gitlab.NewClient(cfg, "test-token")
+	// Each clone creates its directory the way git does and leaves a marker naming its repository
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name == "git" && c.Args[0] == "clone" {
+			dest := c.Args[len(c.Args)-1]
+			if err := os.MkdirAll(filepath.Join(dest, ".git"), 0o755); err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(filepath.Join(dest, "origin"), []byte(c.Args[len(c.Args)-2]), 0o644)
+		}
+		return nil, nil
+	}}
+
+	const workers = 32
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every repository has the same name, in its own group
+			proj := config.RepoSpec{RepoPath: fmt.Sprintf("group-%d/app", i)}
+			var res report.Result
+			errs[i] = cloneAndCreateBranch(context.Background(), f, client, cfg, proj, false, nil, &res)
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, err := range errs {
+		repoPath := fmt.Sprintf("group-%d/app", i)
+		if err != nil {
+			t.Errorf("%s: %v", repoPath, err)
+			continue
+		}
+		dir := cloneDestDir(cfg, repoPath)
+		if seen[dir] {
+			t.Errorf("%s shares clone directory %s with another repository", repoPath, dir)
+		}
+		seen[dir] = true
+		origin, err := os.ReadFile(filepath.Join(dir, "origin"))
+		if err != nil {
+			t.Errorf("%s: %v", repoPath, err)
+		} else if want := client.RepoCloneURL(repoPath); string(origin) != want {
+			t.Errorf("%s was cloned from %s, want %s", dir, origin, want)
+		}
+	}
+	if entries, err := os.ReadDir(reposDir); err != nil || len(entries) != workers {
+		t.Errorf("%s holds %d entries (%v), want %d clones", reposDir, len(entries), err, workers)
+	}
+}
+
+func TestFullPathNamingSeparatesSameNamedRepos(t *testing.T) {
+	cfg := groupServer(t, http.StatusOK, `[{"path_with_namespace": "team/group-a/api"}, {"path_with_namespace": "team/group-b/api"}]`)
+	cfg.DirNaming = config.DirNamingFullPath
+
+	a, b := cloneDestDir(cfg, "team/group-a/api"), cloneDestDir(cfg, "team/group-b/api")
+	if a == b {
+		t.Errorf("both repositories are cloned into %s", a)
+	}
+
+	// Discovery clones into its temp directory under the same names
+	f := &runner.Fake{}
+	if _, _, err := discoverProjects(context.Background(), f, gitlab.NewClient(cfg, "test-token"), cfg); err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, c := range f.Calls() {
+		if c.Name == "git" && slices.Contains(c.Args, "clone") {
+			dirs = append(dirs, filepath.Base(c.Args[len(c.Args)-1]))
+		}
+	}
+	if want := []string{"team__group-a__api", "team__group-b__api"}; !slices.Equal(dirs, want) {
+		t.Errorf("discovery cloned into %v, want %v", dirs, want)
+	}
+}
+
+func TestCleanupTempDir(t *testing.T) {
+	tests := []struct {
+		retention string
+		failed    bool
+		wantKept  bool
+	}{
+		{"", false, false},
+		{"", true, false},
+		{config.TempRetentionAlwaysClean, true, false},
+		{config.TempRetentionKeepOnFailure, false, false},
+		{config.TempRetentionKeepOnFailure, true, true},
+		{config.TempRetentionKeep, false, true},
+		{config.TempRetentionKeep, true, true},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(t.TempDir(), "roller-tmp")
+		if err := os.MkdirAll(filepath.Join(dir, "meta"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		cleanupTempDir(&config.Config{TempRetention: tt.retention}, dir, tt.failed)
+		_, err := os.Stat(dir)
+		if kept := err == nil; kept != tt.wantKept {
+			t.Errorf("temp_retention %q, failed %t: kept %t, want %t", tt.retention, tt.failed, kept, tt.wantKept)
+		}
+	}
+}
+
+func TestMaxWorkspaceSizeSkipsClones(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := testConfig()
+	cfg.MaxWorkspaceSizeMB = 2
+	client := gitlab.NewClient(cfg, "test-token")
+	// Each clone takes 1.5 MB, as a sparse file so next to nothing is written
+	f := &runner.Fake{Respond: func(c runner.Cmd) ([]byte, error) {
+		if c.Name != "git" || c.Args[0] != "clone" {
+			return nil, nil
+		}
+		dest := c.Args[len(c.Args)-1]
+		if err := os.MkdirAll(filepath.Join(dest, ".git"), 0o755); err != nil {
+			return nil, err
+		}
+		file, err := os.Create(filepath.Join(dest, "assets.bin"))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return nil, file.Truncate(3 << 19)
+	}}
+
+	// 0 MB and then 1 MB are in use before the first two clones, 3 MB before the third
+	var cloned []string
+	for _, repo := range []string{"team/app", "team/lib", "team/tool"} {
+		var res report.Result
+		if err := cloneAndCreateBranch(context.Background(), f, client, cfg, config.RepoSpec{RepoPath: repo}, false, nil, &res); err != nil {
+			t.Fatalf("%s: %v", repo, err)
+		}
+		if res.Status == report.StatusSkipped {
+			if res.Reason != "workspace full (3 MB of max_workspace_size_mb 2)" {
+				t.Errorf("%s: skip reason %q", repo, res.Reason)
+			}
+			continue
+		}
+		cloned = append(cloned, repo)
+	}
+	if want := []string{"team/app", "team/lib"}; !slices.Equal(cloned, want) {
+		t.Errorf("cloned %v, want %v", cloned, want)
+	}
+	for _, c := range f.Calls() {
+		if c.Args[0] == "clone" && filepath.Base(c.Args[len(c.Args)-1]) == "tool" {
+			t.Error("the clone that would exceed the limit was started")
+		}
+	}
+}